@@ -0,0 +1,218 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+var accountColumns = []string{"ID", "NAME", "CURRENCY", "BALANCE", "PRIMARY", "TYPE"}
+
+func accountRow(a *coinbase.Account) []string {
+	balance := "-"
+	if a.Balance != nil {
+		balance = formatFloat(float64(a.Balance.Amount))
+	}
+	return []string{a.ID, a.Name, a.Currency, balance, fmt.Sprintf("%v", a.Primary), a.Type}
+}
+
+func (c *cli) emitAccount(a *coinbase.Account) error {
+	t := &table{header: accountColumns}
+	t.add(accountRow(a)...)
+	return c.emit(a, t)
+}
+
+func (c *cli) accounts(args []string) error {
+	client, err := c.authClient()
+	if err != nil {
+		return err
+	}
+	return subcommand(args, map[string]func([]string) error{
+		"list": func(args []string) error {
+			fs := newFlagSet("accounts list")
+			perPage := fs.Int64("per-page", 0, "the number of accounts per page")
+			maxPage := fs.Int64("max-page", 0, "the most pages to fetch, all by default")
+			if _, err := positional(fs, args); err != nil {
+				return err
+			}
+			res, err := client.ListAccounts(&coinbase.AccountsRequest{
+				AccountsPerPage:    *perPage,
+				MaxPage:            *maxPage,
+				ThrottleDurationMs: coinbase.NoThrottle,
+			})
+			if err != nil {
+				return err
+			}
+			accounts := []*coinbase.Account{}
+			for page := range res.PagesChan {
+				if page.Err != nil {
+					return page.Err
+				}
+				accounts = append(accounts, page.Accounts...)
+			}
+			t := &table{header: accountColumns}
+			for _, a := range accounts {
+				t.add(accountRow(a)...)
+			}
+			return c.emit(accounts, t)
+		},
+		"show": func(args []string) error {
+			ids, err := positional(newFlagSet("accounts show"), args, "<account-id>")
+			if err != nil {
+				return err
+			}
+			a, err := client.FindAccountByID(ids[0])
+			if err != nil {
+				return err
+			}
+			return c.emitAccount(a)
+		},
+		"create": func(args []string) error {
+			names, err := positional(newFlagSet("accounts create"), args, "<name>")
+			if err != nil {
+				return err
+			}
+			a, err := client.CreateAccount(&coinbase.CreateAccountRequest{Name: names[0]})
+			if err != nil {
+				return err
+			}
+			return c.emitAccount(a)
+		},
+		"rename": func(args []string) error {
+			pargs, err := positional(newFlagSet("accounts rename"), args, "<account-id>", "<name>")
+			if err != nil {
+				return err
+			}
+			a, err := client.UpdateAccount(&coinbase.UpdateAccountRequest{ID: pargs[0], Name: pargs[1]})
+			if err != nil {
+				return err
+			}
+			return c.emitAccount(a)
+		},
+		"delete": func(args []string) error {
+			ids, err := positional(newFlagSet("accounts delete"), args, "<account-id>")
+			if err != nil {
+				return err
+			}
+			if err := client.DeleteAccountByID(ids[0]); err != nil {
+				return err
+			}
+			result := map[string]string{"id": ids[0], "status": "deleted"}
+			t := &table{header: []string{"ID", "STATUS"}}
+			t.add(ids[0], "deleted")
+			return c.emit(result, t)
+		},
+		"primary": func(args []string) error {
+			ids, err := positional(newFlagSet("accounts primary"), args, "<account-id>")
+			if err != nil {
+				return err
+			}
+			a, err := client.SetAccountAsPrimary(ids[0])
+			if err != nil {
+				return err
+			}
+			return c.emitAccount(a)
+		},
+	})
+}
+
+var addressColumns = []string{"ID", "ADDRESS", "NAME", "NETWORK", "CREATED"}
+
+func addressRow(a *coinbase.Address) []string {
+	created := "-"
+	if a.CreatedAt != nil {
+		created = a.CreatedAt.Format(time.RFC3339)
+	}
+	return []string{a.ID, a.Address, string(a.Name), string(a.Network), created}
+}
+
+func (c *cli) addresses(args []string) error {
+	client, err := c.authClient()
+	if err != nil {
+		return err
+	}
+	return subcommand(args, map[string]func([]string) error{
+		"list": func(args []string) error {
+			fs := newFlagSet("addresses list")
+			maxPage := fs.Int64("max-page", 0, "the most pages to fetch, all by default")
+			ids, err := positional(fs, args, "<account-id>")
+			if err != nil {
+				return err
+			}
+			res, err := client.ListAddresses(&coinbase.AddressesRequest{
+				AccountID:          ids[0],
+				MaxPage:            *maxPage,
+				ThrottleDurationMs: coinbase.NoThrottle,
+			})
+			if err != nil {
+				return err
+			}
+			addresses := []*coinbase.Address{}
+			for page := range res.PagesChan {
+				if page.Err != nil {
+					return page.Err
+				}
+				addresses = append(addresses, page.Addresses...)
+			}
+			t := &table{header: addressColumns}
+			for _, a := range addresses {
+				t.add(addressRow(a)...)
+			}
+			return c.emit(addresses, t)
+		},
+		"create": func(args []string) error {
+			fs := newFlagSet("addresses create")
+			name := fs.String("name", "", "the optional label of the address")
+			ids, err := positional(fs, args, "<account-id>")
+			if err != nil {
+				return err
+			}
+			a, err := client.CreateAddress(&coinbase.CreateAddressRequest{AccountID: ids[0], Name: *name})
+			if err != nil {
+				return err
+			}
+			t := &table{header: addressColumns}
+			t.add(addressRow(a)...)
+			return c.emit(a, t)
+		},
+	})
+}
+
+func (c *cli) profile(args []string) error {
+	fs := newFlagSet("profile")
+	id := fs.String("id", "", "the ID of another user's profile, yours by default")
+	if _, err := positional(fs, args); err != nil {
+		return err
+	}
+	client, err := c.authClient()
+	if err != nil {
+		return err
+	}
+	var profile *coinbase.Profile
+	if *id != "" {
+		profile, err = client.FindProfileByID(*id)
+	} else {
+		profile, err = client.MyProfile()
+	}
+	if err != nil {
+		return err
+	}
+	t := &table{header: []string{"ID", "NAME", "USERNAME", "EMAIL", "TIME ZONE", "NATIVE CURRENCY"}}
+	t.add(profile.ID, string(profile.Name), profile.Username, string(profile.Email), string(profile.Timezone), string(profile.NativeCurrency))
+	return c.emit(profile, t)
+}
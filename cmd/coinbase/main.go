@@ -0,0 +1,190 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+const usage = `Usage: coinbase [-o table|json] <command> [<subcommand>] [flags] [args]
+
+Commands:
+  accounts list|show|create|rename|delete|primary
+  addresses list|create
+  profile
+  rates
+  ticker
+  order place|cancel|list
+  candles
+  watch
+
+Credentials are read from the COINBASE_API_KEY, COINBASE_API_SECRET
+and COINBASE_API_PASSPHRASE environment variables. Run a command
+with -h for its flags.
+`
+
+type cli struct {
+	format string
+	out    io.Writer
+
+	// client is only set up when a command first needs it.
+	client *coinbase.Client
+
+	// anonClient is used by the public commands
+	// when there are no credentials to set up client.
+	anonClient *coinbase.Client
+}
+
+type command func(c *cli, args []string) error
+
+var commands = map[string]command{
+	"accounts":  (*cli).accounts,
+	"addresses": (*cli).addresses,
+	"profile":   (*cli).profile,
+	"rates":     (*cli).rates,
+	"ticker":    (*cli).ticker,
+	"order":     (*cli).order,
+	"candles":   (*cli).candles,
+	"watch":     (*cli).watch,
+}
+
+func main() {
+	log.SetFlags(0)
+	c := &cli{out: os.Stdout}
+	flag.StringVar(&c.format, "o", "table", "the output format: table or json")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if c.format != "table" && c.format != "json" {
+		log.Fatalf("-o: unknown output format %q, expecting table or json", c.format)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		log.Fatalf("unknown command %q\n\n%s", args[0], usage)
+	}
+	if err := cmd(c, args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		log.Fatalf("%s: %v", args[0], err)
+	}
+}
+
+// authClient returns a client for the commands that need credentials.
+func (c *cli) authClient() (*coinbase.Client, error) {
+	if c.client == nil {
+		client, err := coinbase.NewDefaultClient()
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+// publicClient returns a client for the commands that only hit
+// public endpoints, which work without credentials too.
+func (c *cli) publicClient() *coinbase.Client {
+	if client, err := c.authClient(); err == nil {
+		return client
+	}
+	// The credential-less client is kept apart so
+	// that authClient never returns it.
+	if c.anonClient == nil {
+		c.anonClient = new(coinbase.Client)
+	}
+	return c.anonClient
+}
+
+var errSubcommand = errors.New("expecting a subcommand")
+
+// subcommand dispatches args[0] to one of subcommands.
+func subcommand(args []string, subcommands map[string]func(args []string) error) error {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		return fmt.Errorf("%v: one of %s", errSubcommand, strings.Join(names, ", "))
+	}
+	fn, ok := subcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown subcommand %q, expecting one of %s", args[0], strings.Join(names, ", "))
+	}
+	return fn(args[1:])
+}
+
+// newFlagSet returns a FlagSet whose errors are returned rather than fatal.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// positional parses fs and checks that it has n positional arguments.
+func positional(fs *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != len(names) {
+		return nil, fmt.Errorf("%s: expecting arguments: %s", fs.Name(), strings.Join(names, " "))
+	}
+	return fs.Args(), nil
+}
+
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(row ...string) {
+	t.rows = append(t.rows, row)
+}
+
+// emit writes v as JSON or t as a table, per the output format.
+func (c *cli) emit(v interface{}, t *table) error {
+	if c.format == "json" {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%.8g", f)
+}
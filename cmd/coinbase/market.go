@@ -0,0 +1,224 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+func (c *cli) rates(args []string) error {
+	fs := newFlagSet("rates")
+	from := fs.String("currency", "BTC", "the currency to convert from, optionally with the currencies to convert to e.g. BTC-USD-EUR")
+	if _, err := positional(fs, args); err != nil {
+		return err
+	}
+	res, err := c.publicClient().ExchangeRate(coinbase.Currency(*from))
+	if err != nil {
+		return err
+	}
+	var currencies []string
+	for currency := range res.Rates {
+		currencies = append(currencies, string(currency))
+	}
+	sort.Strings(currencies)
+	t := &table{header: []string{"FROM", "TO", "RATE"}}
+	for _, currency := range currencies {
+		t.add(string(res.From), currency, formatFloat(float64(res.Rates[coinbase.Currency(currency)])))
+	}
+	for _, currency := range res.Missing {
+		t.add(string(res.From), string(currency), "-")
+	}
+	return c.emit(res, t)
+}
+
+func (c *cli) ticker(args []string) error {
+	fs := newFlagSet("ticker")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	products := fs.Args()
+	if len(products) == 0 {
+		products = []string{"BTC-USD"}
+	}
+	tickers, err := c.publicClient().Tickers(products...)
+	if len(tickers) == 0 && err != nil {
+		return err
+	}
+	t := &table{header: []string{"PRODUCT", "PRICE", "BID", "ASK", "VOLUME", "TIME"}}
+	for _, product := range products {
+		tick, ok := tickers[product]
+		if !ok {
+			continue
+		}
+		at := "-"
+		if tick.Time != nil {
+			at = tick.Time.Format(time.RFC3339)
+		}
+		t.add(product, formatFloat(tick.Price), formatFloat(tick.Bid), formatFloat(tick.Ask), formatFloat(tick.Volume), at)
+	}
+	if eerr := c.emit(tickers, t); eerr != nil {
+		return eerr
+	}
+	// The tickers that could be fetched are printed before reporting the others.
+	return err
+}
+
+func (c *cli) candles(args []string) error {
+	fs := newFlagSet("candles")
+	product := fs.String("product", "BTC-USD", "the product e.g. BTC-USD")
+	durAgo := fs.Duration("dur-ago", time.Hour, "how far back to go, unless -start is set")
+	startStr := fs.String("start", "", "the start time in RFC 3339 or as a date e.g. 2017-09-21")
+	endStr := fs.String("end", "", "the end time in RFC 3339 or as a date, now by default")
+	granularity := fs.Int("granularity", coinbase.GranularityOneMinute, "the candle width in seconds: 60, 300, 900, 3600, 21600 or 86400")
+	if _, err := positional(fs, args); err != nil {
+		return err
+	}
+
+	end := time.Now()
+	if *endStr != "" {
+		var err error
+		if end, err = parseTime("end", *endStr); err != nil {
+			return err
+		}
+	}
+	start := end.Add(-*durAgo)
+	if *startStr != "" {
+		var err error
+		if start, err = parseTime("start", *startStr); err != nil {
+			return err
+		}
+	}
+
+	res, err := c.publicClient().CandleSticks(&coinbase.CandleStickRequest{
+		Product:              *product,
+		StartTime:            start,
+		EndTime:              end,
+		GranularityInSeconds: *granularity,
+		Ordered:              true,
+	})
+	if err != nil {
+		return err
+	}
+	candles := []*coinbase.CandleStick{}
+	for page := range res.PagesChan {
+		if page.Err != nil {
+			return page.Err
+		}
+		candles = append(candles, page.CandleSticks...)
+	}
+	t := &table{header: []string{"TIME", "OPEN", "HIGH", "LOW", "CLOSE", "VOLUME"}}
+	for _, cs := range candles {
+		t.add(cs.Timestamp().Format(time.RFC3339), formatFloat(cs.Open), formatFloat(cs.High),
+			formatFloat(cs.Low), formatFloat(cs.Close), formatFloat(cs.Volume))
+	}
+	return c.emit(candles, t)
+}
+
+// parseTime parses times in RFC 3339 or as plain UTC dates.
+func parseTime(name, value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("-%s: expecting a time like 2017-09-21 or 2017-09-21T18:00:00Z, got %q", name, value)
+}
+
+// watch streams the websocket feed until interrupted, as a line
+// per message in table output or a JSON object per line otherwise.
+func (c *cli) watch(args []string) error {
+	fs := newFlagSet("watch")
+	products := fs.String("product", "BTC-USD", "comma separated products to watch")
+	types := fs.String("types", "", "comma separated message types to print e.g. match,done; all by default")
+	auth := fs.Bool("auth", false, "authenticate to also receive your own private messages")
+	if _, err := positional(fs, args); err != nil {
+		return err
+	}
+	var client *coinbase.Client
+	if *auth {
+		var err error
+		if client, err = c.authClient(); err != nil {
+			return err
+		}
+	} else {
+		client = c.publicClient()
+	}
+	wanted := make(map[coinbase.Type]bool)
+	for _, typ := range strings.Split(*types, ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			wanted[coinbase.Type(typ)] = true
+		}
+	}
+
+	sub, err := client.Subscribe(&coinbase.Subscription{
+		Authenticate: *auth,
+		Currencies:   strings.Split(*products, ","),
+	})
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
+	enc := json.NewEncoder(c.out)
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	if c.format != "json" {
+		fmt.Fprintln(tw, "TIME\tPRODUCT\tTYPE\tSIDE\tPRICE\tSIZE\tORDER")
+		tw.Flush()
+	}
+	for {
+		select {
+		case <-sigChan:
+			return nil
+		case msg, ok := <-sub.MessagesChan:
+			if !ok {
+				return nil
+			}
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if len(wanted) > 0 && !wanted[msg.Type] {
+				continue
+			}
+			if c.format == "json" {
+				if err := enc.Encode(msg); err != nil {
+					return err
+				}
+				continue
+			}
+			orderID := msg.OrderID
+			if orderID == "" {
+				orderID = msg.MakerOrderID
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", msg.Time.Format(time.RFC3339Nano), msg.ProductID,
+				msg.Type, msg.Side, formatFloat(msg.Price), formatFloat(msg.Size), orderID)
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
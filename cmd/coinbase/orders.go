@@ -0,0 +1,119 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+var orderColumns = []string{"ID", "PRODUCT", "SIDE", "PRICE", "SIZE", "FILLED", "STATUS", "CREATED"}
+
+func orderRow(o *coinbase.OrderResponse) []string {
+	created := "-"
+	if !o.CreatedAt.IsZero() {
+		created = o.CreatedAt.Format(time.RFC3339)
+	}
+	return []string{
+		o.ID, o.ProductID, string(o.Side), formatFloat(o.Price), formatFloat(o.Size),
+		formatFloat(o.FilledSize), string(o.Status), created,
+	}
+}
+
+func (c *cli) order(args []string) error {
+	client, err := c.authClient()
+	if err != nil {
+		return err
+	}
+	return subcommand(args, map[string]func([]string) error{
+		"place": func(args []string) error {
+			fs := newFlagSet("order place")
+			o := new(coinbase.Order)
+			var side, tif, cancelAfter, stp string
+			fs.StringVar(&o.Product, "product", "", "the product to trade e.g. BTC-USD")
+			fs.StringVar(&side, "side", "", "buy or sell")
+			fs.Float64Var(&o.Price, "price", 0, "the limit price")
+			fs.Float64Var(&o.Size, "size", 0, "the amount of the base currency")
+			fs.Float64Var(&o.Funds, "funds", 0, "the amount of the quote currency to spend, for market orders")
+			fs.StringVar(&tif, "tif", "", "the time in force: GTC, GTT, IOC or FOK")
+			fs.StringVar(&cancelAfter, "cancel-after", "", "min, hour or day, for GTT orders")
+			fs.StringVar(&stp, "stp", "", "the self trade prevention: dc, co, cn or cb")
+			fs.BoolVar(&o.PostOnly, "post-only", false, "only add liquidity to the book")
+			fs.StringVar(&o.CustomOrderID, "client-oid", "", "your own UUID for the order")
+			if _, err := positional(fs, args); err != nil {
+				return err
+			}
+			o.Side = coinbase.Side(strings.ToLower(side))
+			o.TimeInForce = coinbase.TimeInForce(strings.ToUpper(tif))
+			o.CancelAfter = coinbase.Period(cancelAfter)
+			o.SelfTradePrevention = coinbase.SelfTradePrevention(stp)
+			res, err := client.Order(o)
+			if err != nil {
+				return err
+			}
+			t := &table{header: orderColumns}
+			t.add(orderRow(res)...)
+			return c.emit(res, t)
+		},
+		"cancel": func(args []string) error {
+			ids, err := positional(newFlagSet("order cancel"), args, "<order-id>")
+			if err != nil {
+				return err
+			}
+			if err := client.CancelOrder(ids[0]); err != nil {
+				return err
+			}
+			result := map[string]string{"id": ids[0], "status": "canceled"}
+			t := &table{header: []string{"ID", "STATUS"}}
+			t.add(ids[0], "canceled")
+			return c.emit(result, t)
+		},
+		"list": func(args []string) error {
+			fs := newFlagSet("order list")
+			product := fs.String("product", "", "only list the orders of this product")
+			statuses := fs.String("status", "", "comma separated statuses e.g. open,pending,done; open orders by default")
+			maxPage := fs.Int64("max-page", 0, "the most pages to fetch, all by default")
+			if _, err := positional(fs, args); err != nil {
+				return err
+			}
+			oreq := &coinbase.OrdersRequest{
+				Product:            *product,
+				MaxPage:            *maxPage,
+				ThrottleDurationMs: coinbase.NoThrottle,
+			}
+			if *statuses != "" {
+				oreq.Statuses = strings.Split(*statuses, ",")
+			}
+			res, err := client.ListOrders(oreq)
+			if err != nil {
+				return err
+			}
+			orders := []*coinbase.OrderResponse{}
+			for page := range res.PagesChan {
+				if page.Err != nil {
+					return page.Err
+				}
+				orders = append(orders, page.Orders...)
+			}
+			t := &table{header: orderColumns}
+			for _, o := range orders {
+				t.add(orderRow(o)...)
+			}
+			return c.emit(orders, t)
+		},
+	})
+}
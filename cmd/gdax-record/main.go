@@ -0,0 +1,148 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/replay"
+)
+
+// The delays between attempts to reconnect to the feed.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+func main() {
+	var productsStr string
+	var maxMB int64
+	var auth bool
+	var statsEvery time.Duration
+	rec := new(replay.FeedRecorder)
+	flag.StringVar(&productsStr, "product", "BTC-USD,ETH-USD,LTC-USD", "the comma separated products to record")
+	flag.StringVar(&rec.Dir, "dir", "recordings", "the directory to write the recordings to")
+	flag.StringVar(&rec.Prefix, "prefix", "feed", "the prefix of the recordings' file names")
+	flag.Int64Var(&maxMB, "max-size", 100, "the most uncompressed megabytes per file, 0 for no limit")
+	flag.DurationVar(&rec.MaxDuration, "max-age", time.Hour, "the longest time span per file, 0 for no limit")
+	flag.DurationVar(&rec.FlushInterval, "flush", time.Second, "how often to flush to disk, the most that a crash loses")
+	flag.BoolVar(&auth, "auth", false, "authenticate to also record your own private messages")
+	flag.DurationVar(&statsEvery, "stats-every", time.Minute, "how often to log the number of frames recorded, 0 to never")
+	flag.Parse()
+	rec.MaxBytes = maxMB * 1024 * 1024
+
+	var products []string
+	for _, product := range strings.Split(productsStr, ",") {
+		if product = strings.ToUpper(strings.TrimSpace(product)); product != "" {
+			products = append(products, product)
+		}
+	}
+	if len(products) == 0 {
+		log.Fatal("-product: expecting at least one product")
+	}
+
+	client, err := coinbase.NewDefaultClient()
+	if err != nil {
+		if auth {
+			log.Fatal(err)
+		}
+		client = new(coinbase.Client)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	var statsC <-chan time.Time
+	if statsEvery > 0 {
+		ticker := time.NewTicker(statsEvery)
+		defer ticker.Stop()
+		statsC = ticker.C
+	}
+
+	var recorded, sinceStats int64
+	delay := minReconnectDelay
+	defer func() {
+		if err := rec.Close(); err != nil {
+			log.Printf("closing the recording: %v", err)
+		}
+		log.Printf("recorded %d frames to %s", recorded, rec.Dir)
+	}()
+
+	for {
+		sub, err := client.Subscribe(&coinbase.Subscription{
+			Authenticate:  auth,
+			Currencies:    products,
+			KeepRawFrames: true,
+		})
+		if err != nil {
+			log.Printf("subscribing: %v, retrying in %v", err, delay)
+		} else {
+			log.Printf("recording %s to %s", strings.Join(products, ", "), rec.Dir)
+			if stop := record(sub, rec, sigChan, statsC, &recorded, &sinceStats, &delay); stop {
+				return
+			}
+			log.Printf("the feed was disconnected, reconnecting in %v", delay)
+		}
+
+		select {
+		case <-sigChan:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// record writes the subscription's frames until it ends, reporting
+// whether recording should stop, which is also the case on failing
+// to write, since the recording would otherwise have a hole.
+func record(sub *coinbase.SubscriptionResponse, rec *replay.FeedRecorder, sigChan <-chan os.Signal,
+	statsC <-chan time.Time, recorded, sinceStats *int64, delay *time.Duration) bool {
+	defer sub.Close()
+	for {
+		select {
+		case <-sigChan:
+			return true
+
+		case <-statsC:
+			log.Printf("recorded %d frames in the last interval, %d in all", *sinceStats, *recorded)
+			*sinceStats = 0
+
+		case msg, ok := <-sub.MessagesChan:
+			if !ok {
+				return false
+			}
+			// Even frames that didn't parse, and errors, are recorded.
+			if err := rec.Record(msg); err != nil {
+				log.Printf("recording: %v", err)
+				return true
+			}
+			*recorded += 1
+			*sinceStats += 1
+			if len(msg.RawFrame) > 0 {
+				*delay = minReconnectDelay
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+const (
+	formatCSV    = "csv"
+	formatJSONL  = "jsonl"
+	formatTSV    = "tsv"
+	formatInflux = "influx"
+)
+
+var formats = []string{formatCSV, formatJSONL, formatTSV, formatInflux}
+
+type formatOptions struct {
+	format      string
+	product     string
+	precision   int
+	location    *time.Location
+	measurement string
+}
+
+// rowWriter writes candles in one of the output formats.
+type rowWriter struct {
+	bw   *bufio.Writer
+	opts *formatOptions
+}
+
+func newRowWriter(w io.Writer, opts *formatOptions) (*rowWriter, error) {
+	switch opts.format {
+	case formatCSV, formatJSONL, formatTSV, formatInflux:
+	default:
+		return nil, fmt.Errorf("unknown format %q, expecting one of: %s", opts.format, strings.Join(formats, ", "))
+	}
+	if opts.precision < 0 {
+		return nil, fmt.Errorf("expecting a non-negative precision, got %d", opts.precision)
+	}
+	if opts.location == nil {
+		opts.location = time.UTC
+	}
+	return &rowWriter{bw: bufio.NewWriter(w), opts: opts}, nil
+}
+
+var columns = []string{"date", "timeEpoch", "high", "low", "open", "close", "volume"}
+
+// writeHeader writes the column names for
+// the formats that have a header line.
+func (rw *rowWriter) writeHeader() error {
+	var err error
+	switch rw.opts.format {
+	case formatCSV:
+		_, err = fmt.Fprintln(rw.bw, strings.Join(columns, ","))
+	case formatTSV:
+		_, err = fmt.Fprintln(rw.bw, strings.Join(columns, "\t"))
+	}
+	return err
+}
+
+func (rw *rowWriter) float(f float64) string {
+	return strconv.FormatFloat(f, 'f', rw.opts.precision, 64)
+}
+
+func (rw *rowWriter) writeCandle(cs *coinbase.CandleStick) error {
+	ts := cs.Timestamp()
+	date := ts.In(rw.opts.location).Format(time.RFC3339)
+	values := []string{rw.float(cs.High), rw.float(cs.Low), rw.float(cs.Open), rw.float(cs.Close), rw.float(cs.Volume)}
+
+	var err error
+	switch rw.opts.format {
+	case formatCSV:
+		_, err = fmt.Fprintf(rw.bw, "%s,%d,%s\n", date, ts.Unix(), strings.Join(values, ","))
+	case formatTSV:
+		_, err = fmt.Fprintf(rw.bw, "%s\t%d\t%s\n", date, ts.Unix(), strings.Join(values, "\t"))
+	case formatJSONL:
+		// The values are written as is rather than through encoding/json
+		// so that they are emitted with the requested precision.
+		_, err = fmt.Fprintf(rw.bw, `{"product":%q,"date":%q,"time_epoch":%d,"high":%s,"low":%s,"open":%s,"close":%s,"volume":%s}`+"\n",
+			rw.opts.product, date, ts.Unix(), values[0], values[1], values[2], values[3], values[4])
+	case formatInflux:
+		_, err = fmt.Fprintf(rw.bw, "%s,product=%s high=%s,low=%s,open=%s,close=%s,volume=%s %d\n",
+			influxEscape(rw.opts.measurement), influxEscape(rw.opts.product),
+			values[0], values[1], values[2], values[3], values[4], ts.UnixNano())
+	}
+	return err
+}
+
+func (rw *rowWriter) flush() error {
+	return rw.bw.Flush()
+}
+
+// influxEscape escapes the characters that are special
+// in measurements and tag values of the line protocol.
+func influxEscape(s string) string {
+	return strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`).Replace(s)
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+var t0 = time.Date(2017, 9, 22, 0, 39, 0, 0, time.UTC)
+
+func testCandles() []*coinbase.CandleStick {
+	return []*coinbase.CandleStick{
+		{Time: float64(t0.Unix()), High: 3649.85, Low: 3647, Open: 3649.7, Close: 3647.05, Volume: 6.23591},
+		{Time: float64(t0.Add(time.Minute).Unix()), High: 3647.05, Low: 3646.6, Open: 3647.05, Close: 3646.61, Volume: 5.5431},
+	}
+}
+
+func TestRowWriter(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [...]struct {
+		opts    formatOptions
+		want    string
+		wantErr bool
+	}{
+		0: {opts: formatOptions{format: "xml"}, wantErr: true},
+		1: {opts: formatOptions{format: formatCSV, precision: -1}, wantErr: true},
+		2: {
+			opts: formatOptions{format: formatCSV, precision: 2},
+			want: "date,timeEpoch,high,low,open,close,volume\n" +
+				"2017-09-22T00:39:00Z,1506040740,3649.85,3647.00,3649.70,3647.05,6.24\n" +
+				"2017-09-22T00:40:00Z,1506040800,3647.05,3646.60,3647.05,3646.61,5.54\n",
+		},
+		3: {
+			opts: formatOptions{format: formatTSV, precision: 1, location: newYork},
+			want: "date\ttimeEpoch\thigh\tlow\topen\tclose\tvolume\n" +
+				"2017-09-21T20:39:00-04:00\t1506040740\t3649.8\t3647.0\t3649.7\t3647.1\t6.2\n" +
+				"2017-09-21T20:40:00-04:00\t1506040800\t3647.1\t3646.6\t3647.1\t3646.6\t5.5\n",
+		},
+		4: {
+			opts: formatOptions{format: formatJSONL, product: "BTC-USD", precision: 2},
+			want: `{"product":"BTC-USD","date":"2017-09-22T00:39:00Z","time_epoch":1506040740,"high":3649.85,"low":3647.00,"open":3649.70,"close":3647.05,"volume":6.24}` + "\n" +
+				`{"product":"BTC-USD","date":"2017-09-22T00:40:00Z","time_epoch":1506040800,"high":3647.05,"low":3646.60,"open":3647.05,"close":3646.61,"volume":5.54}` + "\n",
+		},
+		5: {
+			opts: formatOptions{format: formatInflux, product: "BTC USD", measurement: "gdax,candles", precision: 0},
+			want: `gdax\,candles,product=BTC\ USD high=3650,low=3647,open=3650,close=3647,volume=6 1506040740000000000` + "\n" +
+				`gdax\,candles,product=BTC\ USD high=3647,low=3647,open=3647,close=3647,volume=6 1506040800000000000` + "\n",
+		},
+	}
+
+	for i, tt := range tests {
+		buf := new(bytes.Buffer)
+		opts := tt.opts
+		rw, err := newRowWriter(buf, &opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if err := rw.writeHeader(); err != nil {
+			t.Errorf("#%d: header: %v", i, err)
+			continue
+		}
+		for _, cs := range testCandles() {
+			if err := rw.writeCandle(cs); err != nil {
+				t.Errorf("#%d: candle: %v", i, err)
+			}
+		}
+		if err := rw.flush(); err != nil {
+			t.Errorf("#%d: flush: %v", i, err)
+		}
+		if g, w := buf.String(), tt.want; g != w {
+			t.Errorf("#%d:\ngot= %q\nwant=%q", i, g, w)
+		}
+	}
+}
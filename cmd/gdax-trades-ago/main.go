@@ -15,68 +15,371 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/cache"
 )
 
+type exportConfig struct {
+	fopts       formatOptions
+	startTime   time.Time
+	endTime     time.Time
+	granularity int
+	resume      bool
+
+	// store if set serves the candles already fetched by
+	// previous runs, fetching only the missing windows.
+	store *cache.Store
+}
+
+type exportSummary struct {
+	product string
+	path    string
+	rows    int
+	gaps    int
+	err     error
+}
+
 func main() {
 	var durationAgo string
-	var product string
+	var productsStr string
+	var outPath, outDir string
+	var tz string
+	var startStr, endStr string
+	var concurrency int
+	var requestsPerSecond float64
+	var cacheDir string
+	cfg := new(exportConfig)
 	flag.StringVar(&durationAgo, "dur-ago", "8760h", "the duration ago to go back to")
-	flag.StringVar(&product, "product", "ETH-USD", "the product to retrieve trades for")
+	flag.StringVar(&productsStr, "product", "ETH-USD", `the comma separated products to retrieve trades for or "all"`)
+	flag.StringVar(&outPath, "out", "data.csv", `the path to write a single product to or "-" for stdout`)
+	flag.StringVar(&outDir, "out-dir", "", "the directory to write a file per product to, used with several products")
+	flag.StringVar(&cfg.fopts.format, "format", formatCSV, "the output format: csv, jsonl, tsv or influx")
+	flag.IntVar(&cfg.fopts.precision, "precision", 4, "the number of decimal places of prices and volumes")
+	flag.StringVar(&tz, "tz", "UTC", `the time zone of dates, e.g. "America/New_York" or "Local"`)
+	flag.StringVar(&cfg.fopts.measurement, "measurement", "candles", "the measurement name for the influx format")
+	flag.StringVar(&startStr, "start", "", "the absolute time to start from, overriding -dur-ago, e.g. 2017-01-01")
+	flag.StringVar(&endStr, "end", "", "the absolute time to end at, now by default")
+	flag.IntVar(&cfg.granularity, "granularity", coinbase.GranularityOneMinute, "the candle granularity in seconds: 60, 300, 900, 3600, 21600 or 86400")
+	flag.BoolVar(&cfg.resume, "resume", false, "continue after the last row of the existing output and append to it")
+	flag.IntVar(&concurrency, "concurrency", 3, "the number of products to fetch at once")
+	flag.Float64Var(&requestsPerSecond, "rate", 3, "the most requests per second shared by all products")
+	flag.StringVar(&cacheDir, "cache-dir", "", "the directory to cache candles in so that reruns only fetch what is missing")
 	flag.Parse()
 
-	now := time.Now()
-	pastDuration, err := time.ParseDuration(durationAgo)
-	if err != nil || pastDuration <= 0 {
-		pastDuration = 365 * 24 * time.Hour
+	var err error
+	if cfg.fopts.location, err = time.LoadLocation(tz); err != nil {
+		log.Fatalf("tz: %v", err)
+	}
+
+	cfg.endTime = time.Now()
+	if endStr != "" {
+		if cfg.endTime, err = parseTimeFlag("end", endStr, cfg.fopts.location); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if startStr != "" {
+		if cfg.startTime, err = parseTimeFlag("start", startStr, cfg.fopts.location); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		pastDuration, err := time.ParseDuration(durationAgo)
+		if err != nil || pastDuration <= 0 {
+			pastDuration = 365 * 24 * time.Hour
+		}
+		cfg.startTime = cfg.endTime.Add(-1 * pastDuration)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if requestsPerSecond <= 0 {
+		log.Fatal("-rate: expecting a positive number of requests per second")
+	}
+	// Validate the format before any request is made.
+	if _, err := newRowWriter(ioutil.Discard, &cfg.fopts); err != nil {
+		log.Fatal(err)
+	}
+	if cacheDir != "" {
+		if cfg.store, err = cache.Open(cacheDir); err != nil {
+			log.Fatalf("-cache-dir: %v", err)
+		}
 	}
 
 	client, err := coinbase.NewDefaultClient()
 	if err != nil {
 		log.Fatal(err)
 	}
+	limiter := newRateLimitedTransport(http.DefaultTransport, requestsPerSecond)
+	defer limiter.stop()
+	client.SetHTTPRoundTripper(limiter)
+	// -rate replaces the client's own limit.
+	client.SetPublicRateLimit(0)
 
-	csres, err := client.CandleSticks(&coinbase.CandleStickRequest{
-		Product:   product,
-		StartTime: now.Add(-1 * pastDuration),
-		EndTime:   now,
-	})
+	products, err := resolveProducts(client, productsStr)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	f, err := os.Create("data.csv")
+	paths := make(map[string]string)
+	if len(products) == 1 && outDir == "" {
+		paths[products[0]] = outPath
+	} else {
+		if outDir == "" {
+			outDir = "."
+		}
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		for _, product := range products {
+			paths[product] = filepath.Join(outDir, product+"."+cfg.fopts.format)
+		}
+	}
+
+	summaries := make([]*exportSummary, len(products))
+	sem := make(chan bool, concurrency)
+	var wg sync.WaitGroup
+	for i, product := range products {
+		wg.Add(1)
+		sem <- true
+		go func(i int, product string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			summaries[i] = exportProduct(client, product, paths[product], cfg)
+		}(i, product)
+	}
+	wg.Wait()
+
+	if failed := printSummaries(os.Stderr, summaries); failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// resolveProducts parses the -product flag, listing
+// the exchange's products if it is "all".
+func resolveProducts(client *coinbase.Client, productsStr string) ([]string, error) {
+	if strings.TrimSpace(strings.ToLower(productsStr)) == "all" {
+		products, err := client.Products()
+		if err != nil {
+			return nil, fmt.Errorf("listing products: %v", err)
+		}
+		var ids []string
+		for _, product := range products {
+			ids = append(ids, product.ID)
+		}
+		sort.Strings(ids)
+		return ids, nil
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(productsStr, ",") {
+		id = strings.ToUpper(strings.TrimSpace(id))
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("-product: expecting at least one product")
+	}
+	return ids, nil
+}
+
+func exportProduct(client *coinbase.Client, product, outPath string, cfg *exportConfig) *exportSummary {
+	summary := &exportSummary{product: product, path: outPath}
+	fopts := cfg.fopts
+	fopts.product = product
+	startTime := cfg.startTime
+	step := time.Duration(cfg.granularity) * time.Second
+
+	writeHeader := true
+	var w io.Writer = os.Stdout
+	if outPath != "-" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if cfg.resume {
+			lastTime, ok, err := lastRowTime(outPath, fopts.format)
+			if err != nil {
+				summary.err = err
+				return summary
+			}
+			if ok {
+				startTime = lastTime.Add(step)
+				log.Printf("%s: Resuming from: %s", product, startTime.In(fopts.location).Format(time.RFC3339))
+			}
+			if fi, err := os.Stat(outPath); err == nil && fi.Size() > 0 {
+				writeHeader = false
+			}
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(outPath, flags, 0644)
+		if err != nil {
+			summary.err = err
+			return summary
+		}
+		defer f.Close()
+		w = f
+	} else if cfg.resume {
+		summary.err = fmt.Errorf("-resume needs an -out file to resume from")
+		return summary
+	}
+	if !startTime.Before(cfg.endTime) {
+		log.Printf("%s: Nothing to fetch: the start %s is not before the end %s",
+			product, startTime.Format(time.RFC3339), cfg.endTime.Format(time.RFC3339))
+		return summary
+	}
+
+	rw, err := newRowWriter(w, &fopts)
 	if err != nil {
-		log.Fatal(err)
+		summary.err = err
+		return summary
+	}
+	if cfg.store != nil {
+		summary.rows, summary.gaps, summary.err = exportCached(client, rw, product, startTime, cfg, writeHeader)
+		return summary
+	}
+	csres, err := client.CandleSticks(&coinbase.CandleStickRequest{
+		Product:              product,
+		StartTime:            startTime,
+		EndTime:              cfg.endTime,
+		GranularityInSeconds: cfg.granularity,
+		Ordered:              true,
+		// The shared rate limit spaces out the requests.
+		ThrottleDurationMs: coinbase.NoThrottle,
+	})
+	if err != nil {
+		summary.err = err
+		return summary
 	}
-	defer f.Close()
 
-	fmt.Fprintf(f, "data,timeEpoch,high,low,open,close,volume\n")
-	bw := bufio.NewWriter(f)
-	defer bw.Flush()
+	if writeHeader {
+		if err := rw.writeHeader(); err != nil {
+			summary.err = err
+			return summary
+		}
+	}
+	defer rw.flush()
 
 	for csPage := range csres.PagesChan {
 		if csPage.Err != nil {
-			log.Printf("PageNumber #%d err: %v", csPage.PageNumber, csPage.Err)
-			continue
+			// Pages arrive in order so stopping here leaves no holes
+			// in the output and a rerun with -resume picks up from here.
+			summary.err = fmt.Errorf("page #%d: %v; rerun with -resume to continue", csPage.PageNumber, csPage.Err)
+			csres.Cancel()
+			for range csres.PagesChan {
+			}
+			return summary
+		}
+		for _, gap := range csPage.Gaps {
+			summary.gaps += int(gap.EndTime.Sub(gap.StartTime)/step) + 1
 		}
 		if len(csPage.CandleSticks) == 0 {
 			continue
 		}
 		for _, cs := range csPage.CandleSticks {
-			ts := int64(cs.Time)
-			t := time.Unix(ts, 0)
-			iso8601 := t.Format("2006-01-02T15:04:05.00000Z")
-			fmt.Fprintf(bw, "%s,%d,%.4f,%.4f,%.4f,%.4f,%.4f\n", iso8601, ts, cs.High, cs.Low, cs.Open, cs.Close, cs.Volume)
+			if err := rw.writeCandle(cs); err != nil {
+				summary.err = err
+				return summary
+			}
+		}
+		summary.rows += len(csPage.CandleSticks)
+		if err := rw.flush(); err != nil {
+			summary.err = err
+			return summary
 		}
-		bw.Flush()
-		log.Printf("Flushed page: #%d", csPage.PageNumber)
+		log.Printf("%s: Flushed page: #%d", product, csPage.PageNumber)
+	}
+	return summary
+}
+
+// exportCached writes the candles of product served by the
+// store, returning the number of rows and of empty buckets.
+func exportCached(client *coinbase.Client, rw *rowWriter, product string, startTime time.Time, cfg *exportConfig, writeHeader bool) (rows, gaps int, err error) {
+	candles, err := cfg.store.CandleSticks(client, &coinbase.CandleStickRequest{
+		Product:              product,
+		StartTime:            startTime,
+		EndTime:              cfg.endTime,
+		GranularityInSeconds: cfg.granularity,
+		// The shared rate limit spaces out the requests.
+		ThrottleDurationMs: coinbase.NoThrottle,
+	})
+	if err != nil {
+		// What was fetched is cached so a rerun continues from there.
+		return 0, 0, fmt.Errorf("%v; rerun to continue", err)
 	}
+
+	if writeHeader {
+		if err := rw.writeHeader(); err != nil {
+			return 0, 0, err
+		}
+	}
+	step := int64(cfg.granularity)
+	for i, cs := range candles {
+		if i > 0 {
+			if missed := (int64(cs.Time)-int64(candles[i-1].Time))/step - 1; missed > 0 {
+				gaps += int(missed)
+			}
+		}
+		if err := rw.writeCandle(cs); err != nil {
+			return rows, gaps, err
+		}
+		rows += 1
+	}
+	if err := rw.flush(); err != nil {
+		return rows, gaps, err
+	}
+	log.Printf("%s: Wrote %d cached rows", product, rows)
+	return rows, gaps, nil
+}
+
+// printSummaries writes a table of the exports and
+// returns the number of products that failed.
+func printSummaries(w io.Writer, summaries []*exportSummary) (failed int) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRODUCT\tROWS\tGAPS\tOUTPUT\tERROR")
+	for _, summary := range summaries {
+		errStr := "-"
+		if summary.err != nil {
+			errStr = summary.err.Error()
+			failed += 1
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", summary.product, summary.rows, summary.gaps, summary.path, errStr)
+	}
+	tw.Flush()
+	return failed
+}
+
+// rateLimitedTransport spaces out requests so that
+// all products together stay under the rate limit.
+type rateLimitedTransport struct {
+	rt     http.RoundTripper
+	ticker *time.Ticker
+}
+
+func newRateLimitedTransport(rt http.RoundTripper, requestsPerSecond float64) *rateLimitedTransport {
+	interval := time.Duration(float64(time.Second) / requestsPerSecond)
+	return &rateLimitedTransport{rt: rt, ticker: time.NewTicker(interval)}
+}
+
+func (rlt *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-rlt.ticker.C
+	return rlt.rt.RoundTrip(req)
+}
+
+func (rlt *rateLimitedTransport) stop() {
+	rlt.ticker.Stop()
 }
@@ -0,0 +1,131 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tailSize is how much of the end of the output is inspected
+// to find the last row, which is plenty for a single line.
+const tailSize = 64 * 1024
+
+// lastRowTime returns the time of the last complete row in the output
+// at path, first truncating any partial row that an interrupted run
+// left behind. It reports false if there are no rows yet.
+func lastRowTime(path, format string) (time.Time, bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	offset := fi.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, fi.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return time.Time{}, false, err
+	}
+
+	// Rows are only complete once their newline is written.
+	end := bytes.LastIndexByte(tail, '\n') + 1
+	if end < len(tail) {
+		if err := f.Truncate(offset + int64(end)); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(string(tail[:end])), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if isHeader := strings.HasPrefix(line, columns[0]); isHeader && (format == formatCSV || format == formatTSV) {
+			continue
+		}
+		t, err := parseRowTime(line, format)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%s: last row: %v", path, err)
+		}
+		return t, true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+func parseRowTime(line, format string) (time.Time, error) {
+	switch format {
+	case formatCSV, formatTSV:
+		sep := ","
+		if format == formatTSV {
+			sep = "\t"
+		}
+		fields := strings.Split(line, sep)
+		if len(fields) < 2 {
+			return time.Time{}, fmt.Errorf("expecting at least 2 fields in %q", line)
+		}
+		epoch, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(epoch, 0).UTC(), nil
+
+	case formatJSONL:
+		row := new(struct {
+			TimeEpoch int64 `json:"time_epoch"`
+		})
+		if err := json.Unmarshal([]byte(line), row); err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(row.TimeEpoch, 0).UTC(), nil
+
+	case formatInflux:
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return time.Time{}, fmt.Errorf("expecting a timestamp in %q", line)
+		}
+		nanos, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, nanos).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown format %q", format)
+}
+
+// parseTimeFlag parses absolute times in RFC 3339 or as plain dates.
+func parseTimeFlag(name, value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("-%s: expecting a time like 2017-09-21 or 2017-09-21T18:00:00Z, got %q", name, value)
+}
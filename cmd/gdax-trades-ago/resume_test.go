@@ -0,0 +1,154 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// output returns the rows of the test candles in format.
+func output(t *testing.T, format string) string {
+	buf := new(bytes.Buffer)
+	rw, err := newRowWriter(buf, &formatOptions{format: format, product: "BTC-USD", measurement: "candles", precision: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.writeHeader()
+	for _, cs := range testCandles() {
+		rw.writeCandle(cs)
+	}
+	rw.flush()
+	return buf.String()
+}
+
+func TestLastRowTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdax-trades-ago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	last := t0.Add(time.Minute)
+	type testCase struct {
+		format   string
+		contents string
+		missing  bool
+
+		wantTime     time.Time
+		wantOK       bool
+		wantErr      bool
+		wantContents string
+	}
+	var tests []testCase
+	for _, format := range formats {
+		rows := output(t, format)
+		tests = append(tests,
+			testCase{format: format, contents: rows, wantTime: last, wantOK: true, wantContents: rows},
+			// The torn last row is truncated and the previous one is used.
+			testCase{format: format, contents: rows + rows[:20], wantTime: last, wantOK: true, wantContents: rows},
+			testCase{format: format, contents: "", wantContents: ""},
+			testCase{format: format, missing: true},
+		)
+	}
+	header := "date,timeEpoch,high,low,open,close,volume\n"
+	tests = append(tests,
+		// Only the header is left of a first row that was torn.
+		testCase{format: formatCSV, contents: header + "2017-09", wantContents: header},
+		testCase{format: formatTSV, contents: "date\ttimeEpoch\n", wantContents: "date\ttimeEpoch\n"},
+		testCase{format: formatCSV, contents: "2017-09-22T00:39:00Z,yesterday,1\n", wantErr: true},
+		testCase{format: formatJSONL, contents: "{\"time_epoch\":\n", wantErr: true},
+		testCase{format: formatInflux, contents: "candles\n", wantErr: true},
+	)
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, "out")
+		os.Remove(path)
+		if !tt.missing {
+			if err := ioutil.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		gotTime, gotOK, err := lastRowTime(path, tt.format)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: %s: expected a non-nil error", i, tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %s: unexpected error: %v", i, tt.format, err)
+			continue
+		}
+		if gotOK != tt.wantOK || !gotTime.Equal(tt.wantTime) {
+			t.Errorf("#%d: %s: got=(%s, %v) want=(%s, %v)", i, tt.format, gotTime, gotOK, tt.wantTime, tt.wantOK)
+		}
+		if tt.missing {
+			continue
+		}
+		blob, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("#%d: %s: %v", i, tt.format, err)
+			continue
+		}
+		if g, w := string(blob), tt.wantContents; g != w {
+			t.Errorf("#%d: %s: contents:\ngot= %q\nwant=%q", i, tt.format, g, w)
+		}
+	}
+}
+
+func TestParseTimeFlag(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [...]struct {
+		value   string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		0: {value: "2017-09-21", loc: time.UTC, want: time.Date(2017, 9, 21, 0, 0, 0, 0, time.UTC)},
+		1: {value: "2017-09-21", loc: newYork, want: time.Date(2017, 9, 21, 4, 0, 0, 0, time.UTC)},
+		2: {value: "2017-09-21T18:30:00", loc: newYork, want: time.Date(2017, 9, 21, 22, 30, 0, 0, time.UTC)},
+		// An explicit offset wins over the location.
+		3: {value: "2017-09-21T18:30:00Z", loc: newYork, want: time.Date(2017, 9, 21, 18, 30, 0, 0, time.UTC)},
+		4: {value: "2017-09-21T18:30:00+02:00", loc: time.UTC, want: time.Date(2017, 9, 21, 16, 30, 0, 0, time.UTC)},
+		5: {value: "21/09/2017", loc: time.UTC, wantErr: true},
+		6: {value: "", loc: time.UTC, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		got, err := parseTimeFlag("start", tt.value, tt.loc)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("#%d: got=%s want=%s", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+type config struct {
+	rendering    renderOptions
+	matches      int
+	refresh      time.Duration
+	bookEvery    time.Duration
+	statsEvery   time.Duration
+	requestsRate float64
+}
+
+func main() {
+	var productsStr, tz string
+	cfg := new(config)
+	flag.StringVar(&productsStr, "product", "BTC-USD,ETH-USD", "the comma separated products to watch")
+	flag.IntVar(&cfg.rendering.depth, "depth", 5, "the number of price levels of the book to show per side")
+	flag.IntVar(&cfg.rendering.precision, "precision", 2, "the number of decimal places of prices")
+	flag.BoolVar(&cfg.rendering.color, "color", true, "color buys and rises green and sells red")
+	flag.StringVar(&tz, "tz", "Local", `the time zone of times, e.g. "UTC"`)
+	flag.IntVar(&cfg.matches, "matches", 5, "the number of recent matches to show per product")
+	flag.DurationVar(&cfg.refresh, "refresh", 500*time.Millisecond, "how often to redraw the screen")
+	flag.DurationVar(&cfg.bookEvery, "book-every", 2*time.Second, "how often to poll the books")
+	flag.DurationVar(&cfg.statsEvery, "stats-every", 30*time.Second, "how often to poll the 24h stats")
+	flag.Float64Var(&cfg.requestsRate, "rate", 3, "the most polling requests per second")
+	flag.Parse()
+
+	var err error
+	if cfg.rendering.location, err = time.LoadLocation(tz); err != nil {
+		log.Fatalf("tz: %v", err)
+	}
+	if cfg.refresh <= 0 || cfg.bookEvery <= 0 || cfg.statsEvery <= 0 || cfg.requestsRate <= 0 {
+		log.Fatal("expecting positive -refresh, -book-every, -stats-every and -rate")
+	}
+
+	client, err := coinbase.NewDefaultClient()
+	if err != nil {
+		// Everything that is watched is public.
+		client = new(coinbase.Client)
+	}
+	w := newWatcher(client, cfg)
+	for _, product := range strings.Split(productsStr, ",") {
+		if product = strings.ToUpper(strings.TrimSpace(product)); product != "" {
+			w.add(product)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	if err := w.run(os.Stdin, os.Stdout, sigChan); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// watcher owns the state of the watched markets, which is only ever
+// touched by its run loop, with feeds and pollers reporting to it.
+type watcher struct {
+	client *coinbase.Client
+	cfg    *config
+
+	products []string
+	markets  map[string]*market
+
+	// known are the products listed by the exchange, if they could be.
+	known map[string]bool
+
+	sub    *coinbase.SubscriptionResponse
+	msgs   <-chan *coinbase.Message
+	status string
+}
+
+func newWatcher(client *coinbase.Client, cfg *config) *watcher {
+	w := &watcher{client: client, cfg: cfg, markets: make(map[string]*market)}
+	if products, err := client.Products(); err == nil {
+		w.known = make(map[string]bool)
+		for _, product := range products {
+			w.known[product.ID] = true
+		}
+	}
+	return w
+}
+
+func (w *watcher) add(product string) {
+	if _, ok := w.markets[product]; ok {
+		return
+	}
+	if w.known != nil && !w.known[product] {
+		w.status = fmt.Sprintf("unknown product %q", product)
+		return
+	}
+	w.products = append(w.products, product)
+	w.markets[product] = newMarket(product, w.cfg.matches)
+}
+
+func (w *watcher) remove(product string) {
+	if _, ok := w.markets[product]; !ok {
+		w.status = fmt.Sprintf("not watching %q", product)
+		return
+	}
+	delete(w.markets, product)
+	for i, p := range w.products {
+		if p == product {
+			w.products = append(w.products[:i], w.products[i+1:]...)
+			break
+		}
+	}
+}
+
+// resubscribe replaces the subscription with one to the current
+// products, since a subscription's products can't be changed.
+func (w *watcher) resubscribe() error {
+	if w.sub != nil {
+		sub := w.sub
+		sub.Close()
+		// Unblock the feed's reader, if it was sending to us.
+		go func() {
+			for range sub.MessagesChan {
+			}
+		}()
+		w.sub, w.msgs = nil, nil
+	}
+	if len(w.products) == 0 {
+		return nil
+	}
+	sub, err := w.client.Subscribe(&coinbase.Subscription{Currencies: append([]string(nil), w.products...)})
+	if err != nil {
+		return err
+	}
+	w.sub, w.msgs = sub, sub.MessagesChan
+	return nil
+}
+
+// pollResult is the outcome of polling the books,
+// and possibly the stats, of several products.
+type pollResult struct {
+	books map[string]*coinbase.OrderBook
+	stats map[string]*coinbase.Stats
+	errs  []string
+}
+
+func (w *watcher) poll(products []string, withStats bool, results chan<- *pollResult) {
+	limiter := time.NewTicker(time.Duration(float64(time.Second) / w.cfg.requestsRate))
+	defer limiter.Stop()
+
+	res := &pollResult{books: make(map[string]*coinbase.OrderBook), stats: make(map[string]*coinbase.Stats)}
+	for _, product := range products {
+		<-limiter.C
+		book, err := w.client.OrderBook(product, coinbase.BookLevelTop50)
+		if err != nil {
+			res.errs = append(res.errs, fmt.Sprintf("%s book: %v", product, err))
+		} else {
+			res.books[product] = book
+		}
+		if !withStats {
+			continue
+		}
+		<-limiter.C
+		stats, err := w.client.Stats(product)
+		if err != nil {
+			res.errs = append(res.errs, fmt.Sprintf("%s stats: %v", product, err))
+		} else {
+			res.stats[product] = stats
+		}
+	}
+	results <- res
+}
+
+// reconnectDelay is how long to wait before resubscribing after the feed fails.
+const reconnectDelay = 3 * time.Second
+
+func (w *watcher) run(in io.Reader, out io.Writer, sigChan <-chan os.Signal) error {
+	commands := make(chan string)
+	go func() {
+		defer close(commands)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			commands <- strings.TrimSpace(scanner.Text())
+		}
+	}()
+
+	var reconnect <-chan time.Time
+	if err := w.resubscribe(); err != nil {
+		w.status = fmt.Sprintf("feed: %v", err)
+		reconnect = time.After(reconnectDelay)
+	}
+	defer func() {
+		if w.sub != nil {
+			w.sub.Close()
+		}
+	}()
+
+	refresh := time.NewTicker(w.cfg.refresh)
+	defer refresh.Stop()
+	bookTicker := time.NewTicker(w.cfg.bookEvery)
+	defer bookTicker.Stop()
+
+	// At most one poll is in flight, which mustn't block on exiting.
+	polls := make(chan *pollResult, 1)
+	polling := false
+	var lastStats time.Time
+	startPoll := func() {
+		if polling || len(w.products) == 0 {
+			return
+		}
+		polling = true
+		withStats := time.Since(lastStats) >= w.cfg.statsEvery
+		if withStats {
+			lastStats = time.Now()
+		}
+		go w.poll(append([]string(nil), w.products...), withStats, polls)
+	}
+	startPoll()
+
+	dirty := true
+	for {
+		select {
+		case <-sigChan:
+			fmt.Fprintln(out)
+			return nil
+
+		case cmd, ok := <-commands:
+			if !ok || cmd == "q" || cmd == "quit" {
+				fmt.Fprintln(out)
+				return nil
+			}
+			if changed := w.command(cmd); changed {
+				if err := w.resubscribe(); err != nil {
+					w.status = fmt.Sprintf("feed: %v", err)
+					reconnect = time.After(reconnectDelay)
+				}
+				// Poll right away so that new products don't wait for the next tick,
+				// and again for their stats.
+				lastStats = time.Time{}
+				startPoll()
+			}
+			dirty = true
+
+		case msg, ok := <-w.msgs:
+			if !ok {
+				w.sub, w.msgs = nil, nil
+				w.status = "feed: disconnected, reconnecting"
+				reconnect = time.After(reconnectDelay)
+				dirty = true
+				continue
+			}
+			if msg.Err != nil {
+				w.status = fmt.Sprintf("feed: %v", msg.Err)
+				dirty = true
+				continue
+			}
+			if m, ok := w.markets[msg.ProductID]; ok {
+				m.apply(msg)
+				dirty = true
+			}
+
+		case <-reconnect:
+			reconnect = nil
+			if err := w.resubscribe(); err != nil {
+				w.status = fmt.Sprintf("feed: %v", err)
+				reconnect = time.After(reconnectDelay)
+			} else {
+				w.status = ""
+			}
+			dirty = true
+
+		case <-bookTicker.C:
+			startPoll()
+
+		case res := <-polls:
+			polling = false
+			for product, book := range res.books {
+				if m, ok := w.markets[product]; ok {
+					m.book = book
+				}
+			}
+			for product, stats := range res.stats {
+				if m, ok := w.markets[product]; ok {
+					m.stats = stats
+				}
+			}
+			if len(res.errs) > 0 {
+				w.status = strings.Join(res.errs, "; ")
+			}
+			dirty = true
+
+		case <-refresh.C:
+			if !dirty {
+				continue
+			}
+			dirty = false
+			markets := make([]*market, 0, len(w.products))
+			for _, product := range w.products {
+				markets = append(markets, w.markets[product])
+			}
+			if err := render(out, markets, w.status, &w.cfg.rendering); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// command handles a line typed by the user,
+// reporting whether the products changed.
+func (w *watcher) command(cmd string) bool {
+	if cmd == "" {
+		return false
+	}
+	w.status = ""
+	n := len(w.products)
+	product := strings.ToUpper(strings.TrimSpace(cmd[1:]))
+	switch {
+	case cmd[0] == '+' && product != "":
+		w.add(product)
+		return len(w.products) != n
+	case cmd[0] == '-' && product != "":
+		w.remove(product)
+		return len(w.products) != n
+	}
+	w.status = fmt.Sprintf("unknown command %q", cmd)
+	return false
+}
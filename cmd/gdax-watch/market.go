@@ -0,0 +1,100 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+// trade is a match as seen by the taker.
+type trade struct {
+	time  time.Time
+	side  coinbase.Side
+	price float64
+	size  float64
+}
+
+// market is what is known about a product, from the feed
+// for trades and from polling for the book and 24h stats.
+type market struct {
+	product string
+
+	// trades are the most recent matches, newest first.
+	trades    []*trade
+	maxTrades int
+
+	book *coinbase.OrderBook
+
+	stats *coinbase.Stats
+}
+
+func newMarket(product string, maxTrades int) *market {
+	return &market{product: product, maxTrades: maxTrades}
+}
+
+// apply updates the market with a message from the feed.
+func (m *market) apply(msg *coinbase.Message) {
+	if msg.Type != coinbase.TypeMatch && msg.Type != coinbase.TypeLastMatch {
+		return
+	}
+	// The side of a match is the maker's, so the taker,
+	// who moved the price, was on the other side.
+	side := coinbase.SideBuy
+	if msg.Side == coinbase.SideBuy {
+		side = coinbase.SideSell
+	}
+	tr := &trade{time: msg.Time, side: side, price: msg.Price, size: msg.Size}
+	m.trades = append([]*trade{tr}, m.trades...)
+	if len(m.trades) > m.maxTrades {
+		m.trades = m.trades[:m.maxTrades]
+	}
+}
+
+// last returns the last traded price, from the feed if any
+// trade was seen since watching the product or else from stats.
+func (m *market) last() (float64, bool) {
+	if len(m.trades) > 0 {
+		return m.trades[0].price, true
+	}
+	if m.stats != nil && m.stats.Last > 0 {
+		return m.stats.Last, true
+	}
+	return 0, false
+}
+
+// change returns the change of the last price over the last 24 hours.
+func (m *market) change() (float64, bool) {
+	last, ok := m.last()
+	if !ok || m.stats == nil || m.stats.Open <= 0 {
+		return 0, false
+	}
+	return last - m.stats.Open, true
+}
+
+func (m *market) bestBid() *coinbase.BookEntry {
+	if m.book == nil || len(m.book.Bids) == 0 {
+		return nil
+	}
+	return m.book.Bids[0]
+}
+
+func (m *market) bestAsk() *coinbase.BookEntry {
+	if m.book == nil || len(m.book.Asks) == 0 {
+		return nil
+	}
+	return m.book.Asks[0]
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+const (
+	// clearScreen moves the cursor home and clears the terminal.
+	clearScreen = "\x1b[H\x1b[2J"
+
+	colorReset = "\x1b[0m"
+	colorGreen = "\x1b[32m"
+	colorRed   = "\x1b[31m"
+)
+
+const help = "Type +PRODUCT to add a product, -PRODUCT to remove one and q to quit, then press Enter."
+
+type renderOptions struct {
+	depth     int
+	precision int
+	color     bool
+	location  *time.Location
+}
+
+// render draws a frame of the markets in one write, to avoid flickering.
+func render(w io.Writer, markets []*market, status string, opts *renderOptions) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(clearScreen)
+	fmt.Fprintf(buf, "gdax-watch  %s\n\n", time.Now().In(opts.location).Format("2006-01-02 15:04:05 MST"))
+	if len(markets) == 0 {
+		buf.WriteString("Not watching any products.\n\n")
+	}
+	for _, m := range markets {
+		renderMarket(buf, m, opts)
+		buf.WriteString("\n")
+	}
+	if status != "" {
+		fmt.Fprintf(buf, "%s\n", status)
+	}
+	fmt.Fprintf(buf, "%s\n> ", help)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func renderMarket(buf *bytes.Buffer, m *market, opts *renderOptions) {
+	price := func(f float64) string { return strconv.FormatFloat(f, 'f', opts.precision, 64) }
+	size := func(f float64) string { return strconv.FormatFloat(f, 'f', 4, 64) }
+
+	fmt.Fprintf(buf, "%-9s", m.product)
+	if last, ok := m.last(); ok {
+		fmt.Fprintf(buf, "  last %s", price(last))
+		if len(m.trades) > 0 {
+			tr := m.trades[0]
+			fmt.Fprintf(buf, " (%s %s)", opts.colorize(tr.side, size(tr.size)), tr.time.In(opts.location).Format("15:04:05"))
+		}
+	} else {
+		buf.WriteString("  last -")
+	}
+	if change, ok := m.change(); ok {
+		pct := 100 * change / m.stats.Open
+		text := fmt.Sprintf("%+.*f (%+.2f%%)", opts.precision, change, pct)
+		side := coinbase.SideBuy
+		if change < 0 {
+			side = coinbase.SideSell
+		}
+		fmt.Fprintf(buf, "  24h %s", opts.colorize(side, text))
+	} else {
+		buf.WriteString("  24h -")
+	}
+	bid, ask := m.bestBid(), m.bestAsk()
+	if bid != nil && ask != nil {
+		fmt.Fprintf(buf, "  bid %s  ask %s  spread %s", price(bid.Price), price(ask.Price), price(ask.Price-bid.Price))
+	}
+	buf.WriteString("\n")
+
+	if m.book != nil && opts.depth > 0 {
+		fmt.Fprintf(buf, "  %12s %14s | %-14s %-12s\n", "BID SIZE", "BID", "ASK", "ASK SIZE")
+		for i := 0; i < opts.depth; i++ {
+			var left, right string
+			if i < len(m.book.Bids) {
+				b := m.book.Bids[i]
+				left = fmt.Sprintf("%12s %14s", size(b.Size), price(b.Price))
+			} else {
+				left = fmt.Sprintf("%12s %14s", "", "")
+			}
+			if i < len(m.book.Asks) {
+				a := m.book.Asks[i]
+				right = fmt.Sprintf("%-14s %-12s", price(a.Price), size(a.Size))
+			}
+			if i >= len(m.book.Bids) && i >= len(m.book.Asks) {
+				break
+			}
+			fmt.Fprintf(buf, "  %s | %s\n", left, right)
+		}
+	}
+
+	if len(m.trades) > 0 {
+		buf.WriteString("  recent matches:\n")
+		for _, tr := range m.trades {
+			fmt.Fprintf(buf, "    %s  %s  %14s  %12s\n", tr.time.In(opts.location).Format("15:04:05"),
+				opts.colorize(tr.side, fmt.Sprintf("%-4s", tr.side)), price(tr.price), size(tr.size))
+		}
+	}
+}
+
+// colorize shows buys, and rises, in green and sells in red.
+func (opts *renderOptions) colorize(side coinbase.Side, text string) string {
+	if !opts.color {
+		return text
+	}
+	if side == coinbase.SideBuy {
+		return colorGreen + text + colorReset
+	}
+	return colorRed + text + colorReset
+}
@@ -15,7 +15,6 @@
 package coinbase
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -139,31 +138,23 @@ func (c *Client) UpdateAccount(ureq *UpdateAccountRequest) (*Account, error) {
 		return nil, err
 	}
 	fullURL := fmt.Sprintf("%s/accounts/%s", baseURL, ureq.ID)
-	blob, err := json.Marshal(map[string]string{"name": ureq.Name})
+	req, err := newRequest("PUT", fullURL, map[string]string{"name": ureq.Name})
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("PUT", fullURL, bytes.NewReader(blob))
-	if err != nil {
-		return nil, err
-	}
-	return c.authAndRetrieveAccount(req)
+	return c.authAndRetrieveAccount("UpdateAccount", req)
 }
 
 func (c *Client) CreateAccount(creq *CreateAccountRequest) (*Account, error) {
 	if err := creq.Validate(); err != nil {
 		return nil, err
 	}
-	blob, err := json.Marshal(creq)
-	if err != nil {
-		return nil, err
-	}
 	fullURL := fmt.Sprintf("%s/accounts", baseURL)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewReader(blob))
+	req, err := newRequest("POST", fullURL, creq)
 	if err != nil {
 		return nil, err
 	}
-	return c.authAndRetrieveAccount(req)
+	return c.authAndRetrieveAccount("CreateAccount", req)
 }
 
 func (c *Client) SetAccountAsPrimary(accountID string) (*Account, error) {
@@ -176,7 +167,7 @@ func (c *Client) SetAccountAsPrimary(accountID string) (*Account, error) {
 	if err != nil {
 		return nil, err
 	}
-	return c.authAndRetrieveAccount(req)
+	return c.authAndRetrieveAccount("SetAccountAsPrimary", req)
 }
 
 func (c *Client) DeleteAccountByID(accountID string) error {
@@ -189,7 +180,7 @@ func (c *Client) DeleteAccountByID(accountID string) error {
 	if err != nil {
 		return err
 	}
-	_, _, err = c.doAuthAndReq(req)
+	_, _, err = c.doAuthAndReq("DeleteAccountByID", req)
 	return err
 }
 
@@ -203,11 +194,11 @@ func (c *Client) FindAccountByID(accountID string) (*Account, error) {
 	if err != nil {
 		return nil, err
 	}
-	return c.authAndRetrieveAccount(req)
+	return c.authAndRetrieveAccount("FindAccountByID", req)
 }
 
-func (c *Client) authAndRetrieveAccount(req *http.Request) (*Account, error) {
-	blob, _, err := c.doAuthAndReq(req)
+func (c *Client) authAndRetrieveAccount(name string, req *http.Request) (*Account, error) {
+	blob, _, err := c.doAuthAndReq(name, req)
 	if err != nil {
 		return nil, err
 	}
@@ -275,7 +266,7 @@ func (c *Client) ListAccounts(req *AccountsRequest) (*AccountsListResponse, erro
 				pagesChan <- page
 				return
 			}
-			blob, _, err := c.doAuthAndReq(req)
+			blob, _, err := c.doAuthAndReq("ListAccounts", req)
 			if err != nil {
 				page.Err = err
 				pagesChan <- page
@@ -15,7 +15,6 @@
 package coinbase
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -124,7 +123,7 @@ func (c *Client) ListAddresses(alReq *AddressesRequest) (*AddressesResponse, err
 				pagesChan <- page
 				return
 			}
-			blob, _, err := c.doAuthAndReq(req)
+			blob, _, err := c.doAuthAndReq("ListAddresses", req)
 			if err != nil {
 				page.Err = err
 				pagesChan <- page
@@ -191,17 +190,13 @@ func (c *Client) CreateAddress(caReq *CreateAddressRequest) (*Address, error) {
 	if err := caReq.Validate(); err != nil {
 		return nil, err
 	}
-	blob, err := json.Marshal(map[string]string{"name": caReq.Name})
-	if err != nil {
-		return nil, err
-	}
 	fullURL := fmt.Sprintf("%s/accounts/%s/addresses", baseURL, caReq.AccountID)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewReader(blob))
+	req, err := newRequest("POST", fullURL, map[string]string{"name": caReq.Name})
 	if err != nil {
 		return nil, err
 	}
 
-	blob, _, err = c.doAuthAndReq(req)
+	blob, _, err := c.doAuthAndReq("CreateAddress", req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,310 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy is what happens to messages from the
+// feed once a slow consumer has let the buffer fill up.
+type BackpressurePolicy string
+
+const (
+	// BackpressureBlock stops reading from the feed until the consumer
+	// catches up, which if it lasts makes the exchange disconnect.
+	BackpressureBlock BackpressurePolicy = "block"
+
+	// BackpressureDropOldest drops the oldest buffered message.
+	BackpressureDropOldest BackpressurePolicy = "drop-oldest"
+
+	// BackpressureDropNewest drops the message just received.
+	BackpressureDropNewest BackpressurePolicy = "drop-newest"
+
+	// BackpressureCoalesce replaces a buffered ticker, heartbeat or
+	// l2update message with one of the same product and type just
+	// received once the buffer is full, as only the latest of those
+	// matters. Other messages, and those with none to replace,
+	// wait for the consumer to catch up as with BackpressureBlock.
+	BackpressureCoalesce BackpressurePolicy = "coalesce"
+)
+
+var (
+	errInvalidBackpressure = errors.New("invalid backpressure policy: expecting block, drop-oldest, drop-newest or coalesce")
+	errNegativeBufferSize  = errors.New("expecting a non-negative buffer size")
+)
+
+func (bp BackpressurePolicy) Validate() error {
+	switch bp {
+	case "", BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest, BackpressureCoalesce:
+		return nil
+	}
+	return errInvalidBackpressure
+}
+
+// SubscriptionStats are counters of a subscription's messages.
+type SubscriptionStats struct {
+	Received  uint64 `json:"received"`
+	Delivered uint64 `json:"delivered"`
+
+	// Dropped counts the messages that were never delivered,
+	// including those that Coalesced counts.
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+
+	// Pending is the number of buffered messages.
+	Pending int `json:"pending"`
+
+	// Lag is how long the last delivered message took from
+	// being received to being consumed, and MaxLag the longest.
+	Lag    time.Duration `json:"lag"`
+	MaxLag time.Duration `json:"max_lag"`
+}
+
+// Stats returns a snapshot of the subscription's counters,
+// which are all zero for subscriptions that aren't buffered.
+func (sr *SubscriptionResponse) Stats() *SubscriptionStats {
+	if sr.buffer == nil {
+		return new(SubscriptionStats)
+	}
+	return sr.buffer.stats()
+}
+
+// BufferMessages buffers up to size messages of sr for its consumer,
+// applying the policy once the buffer is full. Closing the returned
+// subscription closes sr too. Messages with an Err are never dropped.
+func BufferMessages(sr *SubscriptionResponse, size int, policy BackpressurePolicy) (*SubscriptionResponse, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errNegativeBufferSize
+	}
+	if size == 0 {
+		size = 1
+	}
+	if policy == "" {
+		policy = BackpressureBlock
+	}
+	mb := &messageBuffer{
+		size:     size,
+		policy:   policy,
+		latest:   make(map[coalesceKey]*bufferSlot),
+		stopChan: make(chan bool),
+	}
+	mb.cond = sync.NewCond(&mb.mu)
+
+	msgsChan := make(chan *Message)
+	go mb.fill(sr.MessagesChan)
+	go mb.drain(msgsChan)
+
+	var closeOnce sync.Once
+	closeFn := func() error {
+		var err error
+		closeOnce.Do(func() {
+			err = sr.Close()
+			mb.stop()
+		})
+		return err
+	}
+	return &SubscriptionResponse{MessagesChan: msgsChan, cancelFn: closeFn, buffer: mb}, nil
+}
+
+// coalescedTypes are the types of messages that BackpressureCoalesce
+// replaces, leaving alone order events that mustn't be lost.
+var coalescedTypes = map[Type]bool{
+	TypeTicker:    true,
+	TypeHeartbeat: true,
+	TypeL2Update:  true,
+}
+
+type coalesceKey struct {
+	productID string
+	typ       Type
+}
+
+// bufferSlot lets a coalesced message take the place of the one it replaces.
+type bufferSlot struct {
+	msg *Message
+}
+
+type messageBuffer struct {
+	size   int
+	policy BackpressurePolicy
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	slots []*bufferSlot
+
+	// latest are the slots of the messages that may be coalesced.
+	latest map[coalesceKey]*bufferSlot
+
+	// done is set once no more messages will be buffered
+	// and stopped once no more will be delivered either.
+	done     bool
+	stopped  bool
+	stopChan chan bool
+
+	counters SubscriptionStats
+}
+
+func (mb *messageBuffer) fill(in <-chan *Message) {
+	for msg := range in {
+		if !mb.push(msg) {
+			// Unblock the sender until the closed subscription ends.
+			for range in {
+			}
+			break
+		}
+	}
+	mb.mu.Lock()
+	mb.done = true
+	mb.cond.Broadcast()
+	mb.mu.Unlock()
+}
+
+// push reports false once the buffer is stopped.
+func (mb *messageBuffer) push(msg *Message) bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.counters.Received += 1
+	key := coalesceKey{productID: msg.ProductID, typ: msg.Type}
+	coalesce := mb.policy == BackpressureCoalesce && msg.Err == nil && coalescedTypes[msg.Type]
+
+	for len(mb.slots) >= mb.size && msg.Err == nil && !mb.stopped {
+		switch mb.policy {
+		case BackpressureCoalesce:
+			if slot, ok := mb.latest[key]; ok && coalesce {
+				slot.msg = msg
+				mb.counters.Dropped += 1
+				mb.counters.Coalesced += 1
+				return true
+			}
+			mb.cond.Wait()
+			continue
+		case BackpressureBlock:
+			mb.cond.Wait()
+			continue
+		case BackpressureDropNewest:
+			mb.counters.Dropped += 1
+			return true
+		}
+		if !mb.dropOldest() {
+			// Only errors are left, which are never dropped.
+			break
+		}
+	}
+	if mb.stopped {
+		return false
+	}
+
+	slot := &bufferSlot{msg: msg}
+	mb.slots = append(mb.slots, slot)
+	if coalesce {
+		mb.latest[key] = slot
+	}
+	mb.cond.Broadcast()
+	return true
+}
+
+// dropOldest must be invoked with mb.mu held.
+func (mb *messageBuffer) dropOldest() bool {
+	for i, slot := range mb.slots {
+		if slot.msg.Err != nil {
+			continue
+		}
+		mb.forget(slot)
+		mb.slots = append(mb.slots[:i], mb.slots[i+1:]...)
+		mb.counters.Dropped += 1
+		return true
+	}
+	return false
+}
+
+// forget must be invoked with mb.mu held.
+func (mb *messageBuffer) forget(slot *bufferSlot) {
+	key := coalesceKey{productID: slot.msg.ProductID, typ: slot.msg.Type}
+	if mb.latest[key] == slot {
+		delete(mb.latest, key)
+	}
+}
+
+// pop waits for the next message, reporting
+// false once there will be no more of them.
+func (mb *messageBuffer) pop() (*Message, bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for len(mb.slots) == 0 && !mb.done && !mb.stopped {
+		mb.cond.Wait()
+	}
+	if len(mb.slots) == 0 || mb.stopped {
+		return nil, false
+	}
+	slot := mb.slots[0]
+	mb.slots[0] = nil
+	mb.slots = mb.slots[1:]
+	mb.forget(slot)
+	mb.cond.Broadcast()
+	return slot.msg, true
+}
+
+func (mb *messageBuffer) drain(out chan<- *Message) {
+	defer close(out)
+	for {
+		msg, ok := mb.pop()
+		if !ok {
+			return
+		}
+		select {
+		case out <- msg:
+		case <-mb.stopChan:
+			return
+		}
+
+		lag := time.Duration(0)
+		if !msg.ReceivedAt.IsZero() {
+			lag = time.Since(msg.ReceivedAt)
+		}
+		mb.mu.Lock()
+		mb.counters.Delivered += 1
+		mb.counters.Lag = lag
+		if lag > mb.counters.MaxLag {
+			mb.counters.MaxLag = lag
+		}
+		mb.mu.Unlock()
+	}
+}
+
+func (mb *messageBuffer) stop() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.stopped {
+		return
+	}
+	mb.stopped = true
+	close(mb.stopChan)
+	mb.cond.Broadcast()
+}
+
+func (mb *messageBuffer) stats() *SubscriptionStats {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	stats := mb.counters
+	stats.Pending = len(mb.slots)
+	return &stats
+}
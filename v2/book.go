@@ -0,0 +1,107 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The order book levels, of which only the aggregated ones are supported.
+const (
+	// BookLevelBest is only the best bid and ask.
+	BookLevelBest = 1
+
+	// BookLevelTop50 is the top 50 bids and asks, aggregated by price.
+	BookLevelTop50 = 2
+)
+
+type OrderBook struct {
+	Sequence int64        `json:"sequence,omitempty"`
+	Bids     []*BookEntry `json:"bids,omitempty"`
+	Asks     []*BookEntry `json:"asks,omitempty"`
+}
+
+// BookEntry is the total size of the orders at a price.
+type BookEntry struct {
+	Price     float64 `json:"price,omitempty"`
+	Size      float64 `json:"size,omitempty"`
+	NumOrders int64   `json:"num_orders,omitempty"`
+}
+
+var (
+	errInvalidBookLevel = errors.New("invalid book level: expecting 1 or 2")
+
+	errInvalidBookEntryOriginalJSON = errors.New("expecting data of the form: [price, size, num_orders]")
+)
+
+// UnmarshalJSON decodes entries sent by the
+// exchange as [price, size, num_orders].
+func (be *BookEntry) UnmarshalJSON(b []byte) error {
+	var recv []interface{}
+	if err := json.Unmarshal(b, &recv); err != nil {
+		return err
+	}
+	if len(recv) < 3 {
+		return errInvalidBookEntryOriginalJSON
+	}
+	var values [3]float64
+	for i, v := range recv[:3] {
+		switch v := v.(type) {
+		case float64:
+			values[i] = v
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			values[i] = f
+		default:
+			return errInvalidBookEntryOriginalJSON
+		}
+	}
+	be.Price, be.Size, be.NumOrders = values[0], values[1], int64(values[2])
+	return nil
+}
+
+// OrderBook retrieves a snapshot of the order book of
+// a product at level BookLevelBest or BookLevelTop50.
+func (c *Client) OrderBook(productID string, level int) (*OrderBook, error) {
+	productID = strings.TrimSpace(productID)
+	if productID == "" {
+		return nil, errBlankProduct
+	}
+	if level != BookLevelBest && level != BookLevelTop50 {
+		return nil, errInvalidBookLevel
+	}
+	fullURL := fmt.Sprintf("https://api.gdax.com/products/%s/book?level=%d", productID, level)
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := c.doPublicReq("OrderBook", req)
+	if err != nil {
+		return nil, err
+	}
+	book := new(OrderBook)
+	if err := json.Unmarshal(blob, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
@@ -0,0 +1,617 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache persists candles and trades on disk so that repeated
+// jobs only fetch from the exchange what they haven't seen before:
+//
+//	store, err := cache.Open("./.coinbase-cache")
+//	...
+//	// Served from disk, fetching only the windows not yet cached.
+//	candles, err := store.CandleSticks(client, &coinbase.CandleStickRequest{
+//		Product:              "BTC-USD",
+//		StartTime:            start,
+//		EndTime:              end,
+//		GranularityInSeconds: coinbase.GranularityOneHour,
+//	})
+//
+// Candles are kept per product and granularity, trades per product, in
+// append-only files of JSON lines under the store's directory. Candle
+// files also record the windows that were fetched, so that buckets in
+// which nothing traded aren't fetched again. Compact rewrites the files
+// without the duplicates that appending accumulates.
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+type Store struct {
+	dir string
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// Open creates dir if necessary and returns a Store that persists in it.
+func Open(dir string) (*Store, error) {
+	for _, subdir := range []string{"candles", "trades"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{dir: dir, now: time.Now}, nil
+}
+
+var (
+	errInvalidProduct     = errors.New("expecting a product of the form BASE-QUOTE")
+	errInvalidGranularity = errors.New("invalid granularity: expecting one of 60, 300, 900, 3600, 21600 or 86400 seconds")
+	errInvalidTimeRange   = errors.New("expecting a start time before the end time")
+
+	productRegexp = regexp.MustCompile(`^[A-Za-z0-9]+-[A-Za-z0-9]+$`)
+)
+
+func validateProduct(product string) error {
+	if !productRegexp.MatchString(product) {
+		return errInvalidProduct
+	}
+	return nil
+}
+
+func validateGranularity(granularity int) error {
+	switch granularity {
+	case coinbase.GranularityOneMinute, coinbase.GranularityFiveMinutes, coinbase.GranularityFifteenMinutes,
+		coinbase.GranularityOneHour, coinbase.GranularitySixHours, coinbase.GranularityOneDay:
+		return nil
+	}
+	return errInvalidGranularity
+}
+
+func (s *Store) candlesPath(product string, granularity int) string {
+	return filepath.Join(s.dir, "candles", fmt.Sprintf("%s-%d.jsonl", strings.ToUpper(product), granularity))
+}
+
+func (s *Store) tradesPath(product string) string {
+	return filepath.Join(s.dir, "trades", fmt.Sprintf("%s.jsonl", strings.ToUpper(product)))
+}
+
+// Window is an inclusive range of bucket start times.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// segment is a line of a candles file: a fetched window and its candles.
+type segment struct {
+	Window
+	Candles []*coinbase.CandleStick `json:"candles,omitempty"`
+}
+
+// candleSet is the merged content of a candles file.
+type candleSet struct {
+	step     time.Duration
+	byTime   map[int64]*coinbase.CandleStick
+	coverage []Window
+}
+
+// readCandles must be invoked with s.mu held.
+func (s *Store) readCandles(product string, granularity int) (*candleSet, error) {
+	cset := &candleSet{
+		step:   time.Duration(granularity) * time.Second,
+		byTime: make(map[int64]*coinbase.CandleStick),
+	}
+	err := readLines(s.candlesPath(product, granularity), func(line []byte) error {
+		seg := new(segment)
+		if err := json.Unmarshal(line, seg); err != nil {
+			return err
+		}
+		for _, cs := range seg.Candles {
+			cset.byTime[int64(cs.Time)] = cs
+		}
+		cset.coverage = append(cset.coverage, seg.Window)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cset.coverage = mergeWindows(cset.coverage, cset.step)
+	return cset, nil
+}
+
+// readLines invokes fn with every non-blank line of the file
+// at path. A file that doesn't exist yet has no lines.
+func readLines(path string, fn func(line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			if err == io.EOF {
+				// A partial line from an interrupted
+				// append is ignored rather than fatal.
+				return nil
+			}
+			if ferr := fn(line); ferr != nil {
+				return fmt.Errorf("%s: %v", path, ferr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// appendLines must be invoked with s.mu held.
+func appendLines(path string, values ...interface{}) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := trimTornLine(f); err != nil {
+		f.Close()
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// trimTornLine truncates f after its last newline, dropping the
+// partial line of an interrupted append that readLines skips and
+// that would otherwise be merged with the next line appended.
+// It leaves f's offset at its end.
+func trimTornLine(f *os.File) error {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	for off := end; off > 0; {
+		n := int64(len(buf))
+		if off < n {
+			n = off
+		}
+		off -= n
+		if _, err := f.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = off + int64(i) + 1
+			break
+		}
+		if off == 0 {
+			end = 0
+		}
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	_, err = f.Seek(end, io.SeekStart)
+	return err
+}
+
+// rewriteLines atomically replaces the file at path.
+func rewriteLines(path string, values ...interface{}) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".compact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(bw)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mergeWindows sorts windows and merges those that overlap or are adjacent.
+func mergeWindows(windows []Window, step time.Duration) []Window {
+	if len(windows) == 0 {
+		return nil
+	}
+	sorted := append([]Window(nil), windows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	merged := []Window{sorted[0]}
+	for _, w := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if w.Start.After(last.End.Add(step)) {
+			merged = append(merged, w)
+			continue
+		}
+		if w.End.After(last.End) {
+			last.End = w.End
+		}
+	}
+	return merged
+}
+
+// missingWindows returns the parts of want not in coverage.
+func missingWindows(want Window, coverage []Window, step time.Duration) []Window {
+	var missing []Window
+	next := want.Start
+	for _, w := range coverage {
+		if w.End.Before(next) {
+			continue
+		}
+		if w.Start.After(want.End) {
+			break
+		}
+		if w.Start.After(next) {
+			missing = append(missing, Window{Start: next, End: w.Start.Add(-step)})
+		}
+		next = w.End.Add(step)
+	}
+	if !next.After(want.End) {
+		missing = append(missing, Window{Start: next, End: want.End})
+	}
+	return missing
+}
+
+func bucketWindow(start, end time.Time, step time.Duration) Window {
+	return Window{Start: start.UTC().Truncate(step), End: end.UTC().Truncate(step)}
+}
+
+// MissingCandles returns the windows between start and end whose
+// candles of product and granularity haven't been cached.
+func (s *Store) MissingCandles(product string, granularity int, start, end time.Time) ([]Window, error) {
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+	if err := validateGranularity(granularity); err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, errInvalidTimeRange
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cset, err := s.readCandles(product, granularity)
+	if err != nil {
+		return nil, err
+	}
+	return missingWindows(bucketWindow(start, end, cset.step), cset.coverage, cset.step), nil
+}
+
+// Candles returns the cached candles of product and
+// granularity between start and end, in ascending order.
+func (s *Store) Candles(product string, granularity int, start, end time.Time) ([]*coinbase.CandleStick, error) {
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+	if err := validateGranularity(granularity); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cset, err := s.readCandles(product, granularity)
+	if err != nil {
+		return nil, err
+	}
+	want := bucketWindow(start, end, cset.step)
+	var candles []*coinbase.CandleStick
+	for _, cs := range cset.byTime {
+		if t := cs.Timestamp(); !t.Before(want.Start) && !t.After(want.End) {
+			candles = append(candles, cs)
+		}
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time < candles[j].Time })
+	return candles, nil
+}
+
+// PutCandles records that the window between start and end was
+// fetched with candles as its content. Buckets that haven't ended
+// yet are left out since their candles are still changing.
+func (s *Store) PutCandles(product string, granularity int, start, end time.Time, candles []*coinbase.CandleStick) error {
+	if err := validateProduct(product); err != nil {
+		return err
+	}
+	if err := validateGranularity(granularity); err != nil {
+		return err
+	}
+	step := time.Duration(granularity) * time.Second
+	w := bucketWindow(start, end, step)
+	if lastComplete := s.now().UTC().Truncate(step).Add(-step); w.End.After(lastComplete) {
+		w.End = lastComplete
+	}
+	if w.End.Before(w.Start) {
+		return nil
+	}
+	seg := &segment{Window: w}
+	for _, cs := range candles {
+		if t := cs.Timestamp(); !t.Before(w.Start) && !t.After(w.End) {
+			seg.Candles = append(seg.Candles, cs)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return appendLines(s.candlesPath(product, granularity), seg)
+}
+
+// CandleSticks serves the candles of creq from the store, fetching
+// and caching the windows that are missing. The request must have
+// both a start and an end time and the candles are in ascending order.
+// Candles of buckets that haven't ended yet are returned as fetched
+// but aren't cached, so a range ending at the present always fetches
+// its last bucket.
+func (s *Store) CandleSticks(client *coinbase.Client, creq *coinbase.CandleStickRequest) ([]*coinbase.CandleStick, error) {
+	if err := creq.Validate(); err != nil {
+		return nil, err
+	}
+	if creq.StartTime.IsZero() || creq.EndTime.Before(creq.StartTime) {
+		return nil, errInvalidTimeRange
+	}
+	granularity := creq.GranularityInSeconds
+	if granularity == 0 {
+		granularity = coinbase.GranularityOneMinute
+	}
+
+	missing, err := s.MissingCandles(creq.Product, granularity, creq.StartTime, creq.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	step := time.Duration(granularity) * time.Second
+	want := bucketWindow(creq.StartTime, creq.EndTime, step)
+	lastComplete := s.now().UTC().Truncate(step).Add(-step)
+	var incomplete []*coinbase.CandleStick
+	for _, w := range missing {
+		freq := *creq
+		freq.StartTime, freq.EndTime = w.Start, w.End
+		freq.GranularityInSeconds = granularity
+		freq.Ordered = true
+		freq.MaxPageNumber = 0
+		res, err := client.CandleSticks(&freq)
+		if err != nil {
+			return nil, err
+		}
+		for page := range res.PagesChan {
+			if page.Err != nil {
+				res.Cancel()
+				// Drain the remaining pages so that the fetching goroutines can exit.
+				for range res.PagesChan {
+				}
+				return nil, page.Err
+			}
+			pageStart, pageEnd := page.StartTime, page.EndTime
+			if pageStart.IsZero() || pageEnd.IsZero() {
+				pageStart, pageEnd = w.Start, w.End
+			}
+			if err := s.PutCandles(creq.Product, granularity, pageStart, pageEnd, page.CandleSticks); err != nil {
+				return nil, err
+			}
+			for _, cs := range page.CandleSticks {
+				if t := cs.Timestamp(); t.After(lastComplete) && !t.Before(want.Start) && !t.After(want.End) {
+					incomplete = append(incomplete, cs)
+				}
+			}
+		}
+	}
+
+	candles, err := s.Candles(creq.Product, granularity, creq.StartTime, creq.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(incomplete, func(i, j int) bool { return incomplete[i].Time < incomplete[j].Time })
+	for _, cs := range incomplete {
+		// A bucket may have ended and been cached since lastComplete was computed.
+		if n := len(candles); n == 0 || candles[n-1].Time < cs.Time {
+			candles = append(candles, cs)
+		}
+	}
+	return candles, nil
+}
+
+// readTrades must be invoked with s.mu held.
+func (s *Store) readTrades(product string) ([]*coinbase.Trade, error) {
+	byID := make(map[int64]*coinbase.Trade)
+	err := readLines(s.tradesPath(product), func(line []byte) error {
+		tr := new(coinbase.Trade)
+		if err := json.Unmarshal(line, tr); err != nil {
+			return err
+		}
+		byID[tr.TradeID] = tr
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	trades := make([]*coinbase.Trade, 0, len(byID))
+	for _, tr := range byID {
+		trades = append(trades, tr)
+	}
+	sort.Slice(trades, func(i, j int) bool { return trades[i].TradeID < trades[j].TradeID })
+	return trades, nil
+}
+
+// Trades returns the cached trades of product in ascending trade ID order.
+func (s *Store) Trades(product string) ([]*coinbase.Trade, error) {
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readTrades(product)
+}
+
+// PutTrades appends trades to the cache of product.
+func (s *Store) PutTrades(product string, trades []*coinbase.Trade) error {
+	if err := validateProduct(product); err != nil {
+		return err
+	}
+	if len(trades) == 0 {
+		return nil
+	}
+	values := make([]interface{}, 0, len(trades))
+	for _, tr := range trades {
+		values = append(values, tr)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return appendLines(s.tradesPath(product), values...)
+}
+
+// BackfillTrades fetches and caches the trades of product newer than
+// the newest cached one. With an empty cache, it starts off with the
+// most recent page of trades. It returns the number of trades added.
+func (s *Store) BackfillTrades(client *coinbase.Client, product string) (int, error) {
+	trades, err := s.Trades(product)
+	if err != nil {
+		return 0, err
+	}
+	treq := &coinbase.TradesRequest{Product: product}
+	if n := len(trades); n > 0 {
+		treq.SinceTradeID = trades[n-1].TradeID
+	} else {
+		treq.MaxPage = 1
+	}
+	res, err := client.ListTrades(treq)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for page := range res.PagesChan {
+		if page.Err != nil {
+			return added, page.Err
+		}
+		if err := s.PutTrades(product, page.Trades); err != nil {
+			res.Cancel()
+			for range res.PagesChan {
+			}
+			return added, err
+		}
+		added += len(page.Trades)
+	}
+	return added, nil
+}
+
+// Compact rewrites every file in the store without duplicated
+// candles or trades, merging the candle windows that were fetched.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candlePaths, err := filepath.Glob(filepath.Join(s.dir, "candles", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, p := range candlePaths {
+		name := strings.TrimSuffix(filepath.Base(p), ".jsonl")
+		i := strings.LastIndex(name, "-")
+		if i < 0 {
+			continue
+		}
+		var granularity int
+		if _, err := fmt.Sscanf(name[i+1:], "%d", &granularity); err != nil {
+			continue
+		}
+		if err := s.compactCandles(name[:i], granularity); err != nil {
+			return err
+		}
+	}
+
+	tradePaths, err := filepath.Glob(filepath.Join(s.dir, "trades", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, p := range tradePaths {
+		trades, err := s.readTrades(strings.TrimSuffix(filepath.Base(p), ".jsonl"))
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, 0, len(trades))
+		for _, tr := range trades {
+			values = append(values, tr)
+		}
+		if err := rewriteLines(p, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactCandles writes a segment per merged window.
+// It must be invoked with s.mu held.
+func (s *Store) compactCandles(product string, granularity int) error {
+	cset, err := s.readCandles(product, granularity)
+	if err != nil {
+		return err
+	}
+	candles := make([]*coinbase.CandleStick, 0, len(cset.byTime))
+	for _, cs := range cset.byTime {
+		candles = append(candles, cs)
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time < candles[j].Time })
+
+	values := make([]interface{}, 0, len(cset.coverage))
+	for _, w := range cset.coverage {
+		seg := &segment{Window: w}
+		for len(candles) > 0 && !candles[0].Timestamp().After(w.End) {
+			if !candles[0].Timestamp().Before(w.Start) {
+				seg.Candles = append(seg.Candles, candles[0])
+			}
+			candles = candles[1:]
+		}
+		values = append(values, seg)
+	}
+	return rewriteLines(s.candlesPath(product, granularity), values...)
+}
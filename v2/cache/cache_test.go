@@ -0,0 +1,311 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/cache"
+	"github.com/orijtech/coinbase/v2/coinbasetest"
+)
+
+// callCounter counts the calls that a client makes by name.
+type callCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (cc *callCounter) hook(ci *coinbase.CallInfo) func(*coinbase.CallResult) {
+	cc.mu.Lock()
+	cc.counts[ci.Name] += 1
+	cc.mu.Unlock()
+	return nil
+}
+
+func (cc *callCounter) take(name string) int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	n := cc.counts[name]
+	cc.counts[name] = 0
+	return n
+}
+
+type testEnv struct {
+	dir    string
+	srv    *coinbasetest.Server
+	client *coinbase.Client
+	calls  *callCounter
+	store  *cache.Store
+}
+
+func setup(t *testing.T) *testEnv {
+	dir, err := ioutil.TempDir("", "coinbase-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := cache.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := coinbasetest.NewServer()
+	client := srv.NewClient()
+	calls := &callCounter{counts: make(map[string]int)}
+	client.AddCallHooks(calls.hook)
+	return &testEnv{dir: dir, srv: srv, client: client, calls: calls, store: store}
+}
+
+func (env *testEnv) Close() {
+	env.srv.Close()
+	os.RemoveAll(env.dir)
+}
+
+func TestCandleSticks(t *testing.T) {
+	env := setup(t)
+	defer env.Close()
+	store := env.store
+
+	start := time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+	for i := -90; i < 150; i += 10 {
+		env.srv.AddTrade("BTC-USD", coinbase.SideBuy, float64(4000+i), 1, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	fetch := func(from, to time.Time) []*coinbase.CandleStick {
+		candles, err := store.CandleSticks(env.client, &coinbase.CandleStickRequest{
+			Product:              "BTC-USD",
+			StartTime:            from,
+			EndTime:              to,
+			GranularityInSeconds: coinbase.GranularityOneMinute,
+			ThrottleDurationMs:   coinbase.NoThrottle,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return candles
+	}
+
+	candles := fetch(start, start.Add(time.Hour))
+	if g, w := len(candles), 7; g != w {
+		t.Fatalf("candles: got=%d want=%d", g, w)
+	}
+	if g, w := env.calls.take("CandleSticks"), 1; g != w {
+		t.Errorf("first fetch: requests got=%d want=%d", g, w)
+	}
+
+	// The same window, even with its empty buckets, is served from disk.
+	candles = fetch(start, start.Add(time.Hour))
+	if g, w := len(candles), 7; g != w {
+		t.Fatalf("cached candles: got=%d want=%d", g, w)
+	}
+	if g, w := env.calls.take("CandleSticks"), 0; g != w {
+		t.Errorf("cached fetch: requests got=%d want=%d", g, w)
+	}
+
+	// Only the windows on either side are fetched.
+	candles = fetch(start.Add(-time.Hour), start.Add(2*time.Hour))
+	if g, w := len(candles), 19; g != w {
+		t.Fatalf("wider candles: got=%d want=%d", g, w)
+	}
+	if g, w := env.calls.take("CandleSticks"), 2; g != w {
+		t.Errorf("wider fetch: requests got=%d want=%d", g, w)
+	}
+	for i := 1; i < len(candles); i++ {
+		if candles[i-1].Time >= candles[i].Time {
+			t.Fatalf("#%d: candles aren't in ascending order", i)
+		}
+	}
+
+	missing, err := store.MissingCandles("BTC-USD", coinbase.GranularityOneMinute, start.Add(-2*time.Hour), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cache.Window{
+		{Start: start.Add(-2 * time.Hour), End: start.Add(-time.Hour - time.Minute)},
+		{Start: start.Add(2*time.Hour + time.Minute), End: start.Add(3 * time.Hour)},
+	}
+	if len(missing) != len(want) || missing[0] != want[0] || missing[1] != want[1] {
+		t.Errorf("missing: got=%v want=%v", missing, want)
+	}
+
+	// Compaction keeps the content but merges the segments.
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacted, err := store.Candles("BTC-USD", coinbase.GranularityOneMinute, start.Add(-time.Hour), start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(compacted), len(candles); g != w {
+		t.Errorf("compacted candles: got=%d want=%d", g, w)
+	}
+	blob, err := ioutil.ReadFile(filepath.Join(env.dir, "candles", "BTC-USD-60.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := countLines(blob), 1; g != w {
+		t.Errorf("compacted segments: got=%d want=%d", g, w)
+	}
+
+	if _, err := store.MissingCandles("BTC-USD", 7, start, start); err == nil {
+		t.Errorf("expected an error for an invalid granularity")
+	}
+	if _, err := store.Candles("../BTC-USD", coinbase.GranularityOneMinute, start, start); err == nil {
+		t.Errorf("expected an error for an invalid product")
+	}
+}
+
+func TestCandleSticksUpToNow(t *testing.T) {
+	env := setup(t)
+	defer env.Close()
+	store := env.store
+
+	now := time.Now().UTC()
+	env.srv.AddTrade("BTC-USD", coinbase.SideBuy, 4000, 1, now.Add(-5*time.Minute))
+	env.srv.AddTrade("BTC-USD", coinbase.SideSell, 4010, 1, now)
+
+	for i := 0; i < 2; i++ {
+		candles, err := store.CandleSticks(env.client, &coinbase.CandleStickRequest{
+			Product:              "BTC-USD",
+			StartTime:            now.Add(-10 * time.Minute),
+			EndTime:              now,
+			GranularityInSeconds: coinbase.GranularityOneMinute,
+			ThrottleDurationMs:   coinbase.NoThrottle,
+		})
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if g, w := len(candles), 2; g != w {
+			t.Fatalf("#%d: candles got=%d want=%d", i, g, w)
+		}
+		if g, w := candles[1].Timestamp(), now.Truncate(time.Minute); !g.Equal(w) {
+			t.Errorf("#%d: newest candle got=%v want=%v", i, g, w)
+		}
+		// Only the bucket in progress is fetched again.
+		if g, w := env.calls.take("CandleSticks"), 1; g != w {
+			t.Errorf("#%d: requests got=%d want=%d", i, g, w)
+		}
+	}
+}
+
+func countLines(blob []byte) int {
+	n := 0
+	for _, b := range blob {
+		if b == '\n' {
+			n += 1
+		}
+	}
+	return n
+}
+
+func TestBackfillTrades(t *testing.T) {
+	env := setup(t)
+	defer env.Close()
+	store := env.store
+
+	start := time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		env.srv.AddTrade("ETH-USD", coinbase.SideBuy, 300, 1, start.Add(time.Duration(i)*time.Second))
+	}
+	added, err := store.BackfillTrades(env.client, "ETH-USD")
+	if err != nil || added != 5 {
+		t.Fatalf("first backfill: added=%d err=%v", added, err)
+	}
+	if added, err := store.BackfillTrades(env.client, "ETH-USD"); err != nil || added != 0 {
+		t.Fatalf("repeated backfill: added=%d err=%v", added, err)
+	}
+
+	for i := 5; i < 8; i++ {
+		env.srv.AddTrade("ETH-USD", coinbase.SideSell, 301, 1, start.Add(time.Duration(i)*time.Second))
+	}
+	if added, err := store.BackfillTrades(env.client, "ETH-USD"); err != nil || added != 3 {
+		t.Fatalf("incremental backfill: added=%d err=%v", added, err)
+	}
+
+	// Duplicates are dropped when reading and compacting.
+	trades, err := store.Trades("ETH-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutTrades("ETH-USD", trades[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	trades, err = store.Trades("ETH-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(trades), 8; g != w {
+		t.Fatalf("trades: got=%d want=%d", g, w)
+	}
+	for i, tr := range trades {
+		if g, w := tr.TradeID, int64(i+1); g != w {
+			t.Errorf("#%d: trade ID got=%d want=%d", i, g, w)
+		}
+	}
+	blob, err := ioutil.ReadFile(filepath.Join(env.dir, "trades", "ETH-USD.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := countLines(blob), 8; g != w {
+		t.Errorf("compacted lines: got=%d want=%d", g, w)
+	}
+}
+
+func TestInterruptedAppend(t *testing.T) {
+	env := setup(t)
+	defer env.Close()
+
+	if err := env.store.PutTrades("BTC-USD", []*coinbase.Trade{{TradeID: 1, Price: 4000, Size: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(env.dir, "trades", "BTC-USD.jsonl"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"trade_id":2,"pri`)
+	f.Close()
+
+	trades, err := env.store.Trades("BTC-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 {
+		t.Errorf("trades: got=%d want=1", len(trades))
+	}
+
+	// Appending after the torn line must leave the file readable.
+	if err := env.store.PutTrades("BTC-USD", []*coinbase.Trade{{TradeID: 3, Price: 4002, Size: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	trades, err = env.store.Trades("BTC-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, tr := range trades {
+		ids = append(ids, tr.TradeID)
+	}
+	if g, w := ids, []int64{1, 3}; !reflect.DeepEqual(g, w) {
+		t.Errorf("trade ids: got=%v want=%v", g, w)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package candles aggregates candles and trades into candles of
+// arbitrary intervals, such as the 15 minute or 4 hour candles that
+// the exchange doesn't serve:
+//
+//	// Resample one minute candles into fifteen minute ones.
+//	resampled, err := candles.Resample(oneMinute, 15*time.Minute, nil)
+//
+//	// Build live five minute candles from the feed's matches.
+//	liveChan, err := candles.Stream(sres.MessagesChan, 5*time.Minute, nil)
+//
+// Buckets are aligned to multiples of the interval since the Unix
+// epoch, the same way that the exchange aligns its own candles.
+package candles
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+type Options struct {
+	// FillEmpty if set emits a candle for every bucket between
+	// buckets that have data. Empty buckets are flat at the
+	// previous close and have no volume.
+	FillEmpty bool `json:"fill_empty,omitempty"`
+}
+
+var errInvalidInterval = errors.New("expecting a positive interval of whole seconds")
+
+func validateInterval(interval time.Duration) error {
+	if interval < time.Second || interval%time.Second != 0 {
+		return errInvalidInterval
+	}
+	return nil
+}
+
+// Builder incrementally aggregates candles and trades, which must
+// be added in ascending time order, into candles of its interval.
+// Data older than the bucket in progress is dropped since the
+// candles that it belongs to have already been emitted.
+type Builder struct {
+	interval  time.Duration
+	fillEmpty bool
+
+	// open is the start of the earliest bucket not yet completed.
+	open      time.Time
+	current   *coinbase.CandleStick
+	lastClose float64
+	hasClose  bool
+}
+
+func NewBuilder(interval time.Duration, opts *Options) (*Builder, error) {
+	if err := validateInterval(interval); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = new(Options)
+	}
+	return &Builder{interval: interval, fillEmpty: opts.FillEmpty}, nil
+}
+
+func (b *Builder) bucket(t time.Time) time.Time {
+	// time.Truncate aligns to the zero time rather than to
+	// the Unix epoch, which differ for intervals like 7m or 1w.
+	secs := int64(b.interval / time.Second)
+	u := t.Unix()
+	offset := u % secs
+	if offset < 0 {
+		offset += secs
+	}
+	return time.Unix(u-offset, 0).UTC()
+}
+
+// AddCandle folds cs into the bucket that it starts in and returns
+// any candles that were completed. The interval of cs should divide
+// that of the Builder for the result to be meaningful.
+func (b *Builder) AddCandle(cs *coinbase.CandleStick) []*coinbase.CandleStick {
+	if cs == nil {
+		return nil
+	}
+	done, ok := b.advanceTo(cs.Timestamp())
+	if !ok {
+		return done
+	}
+	if b.current == nil {
+		cur := *cs
+		cur.Time = float64(b.bucket(cs.Timestamp()).Unix())
+		b.current = &cur
+		return done
+	}
+	cur := b.current
+	if cs.High > cur.High {
+		cur.High = cs.High
+	}
+	if cs.Low < cur.Low {
+		cur.Low = cs.Low
+	}
+	cur.Close = cs.Close
+	cur.Volume += cs.Volume
+	return done
+}
+
+// AddTrade folds a trade into its bucket and
+// returns any candles that were completed.
+func (b *Builder) AddTrade(t time.Time, price, size float64) []*coinbase.CandleStick {
+	return b.AddCandle(&coinbase.CandleStick{
+		Time:   float64(t.Unix()),
+		Low:    price,
+		High:   price,
+		Open:   price,
+		Close:  price,
+		Volume: size,
+	})
+}
+
+// AddMatch folds the trade of a match message into its bucket and
+// returns any candles that were completed. Other messages are ignored.
+func (b *Builder) AddMatch(msg *coinbase.Message) []*coinbase.CandleStick {
+	if !isMatch(msg) {
+		return nil
+	}
+	return b.AddTrade(msg.Time, msg.Price, msg.Size)
+}
+
+// Advance completes and returns the candles of the buckets that
+// ended by now, for when time progresses without any trades.
+func (b *Builder) Advance(now time.Time) []*coinbase.CandleStick {
+	done, _ := b.advanceTo(now)
+	return done
+}
+
+// advanceTo completes the buckets before t's bucket.
+// It reports false if t belongs to an already completed bucket.
+func (b *Builder) advanceTo(t time.Time) ([]*coinbase.CandleStick, bool) {
+	start := b.bucket(t)
+	if b.open.IsZero() {
+		b.open = start
+		return nil, true
+	}
+	if !start.After(b.open) {
+		return nil, start.Equal(b.open)
+	}
+
+	var done []*coinbase.CandleStick
+	next := b.open
+	if b.current != nil {
+		done = append(done, b.finish())
+		next = next.Add(b.interval)
+	}
+	if b.fillEmpty && b.hasClose {
+		for ; next.Before(start); next = next.Add(b.interval) {
+			done = append(done, b.flat(next))
+		}
+	}
+	b.open = start
+	return done, true
+}
+
+func (b *Builder) finish() *coinbase.CandleStick {
+	cur := b.current
+	b.current = nil
+	b.lastClose, b.hasClose = cur.Close, true
+	return cur
+}
+
+func (b *Builder) flat(t time.Time) *coinbase.CandleStick {
+	c := b.lastClose
+	return &coinbase.CandleStick{Time: float64(t.Unix()), Low: c, High: c, Open: c, Close: c}
+}
+
+// Current returns a copy of the candle in progress, if any.
+func (b *Builder) Current() *coinbase.CandleStick {
+	if b.current == nil {
+		return nil
+	}
+	cur := *b.current
+	return &cur
+}
+
+// Flush completes and returns the candle in progress, if any.
+// Later data for its bucket is dropped.
+func (b *Builder) Flush() *coinbase.CandleStick {
+	if b.current == nil {
+		return nil
+	}
+	b.open = b.open.Add(b.interval)
+	return b.finish()
+}
+
+// Resample aggregates candles into candles of interval, which
+// should be a multiple of their granularity. The result is sorted
+// in ascending time order whatever the order of candles.
+func Resample(candles []*coinbase.CandleStick, interval time.Duration, opts *Options) ([]*coinbase.CandleStick, error) {
+	b, err := NewBuilder(interval, opts)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]*coinbase.CandleStick, 0, len(candles))
+	for _, cs := range candles {
+		if cs != nil {
+			sorted = append(sorted, cs)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	var resampled []*coinbase.CandleStick
+	for _, cs := range sorted {
+		resampled = append(resampled, b.AddCandle(cs)...)
+	}
+	if cur := b.Flush(); cur != nil {
+		resampled = append(resampled, cur)
+	}
+	return resampled, nil
+}
+
+// FromMatches builds candles of interval from the match messages in
+// msgs, ordered by time then sequence. Other messages are ignored and
+// so are matches of products other than that of the first match.
+func FromMatches(msgs []*coinbase.Message, interval time.Duration, opts *Options) ([]*coinbase.CandleStick, error) {
+	b, err := NewBuilder(interval, opts)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*coinbase.Message
+	for _, msg := range msgs {
+		if !isMatch(msg) {
+			continue
+		}
+		if len(matches) > 0 && msg.ProductID != matches[0].ProductID {
+			continue
+		}
+		matches = append(matches, msg)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		mi, mj := matches[i], matches[j]
+		if !mi.Time.Equal(mj.Time) {
+			return mi.Time.Before(mj.Time)
+		}
+		return mi.SequenceNumber < mj.SequenceNumber
+	})
+
+	var candles []*coinbase.CandleStick
+	for _, msg := range matches {
+		candles = append(candles, b.AddMatch(msg)...)
+	}
+	if cur := b.Flush(); cur != nil {
+		candles = append(candles, cur)
+	}
+	return candles, nil
+}
+
+func isMatch(msg *coinbase.Message) bool {
+	return msg != nil && msg.Err == nil && (msg.Type == coinbase.TypeMatch || msg.Type == coinbase.TypeLastMatch)
+}
+
+type Candle struct {
+	ProductID   string                `json:"product_id,omitempty"`
+	CandleStick *coinbase.CandleStick `json:"candle,omitempty"`
+
+	// Partial is set for the candles in progress
+	// that are flushed when the feed ends.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Stream builds live candles of interval per product from the
+// messages of a subscription. A candle is sent once a message of its
+// product from a later bucket arrives, so subscribing to heartbeats
+// closes candles on time even when there are no trades. When msgsChan
+// is closed, the candles in progress are sent as partial ones.
+func Stream(msgsChan <-chan *coinbase.Message, interval time.Duration, opts *Options) (<-chan *Candle, error) {
+	if err := validateInterval(interval); err != nil {
+		return nil, err
+	}
+
+	candlesChan := make(chan *Candle)
+	go func() {
+		defer close(candlesChan)
+
+		builders := make(map[string]*Builder)
+		var productIDs []string
+		for msg := range msgsChan {
+			if msg == nil || msg.Err != nil || msg.ProductID == "" || msg.Time.IsZero() {
+				continue
+			}
+			b, ok := builders[msg.ProductID]
+			if !ok {
+				b, _ = NewBuilder(interval, opts)
+				builders[msg.ProductID] = b
+				productIDs = append(productIDs, msg.ProductID)
+			}
+
+			var done []*coinbase.CandleStick
+			if isMatch(msg) {
+				done = b.AddMatch(msg)
+			} else {
+				done = b.Advance(msg.Time)
+			}
+			for _, cs := range done {
+				candlesChan <- &Candle{ProductID: msg.ProductID, CandleStick: cs}
+			}
+		}
+
+		for _, productID := range productIDs {
+			if cs := builders[productID].Flush(); cs != nil {
+				candlesChan <- &Candle{ProductID: productID, CandleStick: cs, Partial: true}
+			}
+		}
+	}()
+
+	return candlesChan, nil
+}
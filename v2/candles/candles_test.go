@@ -0,0 +1,182 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package candles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/candles"
+)
+
+var t0 = time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+
+func at(minutes int) float64 {
+	return float64(t0.Add(time.Duration(minutes) * time.Minute).Unix())
+}
+
+func blobify(v interface{}) string {
+	blob, _ := json.Marshal(v)
+	return string(blob)
+}
+
+func TestResample(t *testing.T) {
+	// One minute candles, newest first as the exchange sends them.
+	oneMinute := []*coinbase.CandleStick{
+		{Time: at(46), Low: 9, High: 9, Open: 9, Close: 9, Volume: 1},
+		{Time: at(16), Low: 10, High: 14, Open: 12, Close: 11, Volume: 3},
+		{Time: at(2), Low: 6, High: 9, Open: 7, Close: 8, Volume: 2},
+		{Time: at(1), Low: 4, High: 12, Open: 5, Close: 7, Volume: 4},
+		{Time: at(0), Low: 5, High: 10, Open: 6, Close: 5, Volume: 1},
+	}
+
+	tests := [...]struct {
+		interval time.Duration
+		opts     *candles.Options
+		want     []*coinbase.CandleStick
+		wantErr  bool
+	}{
+		0: {interval: 0, wantErr: true},
+		1: {interval: 1500 * time.Millisecond, wantErr: true},
+		2: {
+			interval: 15 * time.Minute,
+			want: []*coinbase.CandleStick{
+				{Time: at(0), Low: 4, High: 12, Open: 6, Close: 8, Volume: 7},
+				{Time: at(15), Low: 10, High: 14, Open: 12, Close: 11, Volume: 3},
+				{Time: at(45), Low: 9, High: 9, Open: 9, Close: 9, Volume: 1},
+			},
+		},
+		3: {
+			interval: 15 * time.Minute,
+			opts:     &candles.Options{FillEmpty: true},
+			want: []*coinbase.CandleStick{
+				{Time: at(0), Low: 4, High: 12, Open: 6, Close: 8, Volume: 7},
+				{Time: at(15), Low: 10, High: 14, Open: 12, Close: 11, Volume: 3},
+				{Time: at(30), Low: 11, High: 11, Open: 11, Close: 11},
+				{Time: at(45), Low: 9, High: 9, Open: 9, Close: 9, Volume: 1},
+			},
+		},
+		4: {
+			interval: 4 * time.Hour,
+			want: []*coinbase.CandleStick{
+				// 18:00 UTC falls in the 16:00 bucket.
+				{Time: at(-120), Low: 4, High: 14, Open: 6, Close: 9, Volume: 11},
+			},
+		},
+		5: {
+			interval: 7 * time.Minute,
+			want: []*coinbase.CandleStick{
+				// 7m buckets since the epoch start at 17:58.
+				{Time: at(-2), Low: 4, High: 12, Open: 6, Close: 8, Volume: 7},
+				{Time: at(12), Low: 10, High: 14, Open: 12, Close: 11, Volume: 3},
+				{Time: at(40), Low: 9, High: 9, Open: 9, Close: 9, Volume: 1},
+			},
+		},
+		6: {
+			interval: 7 * 24 * time.Hour,
+			want: []*coinbase.CandleStick{
+				// Weeks start on Thursdays as the epoch did.
+				{Time: at(-18 * 60), Low: 4, High: 14, Open: 6, Close: 9, Volume: 11},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		got, err := candles.Resample(oneMinute, tt.interval, tt.opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d:\ngot= %s\nwant=%s", i, blobify(got), blobify(tt.want))
+		}
+	}
+}
+
+func match(productID string, seq int, minutes int, price, size float64) *coinbase.Message {
+	return &coinbase.Message{
+		Type:           coinbase.TypeMatch,
+		ProductID:      productID,
+		SequenceNumber: seq,
+		Time:           t0.Add(time.Duration(minutes) * time.Minute),
+		Price:          price,
+		Size:           size,
+	}
+}
+
+func TestFromMatches(t *testing.T) {
+	msgs := []*coinbase.Message{
+		match("BTC-USD", 3, 4, 4010, 0.5),
+		match("BTC-USD", 1, 0, 4000, 1),
+		{Type: coinbase.TypeHeartbeat, ProductID: "BTC-USD", Time: t0},
+		match("ETH-USD", 2, 1, 300, 10),
+		match("BTC-USD", 2, 0, 3990, 0.25),
+		match("BTC-USD", 4, 6, 4020, 2),
+	}
+	got, err := candles.FromMatches(msgs, 5*time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*coinbase.CandleStick{
+		{Time: at(0), Low: 3990, High: 4010, Open: 4000, Close: 4010, Volume: 1.75},
+		{Time: at(5), Low: 4020, High: 4020, Open: 4020, Close: 4020, Volume: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got= %s\nwant=%s", blobify(got), blobify(want))
+	}
+}
+
+func TestStream(t *testing.T) {
+	msgsChan := make(chan *coinbase.Message)
+	candlesChan, err := candles.Stream(msgsChan, time.Minute, &candles.Options{FillEmpty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer close(msgsChan)
+		msgsChan <- match("BTC-USD", 1, 0, 4000, 1)
+		msgsChan <- match("ETH-USD", 2, 0, 300, 1)
+		msgsChan <- match("BTC-USD", 3, 0, 4010, 1)
+		// A heartbeat closes BTC-USD's candles without a trade.
+		msgsChan <- &coinbase.Message{Type: coinbase.TypeHeartbeat, ProductID: "BTC-USD", Time: t0.Add(2 * time.Minute)}
+		// A late match is dropped.
+		msgsChan <- match("BTC-USD", 4, 0, 1, 1)
+		msgsChan <- match("ETH-USD", 5, 1, 310, 2)
+	}()
+
+	var got []*candles.Candle
+	for c := range candlesChan {
+		got = append(got, c)
+	}
+	want := []*candles.Candle{
+		{ProductID: "BTC-USD", CandleStick: &coinbase.CandleStick{Time: at(0), Low: 4000, High: 4010, Open: 4000, Close: 4010, Volume: 2}},
+		{ProductID: "BTC-USD", CandleStick: &coinbase.CandleStick{Time: at(1), Low: 4010, High: 4010, Open: 4010, Close: 4010}},
+		{ProductID: "ETH-USD", CandleStick: &coinbase.CandleStick{Time: at(0), Low: 300, High: 300, Open: 300, Close: 300, Volume: 1}},
+		{ProductID: "ETH-USD", CandleStick: &coinbase.CandleStick{Time: at(1), Low: 310, High: 310, Open: 310, Close: 310, Volume: 2}, Partial: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got= %s\nwant=%s", blobify(got), blobify(want))
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Reference: https://developers.coinbase.com/api/v2#time
+
+type ServerTime struct {
+	ISO   time.Time `json:"iso"`
+	Epoch int64     `json:"epoch"`
+}
+
+type serverTimeWrap struct {
+	Data   *ServerTime `json:"data"`
+	Errors []*apiError `json:"errors"`
+}
+
+var errNoServerTimeData = errors.New("expecting server time data in the response")
+
+// ServerTime retrieves the API server's current time.
+// The request is unauthenticated.
+func (c *Client) ServerTime() (*ServerTime, error) {
+	fullURL := fmt.Sprintf("%s/time", baseURL)
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := c.doHTTPReq("ServerTime", req)
+	if err != nil {
+		return nil, err
+	}
+	stwrap := new(serverTimeWrap)
+	if err := json.Unmarshal(blob, stwrap); err != nil {
+		return nil, err
+	}
+	if err := apiErrorsToError(stwrap.Errors); err != nil {
+		return nil, err
+	}
+	if stwrap.Data == nil {
+		return nil, errNoServerTimeData
+	}
+	return stwrap.Data, nil
+}
+
+// SetClock makes the client use clock instead of time.Now
+// for the timestamps that it signs requests with.
+// Passing in nil restores time.Now.
+func (c *Client) SetClock(clock func() time.Time) {
+	c.mu.Lock()
+	c.clock = clock
+	c.mu.Unlock()
+}
+
+// SetClockSkew sets the offset that is added to the
+// client's clock when stamping requests. Signatures whose
+// timestamps are more than 30 seconds away from the server's
+// time are rejected, so a positive skew is needed if
+// the host's clock is behind the server's.
+func (c *Client) SetClockSkew(skew time.Duration) {
+	c.mu.Lock()
+	c.clockSkew = skew
+	c.mu.Unlock()
+}
+
+// ClockSkew returns the offset that is currently
+// being added to the client's clock.
+func (c *Client) ClockSkew() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clockSkew
+}
+
+func (c *Client) now() time.Time {
+	c.mu.RLock()
+	clock, skew := c.clock, c.clockSkew
+	c.mu.RUnlock()
+	if clock == nil {
+		clock = time.Now
+	}
+	return clock().Add(skew)
+}
+
+// EstimateClockSkew compares the client's clock against
+// the server's time and returns their difference. The
+// server's time is compared against the midpoint of
+// the request's round trip. It doesn't change the
+// skew that the client uses, for that see SetClockSkew.
+func (c *Client) EstimateClockSkew() (time.Duration, error) {
+	c.mu.RLock()
+	clock := c.clock
+	c.mu.RUnlock()
+	if clock == nil {
+		clock = time.Now
+	}
+
+	sent := clock()
+	st, err := c.ServerTime()
+	if err != nil {
+		return 0, err
+	}
+	recv := clock()
+
+	midpoint := sent.Add(recv.Sub(sent) / 2)
+	serverTime := st.ISO
+	if serverTime.IsZero() {
+		serverTime = time.Unix(st.Epoch, 0)
+	}
+	// The server only reports time at a granularity
+	// of seconds, so no point in being more precise.
+	return serverTime.Sub(midpoint).Round(time.Second), nil
+}
+
+type ClockSkewEstimator struct {
+	// Interval is the period between estimates.
+	// If unset, it defaults to 5 minutes.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// ErrFunc if set is invoked with any errors
+	// encountered while estimating the skew.
+	ErrFunc func(error) `json:"-"`
+}
+
+// StartClockSkewEstimator periodically estimates the clock skew in
+// the background, starting immediately, and sets it on the client.
+// Invoke the returned function to stop the estimator.
+func (c *Client) StartClockSkewEstimator(cse *ClockSkewEstimator) (cancel func() error) {
+	if cse == nil {
+		cse = new(ClockSkewEstimator)
+	}
+	interval := cse.Interval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	canceler, cancelFn := makeCanceler()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			skew, err := c.EstimateClockSkew()
+			if err == nil {
+				c.SetClockSkew(skew)
+			} else if cse.ErrFunc != nil {
+				cse.ErrFunc(err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-canceler:
+				return
+			}
+		}
+	}()
+
+	return cancelFn
+}
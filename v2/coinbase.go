@@ -15,15 +15,16 @@
 package coinbase
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
@@ -34,6 +35,10 @@ const (
 	baseURL = "https://api.coinbase.com/v2"
 
 	unversionedBaseURL = "https://api.coinbase.com"
+
+	// exchangeHost serves the exchange's endpoints,
+	// which don't accept OAuth2 bearer tokens.
+	exchangeHost = "api.gdax.com"
 )
 
 type Client struct {
@@ -45,12 +50,40 @@ type Client struct {
 	passphrase string
 
 	rt http.RoundTripper
+
+	// clock if set is used instead of time.Now
+	// e.g. for deterministic signatures in tests.
+	clock func() time.Time
+
+	// clockSkew is added to the clock's time when
+	// stamping requests, to compensate for the
+	// difference between the host and server clocks.
+	clockSkew time.Duration
+
+	// tokenSource if set means that requests are
+	// authenticated with OAuth2 bearer tokens instead
+	// of being signed with the API key and secret.
+	tokenSource TokenSource
+
+	// signer if set authenticates requests instead
+	// of the API key or OAuth2 authentication.
+	signer Signer
+
+	callHooks []CallHook
+
+	// publicLimiter if set paces the requests to the public
+	// endpoints. It is created lazily unless publicLimitSet.
+	publicLimiter  *rateLimiter
+	publicLimitSet bool
+
+	wsFeedURL  string
+	feedDialer func(feedURL string) (FeedConn, error)
 }
 
 type Credentials struct {
-	APIKey     string
-	APISecret  string
-	Passphrase string
+	APIKey     string `json:"api_key"`
+	APISecret  string `json:"api_secret"`
+	Passphrase string `json:"passphrase,omitempty"`
 }
 
 var (
@@ -86,25 +119,11 @@ const (
 )
 
 func NewDefaultClient() (*Client, error) {
-	var errorsList []string
-
-	apiKey := strings.TrimSpace(os.Getenv(envCoinbaseAPIKey))
-	if apiKey == "" {
-		errorsList = append(errorsList, fmt.Sprintf("could not find %q in your environment", envCoinbaseAPIKey))
-	}
-	apiSecret := strings.TrimSpace(os.Getenv(envCoinbaseAPISecret))
-	if apiSecret == "" {
-		errorsList = append(errorsList, fmt.Sprintf("could not find %q in your environment", envCoinbaseAPISecret))
-	}
-	if len(errorsList) > 0 {
-		return nil, errors.New(strings.Join(errorsList, "\n"))
+	creds, err := EnvCredentialsProvider().Credentials()
+	if err != nil {
+		return nil, err
 	}
-
-	// Passphrase is an optional field that's only used when
-	// purchasing, canceling and viewing private content.
-	passphrase := strings.TrimSpace(os.Getenv(envCoinbasePassphrase))
-
-	return &Client{apiKey: apiKey, apiSecret: apiSecret, passphrase: passphrase}, nil
+	return NewClient(creds)
 }
 
 const (
@@ -127,30 +146,25 @@ func (c *Client) signAndSetHeaders(req *http.Request) {
 	// * CB-ACCESS-SIGN:
 	//    + HMAC(timestamp + method + requestPath + body)
 	// * CB-ACCESS-TIMESTAMP: Number of seconds since Unix Epoch of the request
-	timestamp := time.Now().Unix()
+	timestamp := c.now().Unix()
+	// The credentials are read together so that a concurrent
+	// rotation can't mix the old and new ones in a signature.
+	c.mu.RLock()
+	apiKey, apiSecret, passphrase := c.apiKey, c.apiSecret, c.passphrase
+	c.mu.RUnlock()
 	req.Header.Set(hdrVersionKey, apiVersion)
 	req.Header.Set(hdrTimestampKey, fmt.Sprintf("%d", timestamp))
-	if c.passphrase != "" {
-		req.Header.Set(hdrPassphraseKey, c.passphrase)
+	if passphrase != "" {
+		req.Header.Set(hdrPassphraseKey, passphrase)
 	}
-	req.Header.Set(hdrAPIKeyKey, c.apiKey)
-	req.Header.Set(hdrSignatureKey, c.hmacSignature(req, timestamp))
+	req.Header.Set(hdrAPIKeyKey, apiKey)
+	req.Header.Set(hdrSignatureKey, hmacSignature(req, apiSecret, timestamp))
 }
 
-func (c *Client) hmacSignature(req *http.Request, timestampUnix int64) string {
-	var body []byte
-	if req.Body != nil {
-		body, _ = ioutil.ReadAll(req.Body)
-		// And we have to reconstruct the body now
-		prc, pwc := io.Pipe()
-		go func() {
-			defer pwc.Close()
-			pwc.Write(body)
-		}()
-		req.Body = prc
-	}
+func hmacSignature(req *http.Request, apiSecret string, timestampUnix int64) string {
+	body, _ := requestBody(req)
 
-	mac := hmac.New(sha256.New, []byte(c.apiSecret))
+	mac := hmac.New(sha256.New, []byte(apiSecret))
 	urlPath := req.URL.Path
 	if q := req.URL.Query(); len(q) > 0 {
 		urlPath += "?" + q.Encode()
@@ -160,6 +174,54 @@ func (c *Client) hmacSignature(req *http.Request, timestampUnix int64) string {
 	return fmt.Sprintf("%x", mac.Sum(nil))
 }
 
+// newRequest creates a request whose body is the JSON
+// serialization of body, unless body is nil. The resulting
+// request has GetBody and ContentLength set so that its body
+// can be read for signing and then replayed on redirects and retries.
+func newRequest(method, fullURL string, body interface{}) (*http.Request, error) {
+	if body == nil {
+		return http.NewRequest(method, fullURL, nil)
+	}
+	blob, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, fullURL, bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// requestBody returns the body of the request without consuming
+// it. For requests whose body can't be replayed i.e. they don't have
+// GetBody set, the body is read once and then buffered into the request.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		req.Body, _ = req.GetBody()
+		return body, nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
 func (c *Client) SetHTTPRoundTripper(rt http.RoundTripper) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -177,15 +239,116 @@ func (c *Client) httpClient() *http.Client {
 	return &http.Client{Transport: rt}
 }
 
-func (c *Client) doAuthAndReq(req *http.Request) ([]byte, http.Header, error) {
-	c.signAndSetHeaders(req)
-	return c.doHTTPReq(req)
+func (c *Client) doAuthAndReq(name string, req *http.Request) ([]byte, http.Header, error) {
+	signer, authKind := c.getSigner()
+	if authKind == AuthOAuth2 && req.URL.Host == exchangeHost {
+		return nil, nil, errOAuth2Exchange
+	}
+	return c.doCall(name, authKind, req, signer.Sign)
+}
+
+func (c *Client) doHTTPReq(name string, req *http.Request) ([]byte, http.Header, error) {
+	return c.doCall(name, AuthNone, req, nil)
 }
 
-func (c *Client) doHTTPReq(req *http.Request) ([]byte, http.Header, error) {
+// maxAttempts is how many times a call is sent while the
+// exchange rejects it for exceeding its rate limit.
+const maxAttempts = 3
+
+// doCall signs req with sign, if set, and then sends it, sending
+// it again, signed anew, while the exchange answers 429 Too Many
+// Requests. The call hooks see every attempt including those failing
+// to be signed, such as on OAuth2 refresh errors, and its latency
+// includes the signing.
+func (c *Client) doCall(name string, authKind AuthKind, req *http.Request, sign func(*http.Request) error) ([]byte, http.Header, error) {
+	for attempt := 1; ; attempt++ {
+		blob, hdr, statusCode, err := c.sendAttempt(name, authKind, req, sign, attempt)
+		if statusCode != http.StatusTooManyRequests || attempt >= maxAttempts || !rewindBody(req) {
+			return blob, hdr, err
+		}
+		time.Sleep(retryAfter(hdr, attempt))
+	}
+}
+
+func (c *Client) sendAttempt(name string, authKind AuthKind, req *http.Request, sign func(*http.Request) error, attempt int) ([]byte, http.Header, int, error) {
+	hooks := c.getCallHooks()
+	start := time.Now()
+	var signErr error
+	if sign != nil {
+		signErr = sign(req)
+	}
+	if len(hooks) == 0 {
+		if signErr != nil {
+			return nil, nil, 0, signErr
+		}
+		return c.roundTrip(req)
+	}
+
+	ci := &CallInfo{
+		Name:       name,
+		HTTPMethod: req.Method,
+		Endpoint:   endpoint(req),
+		AuthKind:   authKind,
+		Attempt:    attempt,
+		Header:     redactHeader(req.Header),
+	}
+	dones := make([]func(*CallResult), 0, len(hooks))
+	for _, hook := range hooks {
+		if done := hook(ci); done != nil {
+			dones = append(dones, done)
+		}
+	}
+
+	var blob []byte
+	var hdr http.Header
+	var statusCode int
+	err := signErr
+	if err == nil {
+		blob, hdr, statusCode, err = c.roundTrip(req)
+	}
+	cr := &CallResult{
+		StatusCode: statusCode,
+		Latency:    time.Since(start),
+		Err:        err,
+	}
+	// Invoke the done functions in reverse order
+	// so that nested spans are ended innermost first.
+	for i := len(dones) - 1; i >= 0; i-- {
+		dones[i](cr)
+	}
+	return blob, hdr, statusCode, err
+}
+
+// rewindBody reports whether req can be sent again,
+// resetting its body to the start if it has one.
+func rewindBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// retryAfter returns how long to wait before the next attempt,
+// as the exchange's Retry-After header asks or else backing off.
+func retryAfter(hdr http.Header, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(hdr.Get("Retry-After")); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return time.Duration(attempt) * time.Second / 2
+}
+
+func (c *Client) roundTrip(req *http.Request) ([]byte, http.Header, int, error) {
 	res, err := c.httpClient().Do(req)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, 0, err
 	}
 	if res.Body != nil {
 		defer res.Body.Close()
@@ -196,21 +359,14 @@ func (c *Client) doHTTPReq(req *http.Request) ([]byte, http.Header, error) {
 		if res.Body != nil {
 			slurp, err = ioutil.ReadAll(res.Body)
 		}
-		return slurp, res.Header, err
+		return slurp, res.Header, res.StatusCode, err
 	}
 
 	// Otherwise we've encountered an error
-	if res.Body == nil {
-		err = errors.New(res.Status)
-	} else {
-		var slurp []byte
-		slurp, err = ioutil.ReadAll(res.Body)
-		if err != nil {
-			err = errors.New(res.Status)
-		} else if len(slurp) > 3 {
-			err = errors.New(string(slurp))
-		}
+	var slurp []byte
+	if res.Body != nil {
+		slurp, _ = ioutil.ReadAll(res.Body)
 	}
 
-	return nil, res.Header, err
+	return nil, res.Header, res.StatusCode, newAPIError(res, slurp)
 }
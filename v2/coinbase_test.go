@@ -19,17 +19,23 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/wsu"
 )
 
 func TestMyProfile(t *testing.T) {
@@ -539,6 +545,8 @@ const (
 
 	exchangeRateRoute = "/rate"
 	cancelOrderRoute  = "/cancel-order"
+
+	timeRoute = "/time"
 )
 
 type profileWrap struct {
@@ -621,6 +629,8 @@ func (b *backend) RoundTrip(req *http.Request) (*http.Response, error) {
 		return b.orderRoundTrip(req)
 	case cancelOrderRoute:
 		return b.cancelOrderRoundTrip(req)
+	case timeRoute:
+		return b.timeRoundTrip(req)
 	default:
 		return makeResp("no such route", http.StatusNotFound, nil), nil
 	}
@@ -803,6 +813,13 @@ func (b *backend) exchangeRateRoundTrip(req *http.Request) (*http.Response, erro
 	return makeResp("OK", http.StatusOK, f), nil
 }
 
+func (b *backend) timeRoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return makeResp(`only accepting method "GET"`, http.StatusMethodNotAllowed, nil), nil
+	}
+	return makeRespFromFile("./testdata/time.json")
+}
+
 func (b *backend) deleteAccountRoundTrip(req *http.Request) (*http.Response, error) {
 	if req.Method != "DELETE" {
 		return makeResp(`only accepting method "DELETE"`, http.StatusMethodNotAllowed, nil), nil
@@ -1037,10 +1054,12 @@ func (b *backend) userProfileRoundTrip(req *http.Request) (*http.Response, error
 
 var (
 	key1 = &coinbase.Credentials{APIKey: "unoKey", APISecret: "unoSecret$", Passphrase: "^Foo$Bar<"}
+	key2 = &coinbase.Credentials{APIKey: "dosKey", APISecret: "dosSecret$", Passphrase: "^Bar$Foo<"}
 )
 
 var keyToAccessKey = map[string]*coinbase.Credentials{
 	key1.APIKey: key1,
+	key2.APIKey: key2,
 }
 
 func makeResp(status string, code int, body io.ReadCloser) *http.Response {
@@ -1077,12 +1096,7 @@ func (b *backend) badAuthCheck(req *http.Request) *http.Response {
 		}
 
 		// Now replace the slurped body
-		prc, pwc := io.Pipe()
-		go func() {
-			defer pwc.Close()
-			pwc.Write(body)
-		}()
-		req.Body = prc
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
 	}
 
 	mac := hmac.New(sha256.New, []byte(akey.APISecret))
@@ -1102,14 +1116,28 @@ func (b *backend) badAuthCheck(req *http.Request) *http.Response {
 func TestExchangeRate(t *testing.T) {
 	rt := &backend{route: exchangeRateRoute}
 	tests := [...]struct {
-		from    coinbase.Currency
-		wantErr bool
+		from        coinbase.Currency
+		wantErr     bool
+		wantRates   []coinbase.Currency
+		wantMissing []coinbase.Currency
 	}{
-		0: {coinbase.USD, false},
-		1: {"unknown", true},
-		2: {"LTC-USD", false},
-		3: {"LTC-USD-BTC-ETH", false},
-		4: {"", false}, // Must return the default currency
+		0: {from: coinbase.USD},
+		1: {from: "unknown", wantErr: true},
+		2: {from: "LTC-USD", wantRates: []coinbase.Currency{coinbase.USD}},
+		3: {from: "LTC-USD-BTC-ETH", wantRates: []coinbase.Currency{coinbase.USD, coinbase.BTC, coinbase.ETH}},
+		4: {from: ""}, // Must return the default currency
+		5: {
+			from:        "LTC-USD-XYZ",
+			wantRates:   []coinbase.Currency{coinbase.USD},
+			wantMissing: []coinbase.Currency{"XYZ"},
+		},
+		6: {from: "ltc-usd", wantRates: []coinbase.Currency{coinbase.USD}},
+		7: {from: "LTC--USD", wantErr: true},
+		8: {from: "LTC-USD-LTC", wantErr: true},
+		9: {from: "LTC-U$D", wantErr: true},
+
+		// The API responded with an error payload but a 200 status.
+		10: {from: "ERR", wantErr: true},
 	}
 	client := new(coinbase.Client)
 	client.SetHTTPRoundTripper(rt)
@@ -1130,6 +1158,29 @@ func TestExchangeRate(t *testing.T) {
 
 		if resp == nil || len(resp.Rates) == 0 {
 			t.Errorf("#%d: want more than rates", i)
+			continue
+		}
+
+		if len(tt.wantRates) > 0 && len(resp.Rates) != len(tt.wantRates) {
+			t.Errorf("#%d: got %d rates want %d", i, len(resp.Rates), len(tt.wantRates))
+		}
+		for _, curr := range tt.wantRates {
+			pair, ok := resp.RatePair(curr)
+			if !ok {
+				t.Errorf("#%d: expected a rate for %q", i, curr)
+				continue
+			}
+			if pair.To != curr || pair.From != resp.From {
+				t.Errorf("#%d: got pair %+v", i, pair)
+			}
+		}
+		if !reflect.DeepEqual(resp.Missing, tt.wantMissing) {
+			t.Errorf("#%d: missing: got=%v want=%v", i, resp.Missing, tt.wantMissing)
+		}
+		for _, curr := range tt.wantMissing {
+			if _, ok := resp.RatePair(curr); ok {
+				t.Errorf("#%d: %q is missing yet has a rate", i, curr)
+			}
 		}
 	}
 }
@@ -1234,3 +1285,1538 @@ func TestCancelOrder(t *testing.T) {
 		}
 	}
 }
+
+// serverEpoch is the epoch in ./testdata/time.json
+const serverEpoch = 1506019620
+
+func TestServerTime(t *testing.T) {
+	client := new(coinbase.Client)
+	client.SetHTTPRoundTripper(&backend{route: timeRoute})
+
+	st, err := client.ServerTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g, w := st.Epoch, int64(serverEpoch); g != w {
+		t.Errorf("epoch: got=%d want=%d", g, w)
+	}
+	if g, w := st.ISO.Unix(), int64(serverEpoch); g != w {
+		t.Errorf("iso: got=%d want=%d", g, w)
+	}
+
+	tests := [...]struct {
+		clockEpoch int64
+		wantSkew   time.Duration
+	}{
+		0: {serverEpoch, 0},
+		1: {serverEpoch - 45, 45 * time.Second},
+		2: {serverEpoch + 3600, -1 * time.Hour},
+	}
+
+	for i, tt := range tests {
+		clockTime := time.Unix(tt.clockEpoch, 0)
+		client.SetClock(func() time.Time { return clockTime })
+		skew, err := client.EstimateClockSkew()
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if skew != tt.wantSkew {
+			t.Errorf("#%d: skew: got=%v want=%v", i, skew, tt.wantSkew)
+		}
+	}
+}
+
+type headerRecorder struct {
+	rt  http.RoundTripper
+	hdr http.Header
+	req *http.Request
+}
+
+func (hr *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	hr.hdr = req.Header
+	hr.req = req
+	return hr.rt.RoundTrip(req)
+}
+
+func TestSignatureWithClockSkew(t *testing.T) {
+	hr := &headerRecorder{rt: &backend{route: myProfileRoute}}
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(hr)
+	client.SetClock(func() time.Time { return time.Unix(serverEpoch-45, 0) })
+	client.SetClockSkew(45 * time.Second)
+
+	if _, err := client.MyProfile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if g, w := hr.hdr.Get("CB-ACCESS-TIMESTAMP"), fmt.Sprintf("%d", serverEpoch); g != w {
+		t.Errorf("timestamp: got=%q want=%q", g, w)
+	}
+	mac := hmac.New(sha256.New, []byte(key1.APISecret))
+	fmt.Fprintf(mac, "%dGET/v2/user", serverEpoch)
+	if g, w := hr.hdr.Get("CB-ACCESS-SIGN"), fmt.Sprintf("%x", mac.Sum(nil)); g != w {
+		t.Errorf("signature: got=%q want=%q", g, w)
+	}
+}
+
+func TestRequestBodyIsReplayable(t *testing.T) {
+	hr := &headerRecorder{rt: &backend{route: orderRoute}}
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(hr)
+
+	order := &coinbase.Order{Product: "BTC-USD", Price: 100, Side: coinbase.SideSell}
+	if _, err := client.Order(order); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := hr.req
+	if g, w := req.Header.Get("Content-Type"), "application/json"; g != w {
+		t.Errorf("Content-Type: got=%q want=%q", g, w)
+	}
+	wantBody, _ := json.Marshal(order)
+	if g, w := req.ContentLength, int64(len(wantBody)); g != w {
+		t.Errorf("ContentLength: got=%d want=%d", g, w)
+	}
+	if req.GetBody == nil {
+		t.Fatal("expected GetBody to have been set")
+	}
+	for i := 0; i < 2; i++ {
+		rc, err := req.GetBody()
+		if err != nil {
+			t.Fatalf("#%d: GetBody: %v", i, err)
+		}
+		gotBody, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(gotBody, wantBody) {
+			t.Errorf("#%d: body: got=%s\nwant=%s", i, gotBody, wantBody)
+		}
+	}
+}
+
+type oauth2Backend struct {
+	accessToken string
+
+	refreshes int
+}
+
+func (ob *oauth2Backend) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Path {
+	case "/oauth/token":
+		if err := req.ParseForm(); err != nil {
+			return makeResp(err.Error(), http.StatusBadRequest, nil), nil
+		}
+		if req.Form.Get("client_secret") != "cs" {
+			return makeResp("invalid client", http.StatusUnauthorized, nil), nil
+		}
+		switch req.Form.Get("grant_type") {
+		case "authorization_code":
+			if req.Form.Get("code") != "code1" {
+				return makeResp("invalid code", http.StatusUnauthorized, nil), nil
+			}
+		case "refresh_token":
+			if req.Form.Get("refresh_token") != "refresh1" {
+				return makeResp("invalid refresh token", http.StatusUnauthorized, nil), nil
+			}
+			ob.refreshes += 1
+		default:
+			return makeResp("unsupported grant_type", http.StatusBadRequest, nil), nil
+		}
+		ob.accessToken = fmt.Sprintf("access%d", ob.refreshes+1)
+		body := fmt.Sprintf(`{"access_token":%q,"token_type":"bearer","expires_in":7200,"refresh_token":"refresh1","scope":"wallet:user:read wallet:accounts:read"}`, ob.accessToken)
+		return makeResp("200 OK", http.StatusOK, ioutil.NopCloser(strings.NewReader(body))), nil
+
+	case "/v2/user":
+		if g, w := req.Header.Get("Authorization"), "Bearer "+ob.accessToken; g != w {
+			return makeResp(fmt.Sprintf("got Authorization %q want %q", g, w), http.StatusUnauthorized, nil), nil
+		}
+		if sig := req.Header.Get("CB-ACCESS-SIGN"); sig != "" {
+			return makeResp("unexpected HMAC signature", http.StatusBadRequest, nil), nil
+		}
+		return makeRespFromFile(profileIDPath(profID1))
+
+	default:
+		return makeResp("no such route", http.StatusNotFound, nil), nil
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	ob := new(oauth2Backend)
+	var refreshed []*coinbase.Token
+	config := &coinbase.OAuth2Config{
+		ClientID:     "ci",
+		ClientSecret: "cs",
+		RedirectURL:  "https://example.org/callback",
+		Scopes:       []coinbase.Scope{coinbase.ScopeUserRead, coinbase.ScopeAccountsRead},
+		RoundTripper: ob,
+		OnRefresh:    func(tok *coinbase.Token) { refreshed = append(refreshed, tok) },
+	}
+
+	authURL := config.AuthCodeURL("state1")
+	for _, want := range []string{"client_id=ci", "state=state1", "response_type=code", "scope=wallet%3Auser%3Aread%2Cwallet%3Aaccounts%3Aread"} {
+		if !strings.Contains(authURL, want) {
+			t.Errorf("AuthCodeURL: %q does not contain %q", authURL, want)
+		}
+	}
+
+	if _, err := config.Exchange("bad-code"); err == nil {
+		t.Errorf("expected an error when exchanging an invalid code")
+	}
+	tok, err := config.Exchange("code1")
+	if err != nil {
+		t.Fatalf("exchange: unexpected error: %v", err)
+	}
+	if !tok.Valid() || !tok.HasScope(coinbase.ScopeUserRead) || tok.HasScope(coinbase.ScopeAccountsDelete) {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+
+	// Expire the token to force a refresh.
+	tok.Expiry = time.Now().Add(-1 * time.Minute)
+	client, err := coinbase.NewOAuth2Client(config.TokenSource(tok))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.SetHTTPRoundTripper(ob)
+
+	for i := 0; i < 2; i++ {
+		profile, err := client.MyProfile()
+		if err != nil {
+			t.Fatalf("#%d: profile: unexpected error: %v", i, err)
+		}
+		if g, w := jsonify(profile), jsonify(profileFromFile(profID1)); !bytes.Equal(g, w) {
+			t.Errorf("#%d: got =%s\nwant=%s", i, g, w)
+		}
+	}
+	if g, w := len(refreshed), 1; g != w {
+		t.Errorf("refreshes: got=%d want=%d", g, w)
+	}
+
+	// Deleting accounts requires a scope that wasn't granted.
+	err = client.DeleteAccountByID(accountID1)
+	if err == nil || !strings.Contains(err.Error(), string(coinbase.ScopeAccountsDelete)) {
+		t.Errorf("expected a missing scope error, got %v", err)
+	}
+
+	// The exchange's endpoints don't accept bearer tokens.
+	client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected request to %s", req.URL)
+		return makeResp("OK", http.StatusOK, nil), nil
+	}))
+	if _, err := client.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: 4000, Size: 1}); err == nil {
+		t.Error("Order: expected an error")
+	}
+	if err := client.CancelOrder(orderID1); err == nil {
+		t.Error("CancelOrder: expected an error")
+	}
+	if _, err := client.FindOrderByID(orderID1); err == nil {
+		t.Error("FindOrderByID: expected an error")
+	}
+	if _, err := client.OrderFills(orderID1); err == nil {
+		t.Error("OrderFills: expected an error")
+	}
+	ores, err := client.ListOrders(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page := <-ores.PagesChan; page == nil || page.Err == nil {
+		t.Errorf("ListOrders: expected an error, got %+v", page)
+	}
+}
+
+func TestFileCredentialsProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coinbase-creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := [...]struct {
+		filename string
+		body     string
+		want     *coinbase.Credentials
+		wantErr  bool
+	}{
+		0: {
+			filename: "creds.json",
+			body:     `{"api_key":"unoKey","api_secret":"unoSecret$","passphrase":"^Foo$Bar<"}`,
+			want:     key1,
+		},
+		1: {
+			filename: "creds.yaml",
+			body:     "# rotated weekly\napi_key: unoKey\napi_secret: 'unoSecret$'\npassphrase: \"^Foo$Bar<\"\n",
+			want:     key1,
+		},
+		2: {filename: "creds.yml", body: "api_key: unoKey\n", wantErr: true},
+		3: {filename: "creds.json", body: `{"api_key":`, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%d-%s", i, tt.filename))
+		if err := ioutil.WriteFile(path, []byte(tt.body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fcp := &coinbase.FileCredentialsProvider{Path: path}
+		creds, err := fcp.Credentials()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: want non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(creds, tt.want) {
+			t.Errorf("#%d: got=%+v want=%+v", i, creds, tt.want)
+		}
+	}
+}
+
+type rotatingProvider struct {
+	mu    sync.Mutex
+	creds *coinbase.Credentials
+}
+
+func (rp *rotatingProvider) Credentials() (*coinbase.Credentials, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.creds, nil
+}
+
+func TestCredentialsRotation(t *testing.T) {
+	rp := &rotatingProvider{creds: &coinbase.Credentials{APIKey: "stale", APISecret: "stale"}}
+	client, err := coinbase.NewClientFromProvider(rp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.SetHTTPRoundTripper(&backend{route: myProfileRoute})
+	if _, err := client.MyProfile(); err == nil {
+		t.Fatal("expected the stale credentials to be rejected")
+	}
+
+	cancel, err := client.StartCredentialsRotation(&coinbase.CredentialsRotation{
+		Provider: rp,
+		Interval: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cancel()
+
+	rp.mu.Lock()
+	rp.creds = key1
+	rp.mu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err := client.MyProfile()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated credentials were never picked up: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSigningDuringRotation(t *testing.T) {
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(&backend{route: myProfileRoute})
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				client.SetCredentials(key2)
+			} else {
+				client.SetCredentials(key1)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for i := 0; i < 2000; i++ {
+		// A signature mixing the key of one credential and the
+		// secret of the other would be rejected by the backend.
+		if _, err := client.MyProfile(); err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestSetSigner(t *testing.T) {
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(&backend{route: myProfileRoute})
+	client.SetSigner(coinbase.SignerFunc(func(req *http.Request) error {
+		req.Header.Set("CB-ACCESS-KEY", "custom")
+		return nil
+	}))
+	if _, err := client.MyProfile(); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("expected the custom signer's headers to have been used, got err=%v", err)
+	}
+
+	// Restoring the default signer.
+	client.SetSigner(nil)
+	if _, err := client.MyProfile(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCallHooks(t *testing.T) {
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(&backend{route: cancelOrderRoute})
+
+	var infos []*coinbase.CallInfo
+	var results []*coinbase.CallResult
+	client.AddCallHooks(func(ci *coinbase.CallInfo) func(*coinbase.CallResult) {
+		infos = append(infos, ci)
+		return func(cr *coinbase.CallResult) {
+			results = append(results, cr)
+		}
+	})
+
+	if err := client.CancelOrder(orderID1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := client.CancelOrder("unknown-order")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	apiErr, ok := err.(*coinbase.APIError)
+	if !ok {
+		t.Fatalf("got error of type %T want *coinbase.APIError", err)
+	}
+	if g, w := apiErr.StatusCode, http.StatusUnauthorized; g != w {
+		t.Errorf("APIError status code: got=%d want=%d", g, w)
+	}
+
+	if g, w := len(infos), 2; g != w {
+		t.Fatalf("infos: got=%d want=%d", g, w)
+	}
+	if g, w := len(results), 2; g != w {
+		t.Fatalf("results: got=%d want=%d", g, w)
+	}
+	for i, ci := range infos {
+		if ci.Name != "CancelOrder" || ci.HTTPMethod != "DELETE" || ci.AuthKind != coinbase.AuthAPIKey || ci.Attempt != 1 {
+			t.Errorf("#%d: unexpected call info: %+v", i, ci)
+		}
+		for _, key := range []string{"CB-ACCESS-SIGN", "CB-ACCESS-PASSPHRASE"} {
+			if g, w := ci.Header.Get(key), "REDACTED"; g != w {
+				t.Errorf("#%d: header %q: got=%q want=%q", i, key, g, w)
+			}
+		}
+	}
+	if g, w := results[0].StatusCode, http.StatusOK; g != w || results[0].Err != nil {
+		t.Errorf("#0: got status=%d err=%v want status=%d", g, results[0].Err, w)
+	}
+	if g, w := results[1].StatusCode, http.StatusUnauthorized; g != w || results[1].Err == nil {
+		t.Errorf("#1: got status=%d err=%v want status=%d", g, results[1].Err, w)
+	}
+}
+
+func TestBlankOrderID(t *testing.T) {
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected request to %s", req.URL)
+		return makeResp("OK", http.StatusOK, nil), nil
+	}))
+	for _, id := range []string{"", "  "} {
+		if ores, err := client.FindOrderByID(id); err == nil {
+			t.Errorf("FindOrderByID(%q): expected an error, got %+v", id, ores)
+		}
+		if fills, err := client.OrderFills(id); err == nil {
+			t.Errorf("OrderFills(%q): expected an error, got %+v", id, fills)
+		}
+	}
+}
+
+func TestCallHooksSeeRetries(t *testing.T) {
+	var bodies, signatures []string
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		signatures = append(signatures, req.Header.Get("CB-ACCESS-SIGN"))
+		if len(bodies) == 1 {
+			res := makeResp("429 Too Many Requests", http.StatusTooManyRequests, ioutil.NopCloser(strings.NewReader(`{"message":"Rate limit exceeded"}`)))
+			res.Header.Set("Retry-After", "0")
+			return res, nil
+		}
+		return makeResp("200 OK", http.StatusOK, ioutil.NopCloser(strings.NewReader(`{"id":"o1"}`))), nil
+	}))
+
+	var infos []*coinbase.CallInfo
+	var results []*coinbase.CallResult
+	client.AddCallHooks(func(ci *coinbase.CallInfo) func(*coinbase.CallResult) {
+		infos = append(infos, ci)
+		return func(cr *coinbase.CallResult) {
+			results = append(results, cr)
+		}
+	})
+
+	ores, err := client.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: 4000, Size: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ores.ID != "o1" {
+		t.Errorf("order id: got=%q want=%q", ores.ID, "o1")
+	}
+	if g, w := len(infos), 2; g != w {
+		t.Fatalf("infos: got=%d want=%d", g, w)
+	}
+	for i, ci := range infos {
+		if g, w := ci.Attempt, i+1; g != w {
+			t.Errorf("#%d: attempt: got=%d want=%d", i, g, w)
+		}
+	}
+	if g, w := results[0].StatusCode, http.StatusTooManyRequests; g != w || results[0].Err == nil {
+		t.Errorf("#0: got status=%d err=%v want status=%d", g, results[0].Err, w)
+	}
+	if g, w := results[1].StatusCode, http.StatusOK; g != w || results[1].Err != nil {
+		t.Errorf("#1: got status=%d err=%v want status=%d", g, results[1].Err, w)
+	}
+	if bodies[0] == "" || bodies[1] != bodies[0] {
+		t.Errorf("expected the same body to be sent again, got %q", bodies)
+	}
+	if signatures[1] == "" {
+		t.Error("expected the retry to be signed")
+	}
+}
+
+func TestCallHooksSeeSigningFailures(t *testing.T) {
+	client := new(coinbase.Client)
+	client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("unexpected request, the call should have failed to be signed")
+		return makeResp("OK", http.StatusOK, nil), nil
+	}))
+	errRefresh := errors.New("refresh failed")
+	client.SetSigner(coinbase.SignerFunc(func(req *http.Request) error {
+		return errRefresh
+	}))
+
+	var infos []*coinbase.CallInfo
+	var results []*coinbase.CallResult
+	client.AddCallHooks(func(ci *coinbase.CallInfo) func(*coinbase.CallResult) {
+		infos = append(infos, ci)
+		return func(cr *coinbase.CallResult) {
+			results = append(results, cr)
+		}
+	})
+
+	if _, err := client.MyProfile(); err != errRefresh {
+		t.Fatalf("got err=%v want %v", err, errRefresh)
+	}
+	if g, w := len(infos), 1; g != w {
+		t.Fatalf("infos: got=%d want=%d", g, w)
+	}
+	if g, w := len(results), 1; g != w {
+		t.Fatalf("results: got=%d want=%d", g, w)
+	}
+	if infos[0].Attempt != 1 {
+		t.Errorf("attempt: got=%d want=1", infos[0].Attempt)
+	}
+	if results[0].Err != errRefresh || results[0].StatusCode != 0 {
+		t.Errorf("unexpected result: %+v", results[0])
+	}
+}
+
+func TestPublicRateLimitIsShared(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	client := new(coinbase.Client)
+	client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requests += 1
+		mu.Unlock()
+		return makeResp("OK", http.StatusOK, ioutil.NopCloser(strings.NewReader(`{}`))), nil
+	}))
+	// 20 requests per second with bursts of 40.
+	client.SetPublicRateLimit(20)
+
+	var productIDs []string
+	for i := 0; i < 25; i++ {
+		productIDs = append(productIDs, fmt.Sprintf("P%d-USD", i))
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := client.Tickers(productIDs...); err != nil {
+			t.Errorf("tickers: unexpected error: %v", err)
+		}
+	}()
+	for _, productID := range productIDs {
+		if _, err := client.Stats(productID); err != nil {
+			t.Errorf("stats: unexpected error: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if g, w := requests, 50; g != w {
+		t.Errorf("requests: got=%d want=%d", g, w)
+	}
+	// The 10 requests past the burst take at least half a second.
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("the concurrent calls weren't limited together, took %s", elapsed)
+	}
+}
+
+func TestAPIErrorDecoding(t *testing.T) {
+	tests := [...]struct {
+		body    string
+		wantID  string
+		wantErr string
+	}{
+		0: {`{"errors":[{"id":"not_found","message":"Not found"}]}`, "not_found", "not_found: Not found"},
+		1: {`{"message":"Insufficient funds"}`, "", "Insufficient funds"},
+		2: {`upstream timeout`, "", "upstream timeout"},
+		3: {``, "", "502 Bad Gateway"},
+	}
+
+	for i, tt := range tests {
+		body := tt.body
+		client := new(coinbase.Client)
+		client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return makeResp("502 Bad Gateway", http.StatusBadGateway, ioutil.NopCloser(strings.NewReader(body))), nil
+		}))
+		_, err := client.Ticker("BTC-USD")
+		apiErr, ok := err.(*coinbase.APIError)
+		if !ok {
+			t.Errorf("#%d: got error of type %T want *coinbase.APIError", i, err)
+			continue
+		}
+		if apiErr.ID != tt.wantID {
+			t.Errorf("#%d: ID: got=%q want=%q", i, apiErr.ID, tt.wantID)
+		}
+		if g, w := apiErr.Error(), tt.wantErr; g != w {
+			t.Errorf("#%d: got=%q want=%q", i, g, w)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (rtf roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rtf(req)
+}
+
+type candleWindow struct {
+	start, end  string
+	granularity string
+}
+
+func TestCandleStickWindows(t *testing.T) {
+	start := time.Date(2017, 9, 21, 18, 0, 30, 0, time.UTC)
+	tests := [...]struct {
+		req         *coinbase.CandleStickRequest
+		wantErr     bool
+		wantWindows []candleWindow
+	}{
+		0: {
+			req:     &coinbase.CandleStickRequest{Product: "BTC-USD", GranularityInSeconds: 30},
+			wantErr: true,
+		},
+		1: {
+			req:     &coinbase.CandleStickRequest{Product: "BTC-USD", GranularityInSeconds: 7200},
+			wantErr: true,
+		},
+		2: {
+			// 600 one-minute buckets from an unaligned start need
+			// three requests: the end bucket is inclusive.
+			req: &coinbase.CandleStickRequest{
+				Product: "BTC-USD", StartTime: start, EndTime: start.Add(600 * time.Minute),
+				GranularityInSeconds: coinbase.GranularityOneMinute,
+			},
+			wantWindows: []candleWindow{
+				{"2017-09-21T18:00:00.00000Z", "2017-09-21T22:59:00.00000Z", "60"},
+				{"2017-09-21T23:00:00.00000Z", "2017-09-22T03:59:00.00000Z", "60"},
+				{"2017-09-22T04:00:00.00000Z", "2017-09-22T04:00:30.00000Z", "60"},
+			},
+		},
+		3: {
+			req: &coinbase.CandleStickRequest{
+				Product: "BTC-USD", StartTime: start, EndTime: start.Add(48 * time.Hour),
+				GranularityInSeconds: coinbase.GranularityOneHour,
+			},
+			wantWindows: []candleWindow{
+				{"2017-09-21T18:00:00.00000Z", "2017-09-23T18:00:30.00000Z", "3600"},
+			},
+		},
+		4: {
+			// Without a time range a single page is requested.
+			req: &coinbase.CandleStickRequest{
+				Product: "BTC-USD", GranularityInSeconds: coinbase.GranularityOneDay,
+			},
+			wantWindows: []candleWindow{{"", "", "86400"}},
+		},
+		5: {
+			// MaxPageNumber caps the pages fetched.
+			req: &coinbase.CandleStickRequest{
+				Product: "BTC-USD", StartTime: start, EndTime: start.Add(600 * time.Minute),
+				MaxPageNumber: 1,
+			},
+			wantWindows: []candleWindow{
+				{"2017-09-21T18:00:00.00000Z", "2017-09-21T22:59:00.00000Z", "60"},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		var mu sync.Mutex
+		var windows []candleWindow
+		client := new(coinbase.Client)
+		client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			qv := req.URL.Query()
+			mu.Lock()
+			windows = append(windows, candleWindow{qv.Get("start"), qv.Get("end"), qv.Get("granularity")})
+			mu.Unlock()
+			return makeResp("200 OK", http.StatusOK, ioutil.NopCloser(strings.NewReader("[]"))), nil
+		}))
+
+		tt.req.ThrottleDurationMs = coinbase.NoThrottle
+		res, err := client.CandleSticks(tt.req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		for page := range res.PagesChan {
+			if page.Err != nil {
+				t.Errorf("#%d: page #%d: %v", i, page.PageNumber, page.Err)
+			}
+		}
+
+		// Pages are fetched concurrently so compare them in order.
+		sort.Slice(windows, func(i, j int) bool { return windows[i].start < windows[j].start })
+		if !reflect.DeepEqual(windows, tt.wantWindows) {
+			t.Errorf("#%d:\ngot= %v\nwant=%v", i, windows, tt.wantWindows)
+		}
+	}
+}
+
+func TestOrderedCandleSticks(t *testing.T) {
+	start := time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+	unix := start.Unix()
+	candle := func(offsetMinutes int64) string {
+		return fmt.Sprintf("[%d,1,2,1.5,1.7,10]", unix+offsetMinutes*60)
+	}
+	// Responses are newest first as the exchange sends them, the first
+	// window repeats a candle of the second and buckets are missing.
+	bodies := map[string]string{
+		"2017-09-21T18:00:00.00000Z": "[" + strings.Join([]string{candle(300), candle(299), candle(1), candle(0)}, ",") + "]",
+		"2017-09-21T23:00:00.00000Z": "[" + strings.Join([]string{candle(302), candle(300)}, ",") + "]",
+		"2017-09-22T04:00:00.00000Z": "[" + candle(600) + "]",
+	}
+
+	client := new(coinbase.Client)
+	client.SetHTTPRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		qstart := req.URL.Query().Get("start")
+		if qstart == "2017-09-21T18:00:00.00000Z" {
+			// Delay the first page so that it arrives last.
+			time.Sleep(50 * time.Millisecond)
+		}
+		return makeResp("200 OK", http.StatusOK, ioutil.NopCloser(strings.NewReader(bodies[qstart]))), nil
+	}))
+
+	res, err := client.CandleSticks(&coinbase.CandleStickRequest{
+		Product:              "BTC-USD",
+		StartTime:            start,
+		EndTime:              start.Add(600 * time.Minute),
+		GranularityInSeconds: coinbase.GranularityOneMinute,
+		ThrottleDurationMs:   coinbase.NoThrottle,
+		Ordered:              true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minute := func(m int64) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	wantGaps := [][]*coinbase.CandleGap{
+		0: {{StartTime: minute(2), EndTime: minute(298)}},
+		1: {{StartTime: minute(301), EndTime: minute(301)}, {StartTime: minute(303), EndTime: minute(599)}},
+		2: nil,
+	}
+	var pageNumbers []int64
+	var times []int64
+	for page := range res.PagesChan {
+		if page.Err != nil {
+			t.Fatalf("page #%d: %v", page.PageNumber, page.Err)
+		}
+		i := len(pageNumbers)
+		pageNumbers = append(pageNumbers, page.PageNumber)
+		for _, cs := range page.CandleSticks {
+			times = append(times, (int64(cs.Time)-unix)/60)
+		}
+		if i < len(wantGaps) && !reflect.DeepEqual(page.Gaps, wantGaps[i]) {
+			t.Errorf("page #%d gaps:\ngot= %s\nwant=%s", page.PageNumber, blobify(page.Gaps), blobify(wantGaps[i]))
+		}
+	}
+	if g, w := pageNumbers, []int64{1, 2, 3}; !reflect.DeepEqual(g, w) {
+		t.Errorf("page numbers: got=%v want=%v", g, w)
+	}
+	if g, w := times, []int64{0, 1, 299, 300, 302, 600}; !reflect.DeepEqual(g, w) {
+		t.Errorf("candle minutes: got=%v want=%v", g, w)
+	}
+}
+
+func blobify(v interface{}) string {
+	blob, _ := json.Marshal(v)
+	return string(blob)
+}
+
+func TestCandleStickJSON(t *testing.T) {
+	blob, err := ioutil.ReadFile("./testdata/candles-ETH-USD.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var candles []*coinbase.CandleStick
+	if err := json.Unmarshal(blob, &candles); err != nil {
+		t.Fatal(err)
+	}
+	want := []*coinbase.CandleStick{
+		{Time: 1504371000, Low: 350.21, High: 351.45, Open: 350.74, Close: 351.02, Volume: 52.50721618},
+		{Time: 1504370940, Low: 349.8, High: 350.74, Open: 350.1, Close: 350.74, Volume: 0.00173397},
+		{Time: 1504370880, Low: 349.95, High: 349.95, Open: 349.95, Close: 349.95, Volume: 1.5},
+	}
+	if !reflect.DeepEqual(candles, want) {
+		t.Fatalf("got= %s\nwant=%s", blobify(candles), blobify(want))
+	}
+	for i, cs := range candles {
+		if cs.Low > cs.High {
+			t.Errorf("#%d: low %f is above high %f", i, cs.Low, cs.High)
+		}
+	}
+	if g, w := candles[0].Timestamp(), time.Date(2017, 9, 2, 16, 50, 0, 0, time.UTC); !g.Equal(w) {
+		t.Errorf("timestamp: got=%v want=%v", g, w)
+	}
+
+	// Marshaling emits the object form which unmarshals back.
+	blob, err = json.Marshal(candles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBlob := `{"time":1504371000,"high":351.45,"low":350.21,"open":350.74,"close":351.02,"volume":52.50721618}`
+	if g := string(blob); g != wantBlob {
+		t.Errorf("marshal:\ngot= %s\nwant=%s", g, wantBlob)
+	}
+	roundTripped := new(coinbase.CandleStick)
+	if err := json.Unmarshal(blob, roundTripped); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundTripped, candles[0]) {
+		t.Errorf("round trip: got=%+v want=%+v", roundTripped, candles[0])
+	}
+
+	if err := json.Unmarshal([]byte("[1504371000, 1, 2]"), new(coinbase.CandleStick)); err == nil {
+		t.Errorf("expected an error for a short candle")
+	}
+}
+
+func TestParseFeedMessage(t *testing.T) {
+	at := time.Date(2017, 9, 22, 0, 40, 0, 0, time.UTC)
+	header := func(typ coinbase.Type, seq int64) coinbase.MessageHeader {
+		return coinbase.MessageHeader{Type: typ, Time: at, ProductID: "BTC-USD", Sequence: seq}
+	}
+
+	tests := [...]struct {
+		frame   string
+		want    coinbase.FeedMessage
+		wantErr bool
+	}{
+		0: {
+			frame: `{"type":"received","time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","sequence":10,"order_id":"o1","size":"1.34","price":"502.1","side":"buy","order_type":"limit","client_oid":"c1"}`,
+			want:  &coinbase.Received{MessageHeader: header(coinbase.TypeReceived, 10), OrderID: "o1", OrderType: "limit", Size: 1.34, Price: 502.1, Side: coinbase.SideBuy, ClientOID: "c1"},
+		},
+		1: {
+			frame: `{"type":"received","time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","sequence":11,"order_id":"o2","funds":"3000.23","side":"buy","order_type":"market"}`,
+			want:  &coinbase.Received{MessageHeader: header(coinbase.TypeReceived, 11), OrderID: "o2", OrderType: "market", Funds: 3000.23, Side: coinbase.SideBuy},
+		},
+		2: {
+			frame: `{"type":"open","time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","sequence":12,"order_id":"o1","price":"200.2","remaining_size":"1.00","side":"sell"}`,
+			want:  &coinbase.Open{MessageHeader: header(coinbase.TypeOpen, 12), OrderID: "o1", Price: 200.2, RemainingSize: 1, Side: coinbase.SideSell},
+		},
+		3: {
+			frame: `{"type":"done","time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","sequence":13,"price":"200.2","order_id":"o1","reason":"filled","side":"sell","remaining_size":"0"}`,
+			want:  &coinbase.Done{MessageHeader: header(coinbase.TypeDone, 13), OrderID: "o1", Price: 200.2, Side: coinbase.SideSell, Reason: coinbase.ReasonFilled},
+		},
+		4: {
+			frame: `{"type":"match","trade_id":7,"sequence":14,"maker_order_id":"m1","taker_order_id":"t1","time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","size":"5.23","price":"400.23","side":"sell","user_id":"u1","taker_user_id":"u2"}`,
+			want: &coinbase.Match{
+				MessageHeader: header(coinbase.TypeMatch, 14), TradeID: 7, MakerOrderID: "m1", TakerOrderID: "t1",
+				Size: 5.23, Price: 400.23, Side: coinbase.SideSell, UserID: "u1", TakerUserID: "u2",
+			},
+		},
+		5: {
+			frame: `{"type":"change","time":"2017-09-22T00:40:00Z","sequence":15,"order_id":"o1","product_id":"BTC-USD","new_size":"5.23","old_size":"12.23","price":"400.23","side":"sell"}`,
+			want:  &coinbase.Change{MessageHeader: header(coinbase.TypeChange, 15), OrderID: "o1", NewSize: 5.23, OldSize: 12.23, Price: 400.23, Side: coinbase.SideSell},
+		},
+		6: {
+			frame: `{"type":"activate","product_id":"BTC-USD","timestamp":"1483736448.299000","user_id":"12","profile_id":"p1","order_id":"o3","stop_type":"entry","side":"buy","stop_price":"80","size":"2","funds":"50","taker_fee_rate":"0.0025","private":true}`,
+			want: &coinbase.Activate{
+				MessageHeader: coinbase.MessageHeader{Type: coinbase.TypeActivate, ProductID: "BTC-USD"},
+				OrderID:       "o3", StopType: coinbase.TypeEntry, Side: coinbase.SideBuy, StopPrice: 80, Size: 2, Funds: 50,
+				TakerFeeRate: 0.0025, Private: true, Timestamp: "1483736448.299000", UserID: "12", ProfileID: "p1",
+			},
+		},
+		7: {
+			frame: `{"type":"ticker","trade_id":20,"sequence":16,"time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","price":"4388.01","side":"buy","last_size":"0.03","best_bid":"4388","best_ask":"4388.01"}`,
+			want:  &coinbase.FeedTicker{MessageHeader: header(coinbase.TypeTicker, 16), TradeID: 20, Price: 4388.01, Side: coinbase.SideBuy, LastSize: 0.03, BestBid: 4388, BestAsk: 4388.01},
+		},
+		8: {
+			frame: `{"type":"l2update","product_id":"BTC-USD","time":"2017-09-22T00:40:00Z","changes":[["buy","4000.50","0.16"],["sell","4001","0"]]}`,
+			want: &coinbase.L2Update{
+				MessageHeader: header(coinbase.TypeL2Update, 0),
+				Changes: []*coinbase.L2Change{
+					{Side: coinbase.SideBuy, Price: 4000.5, Size: 0.16},
+					{Side: coinbase.SideSell, Price: 4001, Size: 0},
+				},
+			},
+		},
+		9: {
+			frame: `{"type":"heartbeat","sequence":90,"last_trade_id":20,"product_id":"BTC-USD","time":"2017-09-22T00:40:00Z"}`,
+			want:  &coinbase.Heartbeat{MessageHeader: header(coinbase.TypeHeartbeat, 90), LastTradeID: 20},
+		},
+		10: {
+			frame: `{"type":"error","message":"Failed to subscribe","reason":"BTC-XYZ is not a valid product"}`,
+			want:  &coinbase.FeedError{MessageHeader: coinbase.MessageHeader{Type: coinbase.TypeError}, Message: "Failed to subscribe", Reason: "BTC-XYZ is not a valid product"},
+		},
+		11: {
+			frame: `{"type":"subscriptions","channels":[]}`,
+			want:  &coinbase.UnknownMessage{MessageHeader: coinbase.MessageHeader{Type: "subscriptions"}},
+		},
+		12: {frame: `{"type":"l2update","changes":[["buy","4000.50"]]}`, wantErr: true},
+		13: {frame: `not json`, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		got, err := coinbase.ParseFeedMessage([]byte(tt.frame))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d:\ngot= %s\nwant=%s", i, blobify(got), blobify(tt.want))
+		}
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	// Market orders' funds used to fail decoding into the flat message.
+	frame := `{"type":"received","time":"2017-09-22T00:40:00Z","product_id":"BTC-USD","sequence":11,"order_id":"o2","funds":"3000.23","side":"buy","order_type":"market"}`
+	msg, err := coinbase.ParseMessage([]byte(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := msg.Funds, 3000.23; g != w {
+		t.Errorf("funds: got=%v want=%v", g, w)
+	}
+	received, ok := msg.Payload.(*coinbase.Received)
+	if !ok {
+		t.Fatalf("payload: got=%T want=*coinbase.Received", msg.Payload)
+	}
+	if g, w := received.OrderID, msg.OrderID; g != w {
+		t.Errorf("order id: got=%q want=%q", g, w)
+	}
+}
+
+func TestBufferMessages(t *testing.T) {
+	msg := func(seq int, product string, typ coinbase.Type) *coinbase.Message {
+		return &coinbase.Message{SequenceNumber: seq, ProductID: product, Type: typ, ReceivedAt: time.Now().Add(-time.Second)}
+	}
+	errMsg := &coinbase.Message{SequenceNumber: 5, Err: fmt.Errorf("disconnected")}
+
+	tests := [...]struct {
+		policy coinbase.BackpressurePolicy
+		// The first message is always taken off the buffer
+		// while waiting for the consumer, before the others.
+		msgs          []*coinbase.Message
+		wantSequences []int
+		wantDropped   uint64
+		wantCoalesced uint64
+	}{
+		0: {
+			policy: coinbase.BackpressureDropNewest,
+			msgs: []*coinbase.Message{
+				msg(0, "BTC-USD", coinbase.TypeMatch), msg(1, "BTC-USD", coinbase.TypeMatch), msg(2, "BTC-USD", coinbase.TypeMatch),
+				msg(3, "BTC-USD", coinbase.TypeMatch), msg(4, "BTC-USD", coinbase.TypeMatch), errMsg,
+			},
+			wantSequences: []int{0, 1, 2, 5},
+			wantDropped:   2,
+		},
+		1: {
+			policy: coinbase.BackpressureDropOldest,
+			msgs: []*coinbase.Message{
+				msg(0, "BTC-USD", coinbase.TypeMatch), msg(1, "BTC-USD", coinbase.TypeMatch), msg(2, "BTC-USD", coinbase.TypeMatch),
+				msg(3, "BTC-USD", coinbase.TypeMatch), msg(4, "BTC-USD", coinbase.TypeMatch),
+			},
+			wantSequences: []int{0, 3, 4},
+			wantDropped:   2,
+		},
+		2: {
+			policy: coinbase.BackpressureCoalesce,
+			msgs: []*coinbase.Message{
+				msg(0, "BTC-USD", coinbase.TypeTicker), msg(1, "BTC-USD", coinbase.TypeTicker), msg(2, "ETH-USD", coinbase.TypeTicker),
+				msg(3, "BTC-USD", coinbase.TypeTicker), msg(4, "ETH-USD", coinbase.TypeTicker),
+			},
+			// The later tickers take the places of the earlier ones.
+			wantSequences: []int{0, 3, 4},
+			wantDropped:   2,
+			wantCoalesced: 2,
+		},
+		3: {
+			policy: coinbase.BackpressureCoalesce,
+			msgs: []*coinbase.Message{
+				msg(0, "BTC-USD", coinbase.TypeTicker), msg(1, "BTC-USD", coinbase.TypeTicker), msg(2, "BTC-USD", coinbase.TypeTicker),
+			},
+			// Messages are only coalesced once the buffer is full.
+			wantSequences: []int{0, 1, 2},
+		},
+	}
+
+	for i, tt := range tests {
+		in := make(chan *coinbase.Message)
+		sub, err := coinbase.BufferMessages(coinbase.NewSubscriptionResponse(in, nil), 2, tt.policy)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		in <- tt.msgs[0]
+		waitFor(t, func() bool { s := sub.Stats(); return s.Received == 1 && s.Pending == 0 })
+		for _, msg := range tt.msgs[1:] {
+			in <- msg
+		}
+		waitFor(t, func() bool { return sub.Stats().Received == uint64(len(tt.msgs)) })
+		close(in)
+
+		var sequences []int
+		for msg := range sub.MessagesChan {
+			sequences = append(sequences, msg.SequenceNumber)
+		}
+		if !reflect.DeepEqual(sequences, tt.wantSequences) {
+			t.Errorf("#%d: sequences: got=%v want=%v", i, sequences, tt.wantSequences)
+		}
+		stats := sub.Stats()
+		if g, w := stats.Dropped, tt.wantDropped; g != w {
+			t.Errorf("#%d: dropped: got=%d want=%d", i, g, w)
+		}
+		if g, w := stats.Coalesced, tt.wantCoalesced; g != w {
+			t.Errorf("#%d: coalesced: got=%d want=%d", i, g, w)
+		}
+		if stats.MaxLag < time.Second {
+			t.Errorf("#%d: max lag: got=%v want at least 1s", i, stats.MaxLag)
+		}
+	}
+}
+
+func TestBufferMessagesBlocks(t *testing.T) {
+	in := make(chan *coinbase.Message)
+	closed := make(chan bool, 1)
+	inner := coinbase.NewSubscriptionResponse(in, func() error {
+		closed <- true
+		return nil
+	})
+	sub, err := coinbase.BufferMessages(inner, 2, coinbase.BackpressureBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One message is held for the consumer, two are buffered and one waits
+	// to be, so the next can't be sent until the consumer catches up.
+	for i := 0; i < 4; i++ {
+		in <- &coinbase.Message{SequenceNumber: i}
+	}
+	select {
+	case in <- &coinbase.Message{SequenceNumber: 4}:
+		t.Fatal("expected the buffer to block once full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i := 0; i < 4; i++ {
+		if msg := <-sub.MessagesChan; msg.SequenceNumber != i {
+			t.Errorf("#%d: got sequence %d", i, msg.SequenceNumber)
+		}
+	}
+	if stats := sub.Stats(); stats.Dropped != 0 {
+		t.Errorf("dropped: got=%d want=0", stats.Dropped)
+	}
+
+	// Closing closes the wrapped subscription and the messages channel.
+	sub.Close()
+	if !<-closed {
+		t.Error("expected the wrapped subscription to be closed")
+	}
+	go func() {
+		for range in {
+		}
+	}()
+	for range sub.MessagesChan {
+	}
+	close(in)
+
+	if _, err := coinbase.BufferMessages(inner, 1, "drop-everything"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+	if _, err := coinbase.BufferMessages(inner, -1, coinbase.BackpressureBlock); err == nil {
+		t.Error("expected an error for a negative buffer size")
+	}
+}
+
+func TestBufferMessagesCoalesceKeepsOrderEvents(t *testing.T) {
+	in := make(chan *coinbase.Message)
+	sub, err := coinbase.BufferMessages(coinbase.NewSubscriptionResponse(in, nil), 2, coinbase.BackpressureCoalesce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Once full, the buffer waits for the consumer instead
+	// of coalescing matches of the same product.
+	in <- &coinbase.Message{SequenceNumber: 0, ProductID: "BTC-USD", Type: coinbase.TypeMatch}
+	waitFor(t, func() bool { s := sub.Stats(); return s.Received == 1 && s.Pending == 0 })
+	for i := 1; i < 4; i++ {
+		in <- &coinbase.Message{SequenceNumber: i, ProductID: "BTC-USD", Type: coinbase.TypeMatch}
+	}
+	sent := make(chan bool)
+	go func() {
+		in <- &coinbase.Message{SequenceNumber: 4, ProductID: "BTC-USD", Type: coinbase.TypeMatch}
+		close(in)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("expected the buffer to wait for the consumer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var sequences []int
+	for msg := range sub.MessagesChan {
+		sequences = append(sequences, msg.SequenceNumber)
+	}
+	if g, w := sequences, []int{0, 1, 2, 3, 4}; !reflect.DeepEqual(g, w) {
+		t.Errorf("sequences: got=%v want=%v", g, w)
+	}
+	if stats := sub.Stats(); stats.Dropped != 0 || stats.Coalesced != 0 {
+		t.Errorf("expected nothing dropped, got %+v", stats)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeFeedConn records the frames sent to the feed and
+// receives the frames that the test pushes onto recv.
+type fakeFeedConn struct {
+	sent      chan string
+	recv      chan *wsu.Message
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func newFakeFeedConn() *fakeFeedConn {
+	return &fakeFeedConn{sent: make(chan string, 100), recv: make(chan *wsu.Message), done: make(chan bool)}
+}
+
+func (fc *fakeFeedConn) Send(msg *wsu.Message) { fc.sent <- string(msg.Frame) }
+
+func (fc *fakeFeedConn) Receive() (*wsu.Message, bool) {
+	select {
+	case msg := <-fc.recv:
+		return msg, true
+	case <-fc.done:
+		return nil, false
+	}
+}
+
+func (fc *fakeFeedConn) Close() error {
+	fc.closeOnce.Do(func() { close(fc.done) })
+	return nil
+}
+
+func (fc *fakeFeedConn) sentFrames() []string {
+	var frames []string
+	for {
+		select {
+		case frame := <-fc.sent:
+			frames = append(frames, frame)
+		default:
+			return frames
+		}
+	}
+}
+
+func TestMalformedFrame(t *testing.T) {
+	const frame = `{"type":"match","product_id":"BTC-USD","size":"notanumber"}`
+	for i, typedOnly := range []bool{false, true} {
+		var dials []*fakeFeedConn
+		client := new(coinbase.Client)
+		client.SetFeedDialer(func(feedURL string) (coinbase.FeedConn, error) {
+			fc := newFakeFeedConn()
+			dials = append(dials, fc)
+			return fc, nil
+		})
+		sub, err := client.Subscribe(&coinbase.Subscription{Currencies: []string{"BTC-USD"}, TypedOnly: typedOnly})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hub, err := client.NewHub(&coinbase.HubConfig{TypedOnly: typedOnly})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hubSub, err := hub.Subscribe(&coinbase.HubSubscription{Products: []string{"BTC-USD"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j, msgsChan := range []<-chan *coinbase.Message{sub.MessagesChan, hubSub.MessagesChan} {
+			dials[j].recv <- &wsu.Message{Frame: []byte(frame)}
+			select {
+			case msg := <-msgsChan:
+				if msg.Err == nil {
+					t.Errorf("#%d.%d: expected the decoding error, got type=%q payload=%v", i, j, msg.Type, msg.Payload)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("#%d.%d: the malformed frame was dropped", i, j)
+			}
+		}
+		sub.Close()
+		hub.Close()
+	}
+}
+
+func TestExchangeFrames(t *testing.T) {
+	frames := [...]string{
+		0: `{"type":"heartbeat","sequence":90,"last_trade_id":20,"product_id":"BTC-USD","time":"2014-11-07T08:19:28.464459Z"}`,
+		1: `{"type":"activate","product_id":"BTC-USD","timestamp":"1483736448.299000","user_id":"12","profile_id":"30000727-d308-cf50-7b1c-c06deb1934fc","order_id":"7b52009b-64fd-0a2a-49e6-d8a939753077","stop_type":"entry","side":"buy","stop_price":"80","size":"2","funds":"50","taker_fee_rate":"0.0025","private":true}`,
+	}
+	for i, typedOnly := range []bool{false, true} {
+		fc := newFakeFeedConn()
+		client := new(coinbase.Client)
+		client.SetFeedDialer(func(feedURL string) (coinbase.FeedConn, error) { return fc, nil })
+		sub, err := client.Subscribe(&coinbase.Subscription{Currencies: []string{"BTC-USD"}, TypedOnly: typedOnly})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msgs []*coinbase.Message
+		for j, frame := range frames {
+			fc.recv <- &wsu.Message{Frame: []byte(frame)}
+			select {
+			case msg := <-sub.MessagesChan:
+				if msg.Err != nil {
+					t.Errorf("#%d.%d: unexpected error: %v", i, j, msg.Err)
+				}
+				if msg.Payload == nil {
+					t.Errorf("#%d.%d: expected the typed payload", i, j)
+				}
+				msgs = append(msgs, msg)
+			case <-time.After(time.Second):
+				t.Fatalf("#%d.%d: the frame was dropped", i, j)
+			}
+		}
+		sub.Close()
+
+		if typedOnly {
+			continue
+		}
+		if g, w := msgs[0].LastTradeID, int64(20); g != w {
+			t.Errorf("#%d: last trade id: got=%d want=%d", i, g, w)
+		}
+		if g, w := msgs[1].StopPrice, 80.0; g != w {
+			t.Errorf("#%d: stop price: got=%v want=%v", i, g, w)
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	var dials []*fakeFeedConn
+	client := new(coinbase.Client)
+	client.SetFeedDialer(func(feedURL string) (coinbase.FeedConn, error) {
+		fc := newFakeFeedConn()
+		dials = append(dials, fc)
+		return fc, nil
+	})
+	hub, err := client.NewHub(&coinbase.HubConfig{TypedOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+
+	tickers, err := hub.Subscribe(&coinbase.HubSubscription{
+		Products: []string{"BTC-USD"},
+		Channels: []coinbase.Channel{coinbase.ChannelTicker},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := hub.Subscribe(&coinbase.HubSubscription{
+		Products:   []string{"BTC-USD", "ETH-USD"},
+		Channels:   []coinbase.Channel{coinbase.ChannelTicker, coinbase.ChannelFull},
+		BufferSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err := hub.Subscribe(&coinbase.HubSubscription{
+		Products:   []string{"ETH-USD"},
+		Types:      []coinbase.Type{coinbase.TypeMatch},
+		BufferSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dials) != 1 {
+		t.Fatalf("dials: got=%d want=1", len(dials))
+	}
+	fc := dials[0]
+
+	// Only what wasn't already subscribed to is sent.
+	wantSent := []string{
+		`{"type":"subscribe","product_ids":["BTC-USD"],"channels":["ticker"]}`,
+		`{"type":"subscribe","product_ids":["ETH-USD"],"channels":["ticker"]}`,
+		`{"type":"subscribe","product_ids":["BTC-USD","ETH-USD"],"channels":["full"]}`,
+	}
+	if g, w := fc.sentFrames(), wantSent; !reflect.DeepEqual(g, w) {
+		t.Errorf("subscribe frames:\ngot= %q\nwant=%q", g, w)
+	}
+
+	frames := []string{
+		`{"type":"ticker","product_id":"BTC-USD","sequence":1,"price":"4000.00"}`,
+		`{"type":"received","product_id":"BTC-USD","sequence":2,"order_id":"o1"}`,
+		`{"type":"match","product_id":"ETH-USD","sequence":3,"size":"1.0"}`,
+		`{"type":"match","product_id":"LTC-USD","sequence":4,"size":"1.0"}`,
+		`{"type":"error","message":"Failed to parse the message"}`,
+	}
+	for _, frame := range frames {
+		fc.recv <- &wsu.Message{Frame: []byte(frame)}
+	}
+
+	tests := [...]struct {
+		sub  *coinbase.SubscriptionResponse
+		want []coinbase.Type
+	}{
+		0: {sub: tickers, want: []coinbase.Type{coinbase.TypeTicker, coinbase.TypeError}},
+		1: {sub: full, want: []coinbase.Type{coinbase.TypeTicker, coinbase.TypeReceived, coinbase.TypeMatch, coinbase.TypeError}},
+		2: {sub: matches, want: []coinbase.Type{coinbase.TypeMatch, coinbase.TypeError}},
+	}
+	for i, tt := range tests {
+		var got []coinbase.Type
+		for range tt.want {
+			select {
+			case msg := <-tt.sub.MessagesChan:
+				got = append(got, msg.Type)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("#%d: timed out, got %v", i, got)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d: types: got=%v want=%v", i, got, tt.want)
+		}
+	}
+
+	// The BTC-USD tickers are still wanted by full.
+	tickers.Close()
+	if frames := fc.sentFrames(); len(frames) != 0 {
+		t.Errorf("expected no unsubscribe, got %q", frames)
+	}
+	if _, ok := <-tickers.MessagesChan; ok {
+		t.Error("expected the closed subscription to end")
+	}
+	full.Close()
+	wantSent = []string{
+		`{"type":"unsubscribe","product_ids":["BTC-USD","ETH-USD"],"channels":["ticker"]}`,
+		`{"type":"unsubscribe","product_ids":["BTC-USD"],"channels":["full"]}`,
+	}
+	if g, w := fc.sentFrames(), wantSent; !reflect.DeepEqual(g, w) {
+		t.Errorf("unsubscribe frames:\ngot= %q\nwant=%q", g, w)
+	}
+
+	// Losing the connection ends the rest, after its error.
+	fc.recv <- &wsu.Message{Err: fmt.Errorf("connection reset")}
+	fc.Close()
+	if msg := <-matches.MessagesChan; msg == nil || msg.Err == nil {
+		t.Errorf("expected the connection's error, got %#v", msg)
+	}
+	if _, ok := <-matches.MessagesChan; ok {
+		t.Error("expected the subscription to end with the connection")
+	}
+	matches.Close()
+
+	// The next subscription reconnects.
+	again, err := hub.Subscribe(&coinbase.HubSubscription{Products: []string{"BTC-USD"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if len(dials) != 2 {
+		t.Errorf("dials: got=%d want=2", len(dials))
+	}
+
+	invalid := [...]*coinbase.HubSubscription{
+		0: {Channels: []coinbase.Channel{"level3"}},
+		1: {Channels: []coinbase.Channel{coinbase.ChannelUser}},
+		2: {Products: []string{""}},
+		3: {Backpressure: "drop-everything"},
+	}
+	for i, hs := range invalid {
+		if _, err := hub.Subscribe(hs); err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+	}
+
+	hub.Close()
+	if _, err := hub.Subscribe(nil); err == nil {
+		t.Error("expected an error after closing the hub")
+	}
+}
+
+func TestHubOverlappingChannels(t *testing.T) {
+	fc := newFakeFeedConn()
+	client := new(coinbase.Client)
+	client.SetCredentials(key1)
+	client.SetFeedDialer(func(feedURL string) (coinbase.FeedConn, error) {
+		return fc, nil
+	})
+	hub, err := client.NewHub(&coinbase.HubConfig{Authenticate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+
+	var subs []*coinbase.SubscriptionResponse
+	for _, ch := range []coinbase.Channel{coinbase.ChannelFull, coinbase.ChannelMatches, coinbase.ChannelUser} {
+		sub, err := hub.Subscribe(&coinbase.HubSubscription{
+			Products:   []string{"BTC-USD"},
+			Channels:   []coinbase.Channel{ch},
+			BufferSize: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	// The exchange sends each message once per channel that it is on.
+	frames := []string{
+		// The match is on all three channels, the open on full and user.
+		`{"type":"match","product_id":"BTC-USD","sequence":1,"trade_id":7,"size":"1.0","price":"4000.00","user_id":"u1"}`,
+		`{"type":"match","product_id":"BTC-USD","sequence":1,"trade_id":7,"size":"1.0","price":"4000.00","user_id":"u1"}`,
+		`{"type":"match","product_id":"BTC-USD","sequence":1,"trade_id":7,"size":"1.0","price":"4000.00","user_id":"u1"}`,
+		`{"type":"open","product_id":"BTC-USD","sequence":2,"order_id":"o1","user_id":"u1"}`,
+		`{"type":"open","product_id":"BTC-USD","sequence":2,"order_id":"o1","user_id":"u1"}`,
+		`{"type":"last_match","product_id":"BTC-USD","sequence":1,"trade_id":6}`,
+		`{"type":"heartbeat","product_id":"BTC-USD","sequence":3}`,
+	}
+	for _, frame := range frames {
+		fc.recv <- &wsu.Message{Frame: []byte(frame)}
+	}
+	// An error that all of them get marks the end of the messages.
+	fc.recv <- &wsu.Message{Frame: []byte(`{"type":"error","message":"done"}`)}
+
+	tests := [...]struct {
+		want []coinbase.Type
+	}{
+		0: {want: []coinbase.Type{coinbase.TypeMatch, coinbase.TypeOpen, coinbase.TypeError}},
+		1: {want: []coinbase.Type{coinbase.TypeMatch, coinbase.TypeLastMatch, coinbase.TypeError}},
+		2: {want: []coinbase.Type{coinbase.TypeMatch, coinbase.TypeOpen, coinbase.TypeError}},
+	}
+	for i, tt := range tests {
+		var got []coinbase.Type
+		for len(got) == 0 || got[len(got)-1] != coinbase.TypeError {
+			select {
+			case msg := <-subs[i].MessagesChan:
+				got = append(got, msg.Type)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("#%d: timed out, got %v", i, got)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d: types: got=%v want=%v", i, got, tt.want)
+		}
+	}
+}
+
+func TestSignedSubscription(t *testing.T) {
+	fc := newFakeFeedConn()
+	client := new(coinbase.Client)
+	client.SetCredentials(&coinbase.Credentials{APIKey: "key", APISecret: "secret", Passphrase: "passphrase"})
+	client.SetFeedDialer(func(feedURL string) (coinbase.FeedConn, error) { return fc, nil })
+	hub, err := client.NewHub(&coinbase.HubConfig{Authenticate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+
+	subscribe := func() map[string]string {
+		sub, err := hub.Subscribe(&coinbase.HubSubscription{Products: []string{"BTC-USD"}, Channels: []coinbase.Channel{coinbase.ChannelUser}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := fc.sentFrames()
+		sub.Close()
+		fc.sentFrames()
+		if len(frames) != 1 {
+			t.Fatalf("frames: got=%q want 1", frames)
+		}
+		fields := make(map[string]string)
+		var recv map[string]interface{}
+		if err := json.Unmarshal([]byte(frames[0]), &recv); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range recv {
+			if s, ok := v.(string); ok {
+				fields[k] = s
+			}
+		}
+		return fields
+	}
+
+	fields := subscribe()
+	if fields["key"] != "key" || fields["passphrase"] != "passphrase" || fields["signature"] == "" || fields["timestamp"] == "" {
+		t.Errorf("expected a signed subscription with the passphrase, got %v", fields)
+	}
+
+	// A custom signer signs subscriptions too.
+	client.SetSigner(coinbase.SignerFunc(func(req *http.Request) error {
+		req.Header.Set("CB-ACCESS-KEY", "custom")
+		req.Header.Set("CB-ACCESS-SIGN", "custom-signature")
+		req.Header.Set("CB-ACCESS-PASSPHRASE", "custom-passphrase")
+		return nil
+	}))
+	fields = subscribe()
+	if fields["key"] != "custom" || fields["signature"] != "custom-signature" || fields["passphrase"] != "custom-passphrase" {
+		t.Errorf("expected the custom signer's fields, got %v", fields)
+	}
+}
@@ -0,0 +1,919 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbasetest_test
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/coinbasetest"
+)
+
+func TestWallet(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	profile, err := client.MyProfile()
+	if err != nil {
+		t.Fatalf("profile: %v", err)
+	}
+	if profile.ID == "" {
+		t.Errorf("expected a profile ID")
+	}
+
+	res, err := client.ListAccounts(&coinbase.AccountsRequest{AccountsPerPage: 3, ThrottleDurationMs: coinbase.NoThrottle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accounts []*coinbase.Account
+	for page := range res.PagesChan {
+		if page.Err != nil {
+			t.Fatalf("page #%d: %v", page.PageNumber, page.Err)
+		}
+		accounts = append(accounts, page.Accounts...)
+	}
+	if g, w := len(accounts), 4; g != w {
+		t.Fatalf("accounts: got=%d want=%d", g, w)
+	}
+
+	created, err := client.CreateAccount(&coinbase.CreateAccountRequest{Name: "Savings"})
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	renamed, err := client.UpdateAccount(&coinbase.UpdateAccountRequest{ID: created.ID, Name: "Cold Storage"})
+	if err != nil {
+		t.Fatalf("update account: %v", err)
+	}
+	if g, w := renamed.Name, "Cold Storage"; g != w {
+		t.Errorf("name: got=%q want=%q", g, w)
+	}
+	primary, err := client.SetAccountAsPrimary(created.ID)
+	if err != nil || !primary.Primary {
+		t.Fatalf("set primary: account=%+v err=%v", primary, err)
+	}
+	if err := client.DeleteAccountByID(created.ID); err == nil {
+		t.Errorf("expected an error deleting the primary account")
+	}
+	if _, err := client.SetAccountAsPrimary(accounts[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteAccountByID(created.ID); err != nil {
+		t.Errorf("delete account: %v", err)
+	}
+	if _, err := client.FindAccountByID(created.ID); err == nil {
+		t.Errorf("expected an error finding a deleted account")
+	}
+
+	addr, err := client.CreateAddress(&coinbase.CreateAddressRequest{AccountID: accounts[0].ID, Name: "deposits"})
+	if err != nil {
+		t.Fatalf("create address: %v", err)
+	}
+	ares, err := client.ListAddresses(&coinbase.AddressesRequest{AccountID: accounts[0].ID, ThrottleDurationMs: coinbase.NoThrottle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addresses []*coinbase.Address
+	for page := range ares.PagesChan {
+		addresses = append(addresses, page.Addresses...)
+	}
+	if len(addresses) != 1 || addresses[0].Address != addr.Address {
+		t.Errorf("addresses: got=%+v want [%+v]", addresses, addr)
+	}
+
+	rates, err := client.ExchangeRate("BTC-USD-ETH")
+	if err != nil {
+		t.Fatalf("exchange rate: %v", err)
+	}
+	if pair, ok := rates.RatePair(coinbase.USD); !ok || pair.Value != 4000 {
+		t.Errorf("BTC-USD: got=%+v", pair)
+	}
+
+	// Requests with unknown credentials must be rejected.
+	client.SetCredentials(&coinbase.Credentials{APIKey: "unknown", APISecret: "unknown"})
+	if _, err := client.MyProfile(); err == nil {
+		t.Errorf("expected an authentication error")
+	}
+}
+
+func TestMatching(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4000, 0.5)
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4100, 1)
+	srv.AddLiquidity("BTC-USD", coinbase.SideBuy, 3900, 1)
+
+	// Buy 1 BTC at up to 4050, which fills 0.5 at 4000
+	// and rests the remaining 0.5 on the book at 4050.
+	ores, err := client.Order(&coinbase.Order{
+		Product: "BTC-USD", Side: coinbase.SideBuy, Price: 4050, Size: 1,
+		CustomOrderID: "06524e0c-5fa9-43f9-bf2f-c2a97cbb60fe",
+	})
+	if err != nil {
+		t.Fatalf("order: %v", err)
+	}
+	if ores.ID == "" {
+		t.Fatal("expected a server assigned ID")
+	}
+
+	usd, usdHold := srv.Balance("USD")
+	btc, _ := srv.Balance("BTC")
+	if g, w := usd, 10000-0.5*4000; g != w {
+		t.Errorf("USD balance: got=%f want=%f", g, w)
+	}
+	if g, w := usdHold, 0.5*4050; g != w {
+		t.Errorf("USD hold: got=%f want=%f", g, w)
+	}
+	if g, w := btc, 1.5; g != w {
+		t.Errorf("BTC balance: got=%f want=%f", g, w)
+	}
+
+	ticker, err := client.Ticker("BTC-USD")
+	if err != nil {
+		t.Fatalf("ticker: %v", err)
+	}
+	if ticker.Price != 4000 || ticker.Bid != 4050 || ticker.Ask != 4100 {
+		t.Errorf("ticker: got=%+v", ticker)
+	}
+
+	// Insufficient funds are rejected.
+	if _, err := client.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideSell, Price: 5000, Size: 100}); err == nil {
+		t.Errorf("expected an insufficient funds error")
+	}
+
+	// Canceling releases the hold.
+	if err := client.CancelOrder(ores.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, usdHold := srv.Balance("USD"); usdHold != 0 {
+		t.Errorf("USD hold after cancel: got=%f want=0", usdHold)
+	}
+	if err := client.CancelOrder(ores.ID); err == nil {
+		t.Errorf("expected an error canceling a done order")
+	}
+
+	// A market sell fills against the best bid.
+	if _, err := client.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideSell, Size: 0.25}); err != nil {
+		t.Fatalf("market order: %v", err)
+	}
+	if usd, _ := srv.Balance("USD"); math.Abs(usd-(8000+0.25*3900)) > 1e-9 {
+		t.Errorf("USD balance after market sell: got=%f want=%f", usd, 8000+0.25*3900)
+	}
+}
+
+func TestCandles(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	start := time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+	srv.AddTrade("ETH-USD", coinbase.SideBuy, 10, 1, start.Add(10*time.Second))
+	srv.AddTrade("ETH-USD", coinbase.SideBuy, 12, 2, start.Add(20*time.Second))
+	srv.AddTrade("ETH-USD", coinbase.SideSell, 9, 3, start.Add(30*time.Second))
+	srv.AddTrade("ETH-USD", coinbase.SideSell, 11, 4, start.Add(70*time.Second))
+
+	csres, err := client.CandleSticks(&coinbase.CandleStickRequest{
+		Product:            "ETH-USD",
+		StartTime:          start,
+		EndTime:            start.Add(5 * time.Minute),
+		ThrottleDurationMs: coinbase.NoThrottle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var candles []*coinbase.CandleStick
+	for page := range csres.PagesChan {
+		if page.Err != nil {
+			t.Fatalf("page #%d: %v", page.PageNumber, page.Err)
+		}
+		candles = append(candles, page.CandleSticks...)
+	}
+	if g, w := len(candles), 2; g != w {
+		t.Fatalf("candles: got=%d want=%d", g, w)
+	}
+	var volume float64
+	for _, cs := range candles {
+		volume += cs.Volume
+	}
+	if volume != 10 {
+		t.Errorf("volume: got=%f want=10", volume)
+	}
+}
+
+// feedConn is a minimal websocket client for the server's feed.
+type feedConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialFeed(t *testing.T, feedURL string) *feedConn {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		u.Host, base64.StdEncoding.EncodeToString(nonce[:]))
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: got status %s", res.Status)
+	}
+	return &feedConn{conn: conn, br: br}
+}
+
+func (fc *feedConn) send(v interface{}) error {
+	payload, _ := json.Marshal(v)
+	frame := []byte{0x81, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^[]byte{1, 2, 3, 4}[i%4])
+	}
+	_, err := fc.conn.Write(frame)
+	return err
+}
+
+func (fc *feedConn) receive() (map[string]interface{}, error) {
+	fc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(fc.br, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(hdr[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(fc.br, ext[:]); err != nil {
+			return nil, err
+		}
+		n = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(fc.br, payload); err != nil {
+		return nil, err
+	}
+	msg := make(map[string]interface{})
+	err := json.Unmarshal(payload, &msg)
+	return msg, err
+}
+
+func TestFeed(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+
+	fc := dialFeed(t, srv.FeedURL)
+	defer fc.conn.Close()
+	if err := fc.send(map[string]interface{}{"type": "subscribe", "product_ids": []string{"BTC-USD"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Give the subscription time to be processed.
+	time.Sleep(50 * time.Millisecond)
+
+	// Not subscribed to, so mustn't be received.
+	srv.AddLiquidity("ETH-USD", coinbase.SideSell, 300, 1)
+
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4000, 1)
+	srv.AddLiquidity("BTC-USD", coinbase.SideBuy, 4000, 0.25)
+
+	var types []string
+	for i := 0; i < 6; i++ {
+		msg, err := fc.receive()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if msg["product_id"] != "BTC-USD" {
+			t.Errorf("#%d: unexpected product: %v", i, msg["product_id"])
+		}
+		types = append(types, msg["type"].(string))
+	}
+	want := "received open received match ticker done"
+	if g := strings.Join(types, " "); g != want {
+		t.Errorf("got =%q\nwant=%q", g, want)
+	}
+}
+
+func TestFeedChannels(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+
+	fc := dialFeed(t, srv.FeedURL)
+	defer fc.conn.Close()
+	subs := []map[string]interface{}{
+		{
+			"type":        "subscribe",
+			"product_ids": []string{"BTC-USD"},
+			"channels": []interface{}{
+				"matches",
+				map[string]interface{}{"name": "ticker", "product_ids": []string{"ETH-USD"}},
+			},
+		},
+		// The user channel requires authentication.
+		{"type": "subscribe", "product_ids": []string{"BTC-USD"}, "channels": []string{"user"}},
+		{"type": "subscribe", "product_ids": []string{"BTC-USD"}, "channels": []string{"level3"}},
+	}
+	for _, sub := range subs {
+		if err := fc.send(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Give the subscriptions time to be processed.
+	time.Sleep(50 * time.Millisecond)
+
+	for _, productID := range []string{"BTC-USD", "ETH-USD"} {
+		srv.AddLiquidity(productID, coinbase.SideSell, 300, 1)
+		srv.AddLiquidity(productID, coinbase.SideBuy, 300, 0.25)
+	}
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		msg, err := fc.receive()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		got = append(got, fmt.Sprintf("%v %v", msg["type"], msg["product_id"]))
+	}
+	want := []string{"error <nil>", "error <nil>", "match BTC-USD", "ticker ETH-USD"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got =%q\nwant=%q", got, want)
+	}
+}
+
+func TestFeedAuthentication(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+
+	tests := [...]struct {
+		passphrase string
+		wantErr    bool
+	}{
+		0: {passphrase: coinbasetest.DefaultCredentials.Passphrase},
+		1: {passphrase: "", wantErr: true},
+		2: {passphrase: "wrong", wantErr: true},
+	}
+
+	for i, tt := range tests {
+		client := srv.NewClient()
+		creds := *coinbasetest.DefaultCredentials
+		creds.Passphrase = tt.passphrase
+		client.SetCredentials(&creds)
+		hub, err := client.NewHub(&coinbase.HubConfig{Authenticate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := hub.Subscribe(&coinbase.HubSubscription{
+			Products: []string{"BTC-USD"},
+			Channels: []coinbase.Channel{coinbase.ChannelUser},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Orders are placed until one is received, as
+		// the subscription is processed asynchronously.
+		var msg *coinbase.Message
+		deadline := time.Now().Add(2 * time.Second)
+		for msg == nil && time.Now().Before(deadline) {
+			if _, err := srv.NewClient().Order(&coinbase.Order{
+				Product: "BTC-USD", Side: coinbase.SideBuy, Price: 3000, Size: 0.1,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case msg = <-sub.MessagesChan:
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		if msg == nil {
+			t.Errorf("#%d: timed out", i)
+		} else if gotErr := msg.Type == coinbase.TypeError; gotErr != tt.wantErr {
+			t.Errorf("#%d: got type=%q message=%q, wantErr=%v", i, msg.Type, msg.Message, tt.wantErr)
+		}
+		hub.Close()
+	}
+}
+
+func TestListTrades(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	start := time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		srv.AddTrade("BTC-USD", coinbase.SideBuy, float64(4000+i), 1, start.Add(time.Duration(i)*time.Second))
+	}
+
+	tests := [...]struct {
+		req       *coinbase.TradesRequest
+		wantErr   bool
+		wantPages [][]int64
+	}{
+		0: {req: &coinbase.TradesRequest{}, wantErr: true},
+		1: {req: &coinbase.TradesRequest{Product: "BTC-USD", TradesPerPage: 1000}, wantErr: true},
+		2: {
+			req: &coinbase.TradesRequest{Product: "BTC-USD", TradesPerPage: 10},
+			wantPages: [][]int64{
+				{25, 24, 23, 22, 21, 20, 19, 18, 17, 16},
+				{15, 14, 13, 12, 11, 10, 9, 8, 7, 6},
+				{5, 4, 3, 2, 1},
+			},
+		},
+		3: {
+			req:       &coinbase.TradesRequest{Product: "BTC-USD", TradesPerPage: 10, MaxPage: 1},
+			wantPages: [][]int64{{25, 24, 23, 22, 21, 20, 19, 18, 17, 16}},
+		},
+		4: {
+			req:       &coinbase.TradesRequest{Product: "BTC-USD", TradesPerPage: 3, SinceTradeID: 20},
+			wantPages: [][]int64{{21, 22, 23}, {24, 25}},
+		},
+		5: {
+			req:       &coinbase.TradesRequest{Product: "BTC-USD", SinceTradeID: 25},
+			wantPages: [][]int64{{}},
+		},
+	}
+
+	for i, tt := range tests {
+		tt.req.ThrottleDurationMs = coinbase.NoThrottle
+		res, err := client.ListTrades(tt.req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		var pages [][]int64
+		for page := range res.PagesChan {
+			if page.Err != nil {
+				t.Errorf("#%d: page #%d: %v", i, page.PageNumber, page.Err)
+				break
+			}
+			ids := []int64{}
+			for _, tr := range page.Trades {
+				if g, w := tr.Price, float64(4000+tr.TradeID-1); g != w {
+					t.Errorf("#%d: trade %d: price got=%f want=%f", i, tr.TradeID, g, w)
+				}
+				ids = append(ids, tr.TradeID)
+			}
+			pages = append(pages, ids)
+		}
+		if !reflect.DeepEqual(pages, tt.wantPages) {
+			t.Errorf("#%d:\ngot= %v\nwant=%v", i, pages, tt.wantPages)
+		}
+	}
+}
+
+func TestStatsAndTickers(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	now := time.Date(2017, 9, 21, 18, 0, 0, 0, time.UTC)
+	srv.SetClock(func() time.Time { return now })
+	srv.AddTrade("ETH-USD", coinbase.SideBuy, 80, 5, now.Add(-48*time.Hour))
+	srv.AddTrade("ETH-USD", coinbase.SideBuy, 100, 1, now.Add(-2*time.Hour))
+	srv.AddTrade("ETH-USD", coinbase.SideSell, 120, 2, now.Add(-1*time.Hour))
+	srv.AddTrade("ETH-USD", coinbase.SideBuy, 90, 1, now.Add(-30*time.Minute))
+	srv.AddTrade("BTC-USD", coinbase.SideBuy, 4000, 1, now.Add(-time.Minute))
+
+	stats, err := client.Stats("ETH-USD")
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	want := &coinbase.Stats{Open: 100, High: 120, Low: 90, Volume: 4, Last: 90, Volume30Day: 9}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats: got=%+v want=%+v", stats, want)
+	}
+
+	if _, err := client.Tickers(); err == nil {
+		t.Errorf("expected an error without products")
+	}
+	if _, err := client.Tickers("BTC-USD", " "); err == nil {
+		t.Errorf("expected an error for a blank product")
+	}
+
+	tickers, err := client.Tickers("BTC-USD", "ETH-USD", "BTC-USD", "FOO")
+	if err == nil || !strings.Contains(err.Error(), "FOO") {
+		t.Errorf("expected an error for product FOO, got %v", err)
+	}
+	if g, w := len(tickers), 2; g != w {
+		t.Fatalf("tickers: got=%d want=%d", g, w)
+	}
+	if g, w := tickers["BTC-USD"].Price, 4000.0; g != w {
+		t.Errorf("BTC-USD price: got=%f want=%f", g, w)
+	}
+	if g, w := tickers["ETH-USD"].Price, 90.0; g != w {
+		t.Errorf("ETH-USD price: got=%f want=%f", g, w)
+	}
+}
+
+func TestProducts(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+
+	products, err := srv.NewClient().Products()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(products), 5; g != w {
+		t.Fatalf("products: got=%d want=%d", g, w)
+	}
+	want := &coinbase.Product{
+		ID: "BTC-USD", BaseCurrency: "BTC", QuoteCurrency: "USD",
+		BaseMinSize: 0.01, BaseMaxSize: 10000, QuoteIncrement: 0.01,
+		DisplayName: "BTC/USD", Status: "online",
+	}
+	if !reflect.DeepEqual(products[0], want) {
+		t.Errorf("got=%+v\nwant=%+v", products[0], want)
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ores, err := client.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: float64(100 + i), Size: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ores.ID)
+	}
+	if err := client.CancelOrder(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(oreq *coinbase.OrdersRequest) (pages [][]string) {
+		oreq.ThrottleDurationMs = coinbase.NoThrottle
+		res, err := client.ListOrders(oreq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for page := range res.PagesChan {
+			if page.Err != nil {
+				t.Fatalf("page #%d: %v", page.PageNumber, page.Err)
+			}
+			var pageIDs []string
+			for _, o := range page.Orders {
+				pageIDs = append(pageIDs, o.ID)
+			}
+			pages = append(pages, pageIDs)
+		}
+		return pages
+	}
+
+	// Open orders, newest first.
+	got := list(&coinbase.OrdersRequest{OrdersPerPage: 2})
+	want := [][]string{{ids[4], ids[3]}, {ids[2], ids[1]}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("open orders:\ngot= %v\nwant=%v", got, want)
+	}
+	got = list(&coinbase.OrdersRequest{OrdersPerPage: 2, Statuses: []string{"all"}})
+	want = [][]string{{ids[4], ids[3]}, {ids[2], ids[1]}, {ids[0]}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("all orders:\ngot= %v\nwant=%v", got, want)
+	}
+	got = list(&coinbase.OrdersRequest{Statuses: []string{"done"}})
+	if len(got) != 1 || !reflect.DeepEqual(got[0], []string{ids[0]}) {
+		t.Errorf("done orders: got=%v want=[[%s]]", got, ids[0])
+	}
+	if got := list(&coinbase.OrdersRequest{Product: "ETH-USD"}); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("ETH-USD orders: got=%v want one empty page", got)
+	}
+	if _, err := client.ListOrders(&coinbase.OrdersRequest{OrdersPerPage: 101}); err == nil {
+		t.Errorf("expected an error for too many orders per page")
+	}
+}
+
+func TestOrderBook(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	srv.AddLiquidity("BTC-USD", coinbase.SideBuy, 99, 1)
+	srv.AddLiquidity("BTC-USD", coinbase.SideBuy, 98, 1)
+	srv.AddLiquidity("BTC-USD", coinbase.SideBuy, 99, 2)
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 101, 0.5)
+
+	tests := [...]struct {
+		level   int
+		want    *coinbase.OrderBook
+		wantErr bool
+	}{
+		0: {
+			level: coinbase.BookLevelBest,
+			want: &coinbase.OrderBook{
+				Bids: []*coinbase.BookEntry{{Price: 99, Size: 3, NumOrders: 2}},
+				Asks: []*coinbase.BookEntry{{Price: 101, Size: 0.5, NumOrders: 1}},
+			},
+		},
+		1: {
+			level: coinbase.BookLevelTop50,
+			want: &coinbase.OrderBook{
+				Bids: []*coinbase.BookEntry{{Price: 99, Size: 3, NumOrders: 2}, {Price: 98, Size: 1, NumOrders: 1}},
+				Asks: []*coinbase.BookEntry{{Price: 101, Size: 0.5, NumOrders: 1}},
+			},
+		},
+		2: {level: 3, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		book, err := client.OrderBook("BTC-USD", tt.level)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected a non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		book.Sequence = 0
+		if !reflect.DeepEqual(book, tt.want) {
+			gotBlob, _ := json.Marshal(book)
+			wantBlob, _ := json.Marshal(tt.want)
+			t.Errorf("#%d:\ngot= %s\nwant=%s", i, gotBlob, wantBlob)
+		}
+	}
+}
+
+func TestOrderTracker(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	transitions := make(chan *coinbase.OrderTransition, 100)
+	tracker, err := client.NewOrderTracker(&coinbase.OrderTrackerConfig{
+		Products:     []string{"BTC-USD"},
+		PollInterval: 20 * time.Millisecond,
+		OnTransition: func(ot *coinbase.OrderTransition) { transitions <- ot },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+	awaitFeed(t, tracker, transitions)
+
+	next := func(clientOID string) *coinbase.OrderTransition {
+		for {
+			select {
+			case ot := <-transitions:
+				if ot.Order.ClientOID == clientOID {
+					return ot
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for a transition of %q", clientOID)
+			}
+		}
+	}
+
+	// Buy 1 BTC at up to 4000, which fills 0.5 at 3990 right
+	// away and the rest at 4000 once a seller comes along.
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 3990, 0.5)
+	placed, err := tracker.Place(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: 4000, Size: 1})
+	if err != nil {
+		t.Fatalf("place: %v", err)
+	}
+	if placed.ID == "" || placed.ClientOID == "" {
+		t.Fatalf("expected both the server and client ids, got %+v", placed)
+	}
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4000, 0.5)
+
+	wants := [...]struct {
+		from, to  coinbase.OrderState
+		fillSize  float64
+		fillPrice float64
+	}{
+		0: {from: coinbase.OrderStatePending, to: coinbase.OrderStateReceived},
+		1: {from: coinbase.OrderStateReceived, to: coinbase.OrderStatePartiallyFilled, fillSize: 0.5, fillPrice: 3990},
+		2: {from: coinbase.OrderStatePartiallyFilled, to: coinbase.OrderStatePartiallyFilled, fillSize: 0.5, fillPrice: 4000},
+		3: {from: coinbase.OrderStatePartiallyFilled, to: coinbase.OrderStateDone},
+	}
+	for i, want := range wants {
+		ot := next(placed.ClientOID)
+		if ot.From != want.from || ot.Order.State != want.to || ot.FillSize != want.fillSize || ot.FillPrice != want.fillPrice {
+			t.Errorf("#%d: got %s->%s fill %v@%v, want %s->%s fill %v@%v", i,
+				ot.From, ot.Order.State, ot.FillSize, ot.FillPrice, want.from, want.to, want.fillSize, want.fillPrice)
+		}
+		if i > 0 && ot.Polled {
+			t.Errorf("#%d: expected the transition to come from the feed", i)
+		}
+	}
+	done, ok := tracker.Order(placed.ID)
+	if !ok {
+		t.Fatal("expected to find the order by its id")
+	}
+	if done.FilledSize != 1 || done.AveragePrice != 3995 || done.DoneReason != coinbase.ReasonFilled {
+		t.Errorf("done order: got %+v", done)
+	}
+	if byClientOID, _ := tracker.Order(placed.ClientOID); byClientOID == nil || byClientOID.ID != placed.ID {
+		t.Errorf("expected to find the order by its client id, got %+v", byClientOID)
+	}
+	if !tracker.Forget(placed.ClientOID) {
+		t.Error("expected to forget the done order")
+	}
+	if forgotten, ok := tracker.Order(placed.ID); ok {
+		t.Errorf("expected the order to be forgotten, got %+v", forgotten)
+	}
+	if tracker.Forget(placed.ID) {
+		t.Error("expected the order to already be forgotten")
+	}
+
+	// Without the feed, the order is polled.
+	srv.SetFeedDown(true)
+	polled, err := tracker.Place(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideSell, Price: 4500, Size: 0.25})
+	if err != nil {
+		t.Fatalf("place while the feed is down: %v", err)
+	}
+	srv.AddLiquidity("BTC-USD", coinbase.SideBuy, 4500, 0.25)
+	for {
+		ot := next(polled.ClientOID)
+		if ot.Order.State != coinbase.OrderStateDone {
+			continue
+		}
+		if !ot.Polled || ot.FillSize != 0.25 || ot.FillPrice != 4500 || ot.Order.DoneReason != coinbase.ReasonFilled {
+			t.Errorf("polled transition: got %+v of %+v", ot, ot.Order)
+		}
+		break
+	}
+
+	// Once the feed is back, it is followed again.
+	srv.SetFeedDown(false)
+	awaitFeed(t, tracker, transitions)
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4200, 0.1)
+	followed, err := tracker.Place(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: 4200, Size: 0.1})
+	if err != nil {
+		t.Fatalf("place once the feed is back: %v", err)
+	}
+	for {
+		ot := next(followed.ClientOID)
+		if ot.Order.State != coinbase.OrderStateDone {
+			continue
+		}
+		if ot.Polled {
+			t.Error("expected the feed to be followed again")
+		}
+		break
+	}
+}
+
+// awaitFeed waits for the tracker to follow the feed, discarding the
+// transitions of other orders, by placing orders that rest on the
+// book until the feed reports one of them open.
+func awaitFeed(t *testing.T, tracker *coinbase.OrderTracker, transitions <-chan *coinbase.OrderTransition) {
+	deadline := time.After(5 * time.Second)
+	for {
+		probe, err := tracker.Place(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: 1, Size: 0.01})
+		if err != nil {
+			t.Fatalf("place probe: %v", err)
+		}
+		retry := time.After(100 * time.Millisecond)
+	wait:
+		for {
+			select {
+			case ot := <-transitions:
+				if ot.Order.ClientOID == probe.ClientOID && ot.Order.State == coinbase.OrderStateOpen && !ot.Polled {
+					return
+				}
+			case <-retry:
+				break wait
+			case <-deadline:
+				t.Fatal("timed out waiting for the feed to be followed")
+			}
+		}
+	}
+}
+
+func TestOrderTrackerFillWhileReconnecting(t *testing.T) {
+	srv := coinbasetest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	transitions := make(chan *coinbase.OrderTransition, 100)
+	tracker, err := client.NewOrderTracker(&coinbase.OrderTrackerConfig{
+		Products:     []string{"BTC-USD"},
+		PollInterval: 20 * time.Millisecond,
+		OnTransition: func(ot *coinbase.OrderTransition) { transitions <- ot },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+	awaitFeed(t, tracker, transitions)
+
+	next := func(clientOID string) *coinbase.OrderTransition {
+		for {
+			select {
+			case ot := <-transitions:
+				if ot.Order.ClientOID == clientOID {
+					return ot
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for a transition of %q", clientOID)
+			}
+		}
+	}
+
+	placed, err := tracker.Place(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideBuy, Price: 4000, Size: 1})
+	if err != nil {
+		t.Fatalf("place: %v", err)
+	}
+	var fillSizes []float64
+	until := func(state coinbase.OrderState, polled bool) {
+		for {
+			ot := next(placed.ClientOID)
+			if ot.FillSize > 0 {
+				fillSizes = append(fillSizes, ot.FillSize)
+			}
+			if ot.Order.State == state && ot.Polled == polled {
+				return
+			}
+		}
+	}
+	until(coinbase.OrderStateOpen, false)
+
+	// The polls of the order are held back, for it
+	// to be filled right before it is looked up.
+	polls, resume, done := make(chan bool), make(chan bool), make(chan bool)
+	defer close(done)
+	client.AddCallHooks(func(ci *coinbase.CallInfo) func(*coinbase.CallResult) {
+		if ci.Name == "FindOrderByID" && strings.HasSuffix(ci.Endpoint, "/"+placed.ID) {
+			select {
+			case polls <- true:
+				<-resume
+			case <-done:
+			}
+		}
+		return nil
+	})
+
+	// While the feed is down, the fill is only polled.
+	srv.SetFeedDown(true)
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 3990, 0.25)
+	<-polls
+	resume <- true
+	until(coinbase.OrderStatePartiallyFilled, true)
+
+	// As the feed comes back, the order is filled and polled before
+	// the feed reports the fill, which must only be counted once.
+	srv.SetFeedDown(false)
+	<-polls
+	awaitFeed(t, tracker, transitions)
+	srv.SetFeedHeld(true)
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4000, 0.25)
+	resume <- true
+	until(coinbase.OrderStatePartiallyFilled, true)
+	srv.SetFeedHeld(false)
+
+	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4000, 0.5)
+	until(coinbase.OrderStateDone, false)
+
+	if g, w := fillSizes, []float64{0.25, 0.25, 0.5}; !reflect.DeepEqual(g, w) {
+		t.Errorf("fills: got=%v want=%v", g, w)
+	}
+	got, ok := tracker.Order(placed.ID)
+	if !ok {
+		t.Fatal("expected to find the order by its id")
+	}
+	if got.FilledSize != 1 || got.ExecutedValue != 4000 || got.AveragePrice != 4000 {
+		t.Errorf("done order: got %+v", got)
+	}
+}
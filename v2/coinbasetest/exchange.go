@@ -0,0 +1,974 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbasetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+type order struct {
+	ID            string
+	ClientOID     string
+	ProductID     string
+	Side          coinbase.Side
+	Type          string
+	Price         float64
+	Size          float64
+	Funds         float64
+	TimeInForce   coinbase.TimeInForce
+	PostOnly      bool
+	CreatedAt     time.Time
+	DoneAt        time.Time
+	FilledSize    float64
+	ExecutedValue float64
+	Status        string
+	DoneReason    coinbase.Reason
+
+	// own is set for the user's orders, whose fills are
+	// settled in the ledger, as opposed to the orders placed
+	// with AddLiquidity which only provide liquidity.
+	own bool
+
+	// hold is the amount still held in the ledger for the order.
+	hold float64
+}
+
+func (o *order) remaining() float64 {
+	return o.Size - o.FilledSize
+}
+
+type orderJSON struct {
+	ID            string               `json:"id"`
+	Price         string               `json:"price,omitempty"`
+	Size          string               `json:"size,omitempty"`
+	Funds         string               `json:"funds,omitempty"`
+	ProductID     string               `json:"product_id"`
+	Side          coinbase.Side        `json:"side"`
+	STP           string               `json:"stp,omitempty"`
+	Type          string               `json:"type"`
+	TimeInForce   coinbase.TimeInForce `json:"time_in_force,omitempty"`
+	PostOnly      bool                 `json:"post_only"`
+	CreatedAt     time.Time            `json:"created_at"`
+	DoneAt        *time.Time           `json:"done_at,omitempty"`
+	DoneReason    coinbase.Reason      `json:"done_reason,omitempty"`
+	FillFees      string               `json:"fill_fees"`
+	FilledSize    string               `json:"filled_size"`
+	ExecutedValue string               `json:"executed_value"`
+	Status        string               `json:"status"`
+	Settled       bool                 `json:"settled"`
+}
+
+func (o *order) toJSON() *orderJSON {
+	oj := &orderJSON{
+		ID:            o.ID,
+		ProductID:     o.ProductID,
+		Side:          o.Side,
+		STP:           "dc",
+		Type:          o.Type,
+		TimeInForce:   o.TimeInForce,
+		PostOnly:      o.PostOnly,
+		CreatedAt:     o.CreatedAt,
+		DoneReason:    o.DoneReason,
+		FillFees:      "0",
+		FilledSize:    formatFloat(o.FilledSize),
+		ExecutedValue: formatFloat(o.ExecutedValue),
+		Status:        o.Status,
+		Settled:       o.Status == "done",
+	}
+	if o.Price > 0 {
+		oj.Price = formatFloat(o.Price)
+	}
+	if o.Size > 0 {
+		oj.Size = formatFloat(o.Size)
+	}
+	if o.Funds > 0 {
+		oj.Funds = formatFloat(o.Funds)
+	}
+	if !o.DoneAt.IsZero() {
+		doneAt := o.DoneAt
+		oj.DoneAt = &doneAt
+	}
+	return oj
+}
+
+type trade struct {
+	ID           int64
+	Time         time.Time
+	Price        float64
+	Size         float64
+	Side         coinbase.Side
+	MakerOrderID string
+	TakerOrderID string
+}
+
+// book holds the resting orders of a product with bids
+// sorted by descending and asks by ascending price, each
+// in the order of their arrival at the same price.
+type book struct {
+	bids []*order
+	asks []*order
+}
+
+func (b *book) insert(o *order) {
+	if o.Side == coinbase.SideBuy {
+		i := sort.Search(len(b.bids), func(i int) bool { return b.bids[i].Price < o.Price })
+		b.bids = append(b.bids, nil)
+		copy(b.bids[i+1:], b.bids[i:])
+		b.bids[i] = o
+		return
+	}
+	i := sort.Search(len(b.asks), func(i int) bool { return b.asks[i].Price > o.Price })
+	b.asks = append(b.asks, nil)
+	copy(b.asks[i+1:], b.asks[i:])
+	b.asks[i] = o
+}
+
+func (b *book) remove(o *order) bool {
+	side := &b.asks
+	if o.Side == coinbase.SideBuy {
+		side = &b.bids
+	}
+	for i, other := range *side {
+		if other == o {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// opposite returns the resting orders that o would match against.
+func (b *book) opposite(o *order) []*order {
+	if o.Side == coinbase.SideBuy {
+		return b.asks
+	}
+	return b.bids
+}
+
+func crosses(taker, maker *order) bool {
+	if taker.Type == "market" {
+		return true
+	}
+	if taker.Side == coinbase.SideBuy {
+		return maker.Price <= taker.Price
+	}
+	return maker.Price >= taker.Price
+}
+
+func splitProduct(productID string) (base, quote string, ok bool) {
+	splits := strings.Split(productID, "-")
+	if len(splits) != 2 || splits[0] == "" || splits[1] == "" {
+		return "", "", false
+	}
+	return splits[0], splits[1], true
+}
+
+// book must be invoked with s.mu held.
+func (s *Server) book(productID string) *book {
+	b, ok := s.books[productID]
+	if !ok {
+		b = new(book)
+		s.books[productID] = b
+	}
+	return b
+}
+
+// AddLiquidity rests a limit order, that isn't settled in the ledger,
+// on the book e.g. to simulate other market participants. If it crosses
+// resting orders, it is matched against them first. It returns the order's ID.
+func (s *Server) AddLiquidity(productID string, side coinbase.Side, price, size float64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &order{
+		ID:          s.nextID(),
+		ProductID:   productID,
+		Side:        side,
+		Type:        "limit",
+		Price:       price,
+		Size:        size,
+		TimeInForce: coinbase.GTC,
+		CreatedAt:   s.now(),
+		Status:      "pending",
+	}
+	s.orders[o.ID] = o
+	s.orderList = append(s.orderList, o)
+	s.process(o)
+	return o.ID
+}
+
+// AddTrade records a historical trade, that doesn't affect the books
+// or the ledger, e.g. to seed the candles and ticker of a product.
+func (s *Server) AddTrade(productID string, side coinbase.Side, price, size float64, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordTrade(productID, &trade{Time: t.UTC(), Price: price, Size: size, Side: side})
+}
+
+// recordTrade must be invoked with s.mu held.
+func (s *Server) recordTrade(productID string, tr *trade) {
+	trades := s.trades[productID]
+	tr.ID = int64(len(trades) + 1)
+	// Keep the trades sorted by time, for historical trades.
+	i := sort.Search(len(trades), func(i int) bool { return trades[i].Time.After(tr.Time) })
+	trades = append(trades, nil)
+	copy(trades[i+1:], trades[i:])
+	trades[i] = tr
+	s.trades[productID] = trades
+}
+
+var errInsufficientFunds = "Insufficient funds"
+
+// place validates o, holds its funds in the ledger and
+// then processes it. It must be invoked with s.mu held.
+func (s *Server) place(o *order) (code int, msg string) {
+	base, quote, ok := splitProduct(o.ProductID)
+	if !ok {
+		return http.StatusBadRequest, "Invalid product_id"
+	}
+	if o.Side != coinbase.SideBuy && o.Side != coinbase.SideSell {
+		return http.StatusBadRequest, "Invalid side"
+	}
+	if o.Type == "limit" && (o.Price <= 0 || o.Size <= 0) {
+		return http.StatusBadRequest, "Limit orders require price and size"
+	}
+	if o.Type == "market" && o.Size <= 0 && o.Funds <= 0 {
+		return http.StatusBadRequest, "Market orders require size or funds"
+	}
+	if o.PostOnly && (o.TimeInForce == coinbase.IOC || o.TimeInForce == coinbase.FOK) {
+		return http.StatusBadRequest, "Post only is invalid with IOC or FOK"
+	}
+
+	b := s.book(o.ProductID)
+	if o.PostOnly {
+		if opp := b.opposite(o); len(opp) > 0 && crosses(o, opp[0]) {
+			return http.StatusBadRequest, "Post only mode"
+		}
+	}
+	if o.TimeInForce == coinbase.FOK && s.fillable(o) < o.Size {
+		return http.StatusBadRequest, "Order couldn't be filled entirely"
+	}
+
+	// Hold the funds for the order.
+	switch {
+	case o.Side == coinbase.SideSell && o.Size > 0:
+		o.hold = o.Size
+		if acct := s.ledgerAccount(base); acct.balance-acct.hold < o.hold {
+			return http.StatusBadRequest, errInsufficientFunds
+		}
+		s.ledgerAccount(base).hold += o.hold
+	case o.Side == coinbase.SideBuy && o.Type == "limit":
+		o.hold = o.Price * o.Size
+		if acct := s.ledgerAccount(quote); acct.balance-acct.hold < o.hold {
+			return http.StatusBadRequest, errInsufficientFunds
+		}
+		s.ledgerAccount(quote).hold += o.hold
+	case o.Funds > 0:
+		o.hold = o.Funds
+		if acct := s.ledgerAccount(quote); acct.balance-acct.hold < o.hold {
+			return http.StatusBadRequest, errInsufficientFunds
+		}
+		s.ledgerAccount(quote).hold += o.hold
+	default:
+		// Market buys by size are only limited by the available funds.
+		if acct := s.ledgerAccount(quote); acct.balance-acct.hold <= 0 {
+			return http.StatusBadRequest, errInsufficientFunds
+		}
+	}
+
+	o.own = true
+	s.orders[o.ID] = o
+	s.orderList = append(s.orderList, o)
+	s.process(o)
+	return http.StatusOK, ""
+}
+
+// fillable returns the size of o that can be filled
+// immediately. It must be invoked with s.mu held.
+func (s *Server) fillable(o *order) float64 {
+	var size float64
+	for _, maker := range s.book(o.ProductID).opposite(o) {
+		if !crosses(o, maker) {
+			break
+		}
+		size += maker.remaining()
+	}
+	return size
+}
+
+// process matches o against the book, rests its remainder if
+// necessary and publishes its lifecycle to the feed.
+// It must be invoked with s.mu held.
+func (s *Server) process(o *order) {
+	b := s.book(o.ProductID)
+	s.publishOrder(o, "received", nil)
+
+	funds := o.Funds
+	for {
+		opp := b.opposite(o)
+		if len(opp) == 0 || !crosses(o, opp[0]) {
+			break
+		}
+		maker := opp[0]
+		size := maker.remaining()
+		if o.Size > 0 {
+			size = math.Min(size, o.remaining())
+		}
+		if o.Type == "market" && o.Funds > 0 {
+			size = math.Min(size, funds/maker.Price)
+		}
+		if o.own && o.Type == "market" && o.Side == coinbase.SideBuy && o.Funds <= 0 {
+			_, quote, _ := splitProduct(o.ProductID)
+			acct := s.ledgerAccount(quote)
+			size = math.Min(size, (acct.balance-acct.hold)/maker.Price)
+		}
+		size = roundSize(size)
+		if size <= 0 {
+			break
+		}
+
+		s.fill(o, maker, size)
+		funds -= size * maker.Price
+		if maker.remaining() <= 0 {
+			b.remove(maker)
+			s.finish(maker, coinbase.ReasonFilled)
+		}
+		if o.Size > 0 && o.remaining() <= 0 {
+			break
+		}
+	}
+
+	switch {
+	case o.Size > 0 && o.remaining() <= 0:
+		s.finish(o, coinbase.ReasonFilled)
+	case o.Type == "market", o.TimeInForce == coinbase.IOC, o.TimeInForce == coinbase.FOK:
+		reason := coinbase.ReasonCanceled
+		if o.Type == "market" && o.FilledSize > 0 {
+			reason = coinbase.ReasonFilled
+		}
+		s.finish(o, reason)
+	default:
+		o.Status = "open"
+		b.insert(o)
+		s.publishOrder(o, "open", nil)
+	}
+}
+
+// roundSize rounds to satoshis to avoid floating point dust.
+func roundSize(size float64) float64 {
+	return math.Floor(size*1e8+0.5) / 1e8
+}
+
+// fill settles a match of size between the taker and the
+// maker at the maker's price. It must be invoked with s.mu held.
+func (s *Server) fill(taker, maker *order, size float64) {
+	price := maker.Price
+	taker.FilledSize = roundSize(taker.FilledSize + size)
+	maker.FilledSize = roundSize(maker.FilledSize + size)
+	taker.ExecutedValue += price * size
+	maker.ExecutedValue += price * size
+
+	for _, o := range []*order{taker, maker} {
+		if o.own {
+			s.settle(o, price, size)
+		}
+	}
+
+	tr := &trade{
+		Time:         s.now(),
+		Price:        price,
+		Size:         size,
+		Side:         maker.Side,
+		MakerOrderID: maker.ID,
+		TakerOrderID: taker.ID,
+	}
+	s.recordTrade(taker.ProductID, tr)
+	s.publishMatch(taker, maker, tr)
+}
+
+// settle moves the funds of a fill of o in the ledger.
+// It must be invoked with s.mu held.
+func (s *Server) settle(o *order, price, size float64) {
+	base, quote, _ := splitProduct(o.ProductID)
+	baseAcct, quoteAcct := s.ledgerAccount(base), s.ledgerAccount(quote)
+	value := price * size
+	if o.Side == coinbase.SideBuy {
+		release := value
+		if o.Type == "limit" {
+			release = o.Price * size
+		}
+		release = math.Min(release, o.hold)
+		o.hold -= release
+		quoteAcct.hold -= release
+		quoteAcct.balance -= value
+		baseAcct.balance += size
+	} else {
+		release := math.Min(size, o.hold)
+		o.hold -= release
+		baseAcct.hold -= release
+		baseAcct.balance -= size
+		quoteAcct.balance += value
+	}
+	now := s.now()
+	baseAcct.UpdatedAt, quoteAcct.UpdatedAt = now, now
+}
+
+// finish marks o as done and releases any funds that
+// are still on hold. It must be invoked with s.mu held.
+func (s *Server) finish(o *order, reason coinbase.Reason) {
+	o.Status = "done"
+	o.DoneReason = reason
+	o.DoneAt = s.now()
+	if o.own && o.hold > 0 {
+		base, quote, _ := splitProduct(o.ProductID)
+		currency := quote
+		if o.Side == coinbase.SideSell {
+			currency = base
+		}
+		s.ledgerAccount(currency).hold -= o.hold
+		o.hold = 0
+	}
+	s.publishOrder(o, "done", nil)
+}
+
+func (s *Server) handleOrders(rw http.ResponseWriter, req *http.Request) {
+	body, authErr := s.authenticate(req, true)
+	if authErr != "" {
+		exchangeError(rw, http.StatusUnauthorized, authErr)
+		return
+	}
+
+	splits := splitPath(req.URL.Path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case len(splits) == 1 && req.Method == "POST":
+		recv := new(coinbase.Order)
+		if err := json.Unmarshal(body, recv); err != nil {
+			exchangeError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+		o := &order{
+			ID:          s.nextID(),
+			ClientOID:   recv.CustomOrderID,
+			ProductID:   recv.Product,
+			Side:        recv.Side,
+			Type:        "limit",
+			Price:       recv.Price,
+			Size:        recv.Size,
+			Funds:       recv.Funds,
+			TimeInForce: recv.TimeInForce,
+			PostOnly:    recv.PostOnly,
+			CreatedAt:   s.now(),
+			Status:      "pending",
+		}
+		if o.Price <= 0 {
+			o.Type = "market"
+		}
+		if o.TimeInForce == "" {
+			o.TimeInForce = coinbase.GTC
+		}
+		if code, msg := s.place(o); msg != "" {
+			exchangeError(rw, code, msg)
+			return
+		}
+		oj := o.toJSON()
+		// The response reflects the order as it was received.
+		oj.Status, oj.FilledSize, oj.ExecutedValue, oj.DoneAt, oj.DoneReason = "pending", "0", "0", nil, ""
+		oj.Settled = false
+		writeJSON(rw, http.StatusOK, oj)
+
+	case len(splits) == 1 && req.Method == "GET":
+		statuses := req.URL.Query()["status"]
+		if len(statuses) == 0 {
+			statuses = []string{"open", "pending"}
+		}
+		productID := req.URL.Query().Get("product_id")
+		limit, after, ok := s.orderPaging(rw, req)
+		if !ok {
+			return
+		}
+		// Orders are listed newest first and the cursors
+		// are their positions in the order of arrival.
+		list := []*orderJSON{}
+		var first, last int
+		for i := len(s.orderList) - 1; i >= 0 && len(list) < limit; i-- {
+			o := s.orderList[i]
+			if !o.own || (productID != "" && o.ProductID != productID) || (after > 0 && i+1 >= after) {
+				continue
+			}
+			for _, status := range statuses {
+				if status == "all" || status == o.Status {
+					if len(list) == 0 {
+						first = i + 1
+					}
+					last = i + 1
+					list = append(list, o.toJSON())
+					break
+				}
+			}
+		}
+		if len(list) > 0 {
+			rw.Header().Set("CB-BEFORE", strconv.Itoa(first))
+			rw.Header().Set("CB-AFTER", strconv.Itoa(last))
+		}
+		writeJSON(rw, http.StatusOK, list)
+
+	case len(splits) == 1 && req.Method == "DELETE":
+		var canceled []string
+		for _, o := range s.orderList {
+			if o.own && o.Status == "open" {
+				s.book(o.ProductID).remove(o)
+				s.finish(o, coinbase.ReasonCanceled)
+				canceled = append(canceled, o.ID)
+			}
+		}
+		if canceled == nil {
+			canceled = []string{}
+		}
+		writeJSON(rw, http.StatusOK, canceled)
+
+	case len(splits) == 2:
+		o, ok := s.orders[splits[1]]
+		if !ok || !o.own {
+			exchangeError(rw, http.StatusNotFound, "NotFound")
+			return
+		}
+		switch req.Method {
+		case "GET":
+			writeJSON(rw, http.StatusOK, o.toJSON())
+		case "DELETE":
+			if o.Status != "open" {
+				exchangeError(rw, http.StatusBadRequest, "Order already done")
+				return
+			}
+			s.book(o.ProductID).remove(o)
+			s.finish(o, coinbase.ReasonCanceled)
+			writeJSON(rw, http.StatusOK, []string{o.ID})
+		default:
+			exchangeError(rw, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+
+	default:
+		exchangeError(rw, http.StatusNotFound, "NotFound")
+	}
+}
+
+type fillJSON struct {
+	TradeID   int64         `json:"trade_id"`
+	ProductID string        `json:"product_id"`
+	OrderID   string        `json:"order_id"`
+	Price     string        `json:"price"`
+	Size      string        `json:"size"`
+	Fee       string        `json:"fee"`
+	Side      coinbase.Side `json:"side"`
+	Liquidity string        `json:"liquidity"`
+	Settled   bool          `json:"settled"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func (s *Server) handleFills(rw http.ResponseWriter, req *http.Request) {
+	if _, authErr := s.authenticate(req, true); authErr != "" {
+		exchangeError(rw, http.StatusUnauthorized, authErr)
+		return
+	}
+	if req.Method != "GET" {
+		exchangeError(rw, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[req.URL.Query().Get("order_id")]
+	if !ok || !o.own {
+		exchangeError(rw, http.StatusNotFound, "NotFound")
+		return
+	}
+	limit, after, ok := s.orderPaging(rw, req)
+	if !ok {
+		return
+	}
+	// Fills are listed newest first and the
+	// cursors are the IDs of their trades.
+	trades := s.trades[o.ProductID]
+	list := []*fillJSON{}
+	for i := len(trades) - 1; i >= 0 && len(list) < limit; i-- {
+		tr := trades[i]
+		if after > 0 && tr.ID >= int64(after) {
+			continue
+		}
+		liquidity := "T"
+		if tr.MakerOrderID == o.ID {
+			liquidity = "M"
+		} else if tr.TakerOrderID != o.ID {
+			continue
+		}
+		list = append(list, &fillJSON{
+			TradeID:   tr.ID,
+			ProductID: o.ProductID,
+			OrderID:   o.ID,
+			Price:     formatFloat(tr.Price),
+			Size:      formatFloat(tr.Size),
+			Fee:       "0",
+			Side:      o.Side,
+			Liquidity: liquidity,
+			Settled:   true,
+			CreatedAt: tr.Time,
+		})
+	}
+	if n := len(list); n > 0 {
+		rw.Header().Set("CB-BEFORE", strconv.FormatInt(list[0].TradeID, 10))
+		rw.Header().Set("CB-AFTER", strconv.FormatInt(list[n-1].TradeID, 10))
+	}
+	writeJSON(rw, http.StatusOK, list)
+}
+
+func (s *Server) handleProducts(rw http.ResponseWriter, req *http.Request) {
+	splits := splitPath(req.URL.Path)
+	if len(splits) == 1 && req.Method == "GET" {
+		s.writeProducts(rw)
+		return
+	}
+	if len(splits) != 3 || req.Method != "GET" {
+		exchangeError(rw, http.StatusNotFound, "NotFound")
+		return
+	}
+	productID := splits[1]
+	if _, _, ok := splitProduct(productID); !ok {
+		exchangeError(rw, http.StatusNotFound, "NotFound")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch splits[2] {
+	case "ticker":
+		s.writeTicker(rw, productID)
+	case "candles":
+		s.writeCandles(rw, req, productID)
+	case "trades":
+		s.writeTrades(rw, req, productID)
+	case "stats":
+		s.writeStats(rw, productID)
+	case "book":
+		s.writeBook(rw, req, productID)
+	default:
+		exchangeError(rw, http.StatusNotFound, "NotFound")
+	}
+}
+
+// products are the products that the server lists,
+// between the currencies that its ledger starts off with.
+var products = [][2]string{{"BTC", "USD"}, {"ETH", "USD"}, {"LTC", "USD"}, {"ETH", "BTC"}, {"LTC", "BTC"}}
+
+func (s *Server) writeProducts(rw http.ResponseWriter) {
+	var out []map[string]string
+	for _, p := range products {
+		out = append(out, map[string]string{
+			"id":              p[0] + "-" + p[1],
+			"base_currency":   p[0],
+			"quote_currency":  p[1],
+			"base_min_size":   "0.01",
+			"base_max_size":   "10000",
+			"quote_increment": "0.01",
+			"display_name":    p[0] + "/" + p[1],
+			"status":          "online",
+		})
+	}
+	writeJSON(rw, http.StatusOK, out)
+}
+
+// writeTicker must be invoked with s.mu held.
+func (s *Server) writeTicker(rw http.ResponseWriter, productID string) {
+	ticker := map[string]interface{}{}
+	trades := s.trades[productID]
+	if n := len(trades); n > 0 {
+		last := trades[n-1]
+		ticker["trade_id"] = last.ID
+		ticker["price"] = formatFloat(last.Price)
+		ticker["size"] = formatFloat(last.Size)
+		ticker["time"] = last.Time
+
+		var volume float64
+		since := s.now().Add(-24 * time.Hour)
+		for _, tr := range trades {
+			if tr.Time.After(since) {
+				volume += tr.Size
+			}
+		}
+		ticker["volume"] = formatFloat(volume)
+	}
+	b := s.book(productID)
+	if len(b.bids) > 0 {
+		ticker["bid"] = formatFloat(b.bids[0].Price)
+	}
+	if len(b.asks) > 0 {
+		ticker["ask"] = formatFloat(b.asks[0].Price)
+	}
+	writeJSON(rw, http.StatusOK, ticker)
+}
+
+// writeStats must be invoked with s.mu held.
+func (s *Server) writeStats(rw http.ResponseWriter, productID string) {
+	var open, high, low, volume, last, volume30Day float64
+	now := s.now()
+	since, since30Day := now.Add(-24*time.Hour), now.Add(-30*24*time.Hour)
+	for _, tr := range s.trades[productID] {
+		if tr.Time.After(since30Day) {
+			volume30Day += tr.Size
+		}
+		if !tr.Time.After(since) {
+			continue
+		}
+		if volume == 0 {
+			open, high, low = tr.Price, tr.Price, tr.Price
+		}
+		high, low = math.Max(high, tr.Price), math.Min(low, tr.Price)
+		volume += tr.Size
+		last = tr.Price
+	}
+	writeJSON(rw, http.StatusOK, map[string]string{
+		"open":         formatFloat(open),
+		"high":         formatFloat(high),
+		"low":          formatFloat(low),
+		"volume":       formatFloat(volume),
+		"last":         formatFloat(last),
+		"volume_30day": formatFloat(volume30Day),
+	})
+}
+
+// writeBook must be invoked with s.mu held.
+func (s *Server) writeBook(rw http.ResponseWriter, req *http.Request, productID string) {
+	depth := 1
+	switch level := req.URL.Query().Get("level"); level {
+	case "", "1":
+	case "2":
+		depth = 50
+	default:
+		exchangeError(rw, http.StatusBadRequest, "Invalid level")
+		return
+	}
+	b := s.book(productID)
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"sequence": s.sequences[productID],
+		"bids":     aggregate(b.bids, depth),
+		"asks":     aggregate(b.asks, depth),
+	})
+}
+
+// aggregate sums up the remaining sizes of the first depth
+// price levels of orders, as [price, size, num_orders] entries.
+func aggregate(orders []*order, depth int) [][]interface{} {
+	entries := [][]interface{}{}
+	var size float64
+	var n int
+	for i, o := range orders {
+		size += o.remaining()
+		n++
+		if i+1 < len(orders) && orders[i+1].Price == o.Price {
+			continue
+		}
+		entries = append(entries, []interface{}{formatFloat(o.Price), formatFloat(roundSize(size)), n})
+		if len(entries) == depth {
+			break
+		}
+		size, n = 0, 0
+	}
+	return entries
+}
+
+// maxCandles is the most candles that the exchange returns per request.
+const maxCandles = 300
+
+// orderPaging parses the limit and after cursor of order listings.
+func (s *Server) orderPaging(rw http.ResponseWriter, req *http.Request) (limit, after int, ok bool) {
+	query := req.URL.Query()
+	limit = 100
+	if l := query.Get("limit"); l != "" {
+		li, err := strconv.Atoi(l)
+		if err != nil || li <= 0 || li > 100 {
+			exchangeError(rw, http.StatusBadRequest, "Invalid limit")
+			return 0, 0, false
+		}
+		limit = li
+	}
+	if a := query.Get("after"); a != "" {
+		ai, err := strconv.Atoi(a)
+		if err != nil || ai <= 0 {
+			exchangeError(rw, http.StatusBadRequest, "Invalid after")
+			return 0, 0, false
+		}
+		after = ai
+	}
+	return limit, after, true
+}
+
+// writeTrades pages through trades newest first with the
+// before and after cursors being trade IDs, as the exchange does.
+// It must be invoked with s.mu held.
+func (s *Server) writeTrades(rw http.ResponseWriter, req *http.Request, productID string) {
+	query := req.URL.Query()
+	limit := 100
+	if l := query.Get("limit"); l != "" {
+		li, err := strconv.Atoi(l)
+		if err != nil || li <= 0 || li > 100 {
+			exchangeError(rw, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = li
+	}
+	var before, after int64
+	for key, dest := range map[string]*int64{"before": &before, "after": &after} {
+		if v := query.Get(key); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				exchangeError(rw, http.StatusBadRequest, fmt.Sprintf("Invalid %s", key))
+				return
+			}
+			*dest = id
+		}
+	}
+
+	trades := append([]*trade(nil), s.trades[productID]...)
+	sort.Slice(trades, func(i, j int) bool { return trades[i].ID > trades[j].ID })
+	var page []*trade
+	switch {
+	case before > 0:
+		// The page of trades immediately newer than before.
+		i := sort.Search(len(trades), func(i int) bool { return trades[i].ID <= before })
+		page = trades[:i]
+		if len(page) > limit {
+			page = page[len(page)-limit:]
+		}
+	case after > 0:
+		i := sort.Search(len(trades), func(i int) bool { return trades[i].ID < after })
+		page = trades[i:]
+		if len(page) > limit {
+			page = page[:limit]
+		}
+	default:
+		page = trades
+		if len(page) > limit {
+			page = page[:limit]
+		}
+	}
+
+	out := make([]map[string]interface{}, 0, len(page))
+	for _, tr := range page {
+		out = append(out, map[string]interface{}{
+			"time":     tr.Time,
+			"trade_id": tr.ID,
+			"price":    formatFloat(tr.Price),
+			"size":     formatFloat(tr.Size),
+			"side":     tr.Side,
+		})
+	}
+	if n := len(page); n > 0 {
+		rw.Header().Set("CB-BEFORE", strconv.FormatInt(page[0].ID, 10))
+		rw.Header().Set("CB-AFTER", strconv.FormatInt(page[n-1].ID, 10))
+	}
+	writeJSON(rw, http.StatusOK, out)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05.00000Z", s)
+}
+
+func validGranularity(granularity int64) bool {
+	switch granularity {
+	case 60, 300, 900, 3600, 21600, 86400:
+		return true
+	}
+	return false
+}
+
+// writeCandles must be invoked with s.mu held.
+func (s *Server) writeCandles(rw http.ResponseWriter, req *http.Request, productID string) {
+	query := req.URL.Query()
+	granularity := int64(60)
+	if g := query.Get("granularity"); g != "" {
+		gi, err := strconv.ParseInt(g, 10, 64)
+		if err != nil || !validGranularity(gi) {
+			exchangeError(rw, http.StatusBadRequest, "Unsupported granularity")
+			return
+		}
+		granularity = gi
+	}
+	step := time.Duration(granularity) * time.Second
+
+	end := s.now()
+	start := end.Add(-maxCandles * step)
+	if qs, qe := query.Get("start"), query.Get("end"); qs != "" && qe != "" {
+		var err error
+		if start, err = parseTime(qs); err != nil {
+			exchangeError(rw, http.StatusBadRequest, fmt.Sprintf("Invalid start: %v", err))
+			return
+		}
+		if end, err = parseTime(qe); err != nil {
+			exchangeError(rw, http.StatusBadRequest, fmt.Sprintf("Invalid end: %v", err))
+			return
+		}
+	}
+	// Candles are bucketed on multiples of the granularity and
+	// the buckets at both the start and end times are included.
+	start, end = start.Truncate(step), end.Truncate(step)
+	if end.Sub(start)/step >= maxCandles {
+		exchangeError(rw, http.StatusBadRequest, "granularity too small for the requested time range")
+		return
+	}
+
+	buckets := make(map[int64][]float64)
+	var times []int64
+	for _, tr := range s.trades[productID] {
+		if t := tr.Time.Truncate(step); t.Before(start) || t.After(end) {
+			continue
+		}
+		bucket := tr.Time.Unix() - tr.Time.Unix()%granularity
+		cs, ok := buckets[bucket]
+		if !ok {
+			// [time, low, high, open, close, volume]
+			cs = []float64{float64(bucket), tr.Price, tr.Price, tr.Price, tr.Price, 0}
+			buckets[bucket] = cs
+			times = append(times, bucket)
+		}
+		cs[1] = math.Min(cs[1], tr.Price)
+		cs[2] = math.Max(cs[2], tr.Price)
+		cs[4] = tr.Price
+		cs[5] += tr.Size
+	}
+
+	// Candles are returned newest first.
+	sort.Slice(times, func(i, j int) bool { return times[i] > times[j] })
+	candles := make([][]float64, 0, len(times))
+	for _, t := range times {
+		candles = append(candles, buckets[t])
+	}
+	writeJSON(rw, http.StatusOK, candles)
+}
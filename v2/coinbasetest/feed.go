@@ -0,0 +1,697 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbasetest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/wsu"
+)
+
+// feed fans out the exchange's messages to the websocket
+// connections that are subscribed to their products.
+type feed struct {
+	mu     sync.Mutex
+	conns  map[*wsConn]bool
+	closed bool
+	down   bool
+
+	// held are the messages published while the feed
+	// is held back, to be sent once it no longer is.
+	holding bool
+	held    []*feedMessage
+}
+
+// feedMessage is a message of the feed as sent to
+// the subscribers with and without the user's fields.
+type feedMessage struct {
+	productID string
+	kind      string
+	public    []byte
+	private   []byte
+	user      bool
+}
+
+func newFeed() *feed {
+	return &feed{conns: make(map[*wsConn]bool)}
+}
+
+func (f *feed) add(wc *wsConn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed || f.down {
+		return false
+	}
+	f.conns[wc] = true
+	return true
+}
+
+func (f *feed) remove(wc *wsConn) {
+	f.mu.Lock()
+	delete(f.conns, wc)
+	f.mu.Unlock()
+}
+
+func (f *feed) close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.setDown(true)
+}
+
+// setDown disconnects every connection and
+// refuses new ones for as long as it is down.
+func (f *feed) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	if !down {
+		f.mu.Unlock()
+		return
+	}
+	conns := f.conns
+	f.conns = make(map[*wsConn]bool)
+	f.mu.Unlock()
+
+	for wc := range conns {
+		wc.close()
+	}
+}
+
+// setHeld holds the messages back, in the order that they were
+// published, for as long as it is held, instead of sending them.
+func (f *feed) setHeld(held bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.holding = held
+	if held {
+		return
+	}
+	for _, fm := range f.held {
+		f.send(fm)
+	}
+	f.held = nil
+}
+
+// channelsOf maps the types of messages to the channels that they
+// are sent on. The user channel only gets those of the user's orders.
+var channelsOf = map[string][]string{
+	"received": {"full", "user"},
+	"open":     {"full", "user"},
+	"done":     {"full", "user"},
+	"change":   {"full", "user"},
+	"activate": {"full", "user"},
+	"match":    {"full", "matches", "user"},
+	"ticker":   {"ticker"},
+}
+
+// publish sends msg to every subscriber of productID, once per channel
+// subscribed to, just like the exchange does. Messages of the user's own
+// orders are only sent with the user's fields to authenticated subscribers.
+// It doesn't block: subscribers that aren't keeping up are disconnected,
+// just like the exchange does.
+func (f *feed) publish(productID string, msg map[string]interface{}, userFields map[string]interface{}) {
+	public, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	private := public
+	if len(userFields) > 0 {
+		merged := make(map[string]interface{}, len(msg)+len(userFields))
+		for k, v := range msg {
+			merged[k] = v
+		}
+		for k, v := range userFields {
+			merged[k] = v
+		}
+		if private, err = json.Marshal(merged); err != nil {
+			return
+		}
+	}
+	kind, _ := msg["type"].(string)
+	fm := &feedMessage{productID: productID, kind: kind, public: public, private: private, user: len(userFields) > 0}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.holding {
+		f.held = append(f.held, fm)
+		return
+	}
+	f.send(fm)
+}
+
+// send must be invoked with f.mu held.
+func (f *feed) send(fm *feedMessage) {
+	for wc := range f.conns {
+		frame := fm.public
+		if wc.isAuthenticated() {
+			frame = fm.private
+		}
+	channels:
+		for _, ch := range channelsOf[fm.kind] {
+			if !wc.subscribed(ch, fm.productID) || (ch == "user" && !fm.user) {
+				continue
+			}
+			select {
+			case wc.sendq <- frame:
+			default:
+				delete(f.conns, wc)
+				go wc.close()
+				break channels
+			}
+		}
+	}
+}
+
+// publishOrder must be invoked with s.mu held.
+func (s *Server) publishOrder(o *order, kind string, extra map[string]interface{}) {
+	msg := map[string]interface{}{
+		"type":       kind,
+		"time":       s.now(),
+		"product_id": o.ProductID,
+		"sequence":   s.nextSequence(o.ProductID),
+		"order_id":   o.ID,
+		"side":       o.Side,
+	}
+	if o.Price > 0 {
+		msg["price"] = formatFloat(o.Price)
+	}
+	switch kind {
+	case "received":
+		msg["order_type"] = o.Type
+		if o.Size > 0 {
+			msg["size"] = formatFloat(o.Size)
+		}
+		if o.Funds > 0 {
+			msg["funds"] = formatFloat(o.Funds)
+		}
+		if o.ClientOID != "" {
+			msg["client_oid"] = o.ClientOID
+		}
+	case "open":
+		msg["remaining_size"] = formatFloat(o.remaining())
+	case "done":
+		msg["reason"] = o.DoneReason
+		if o.Size > 0 {
+			msg["remaining_size"] = formatFloat(roundSize(o.remaining()))
+		}
+	}
+	for k, v := range extra {
+		msg[k] = v
+	}
+	s.feed.publish(o.ProductID, msg, s.userFields(o))
+}
+
+// publishMatch must be invoked with s.mu held.
+func (s *Server) publishMatch(taker, maker *order, tr *trade) {
+	match := map[string]interface{}{
+		"type":           "match",
+		"trade_id":       tr.ID,
+		"time":           tr.Time,
+		"product_id":     taker.ProductID,
+		"sequence":       s.nextSequence(taker.ProductID),
+		"maker_order_id": maker.ID,
+		"taker_order_id": taker.ID,
+		"size":           formatFloat(tr.Size),
+		"price":          formatFloat(tr.Price),
+		"side":           maker.Side,
+	}
+	userFields := s.userFields(maker)
+	if takerFields := s.userFields(taker); takerFields != nil {
+		if userFields == nil {
+			userFields = make(map[string]interface{})
+		}
+		userFields["taker_user_id"] = takerFields["user_id"]
+		userFields["taker_profile_id"] = takerFields["profile_id"]
+	}
+	s.feed.publish(taker.ProductID, match, userFields)
+
+	ticker := map[string]interface{}{
+		"type":       "ticker",
+		"trade_id":   tr.ID,
+		"time":       tr.Time,
+		"product_id": taker.ProductID,
+		"sequence":   s.nextSequence(taker.ProductID),
+		"price":      formatFloat(tr.Price),
+		"side":       taker.Side,
+		"last_size":  formatFloat(tr.Size),
+	}
+	b := s.book(taker.ProductID)
+	if len(b.bids) > 0 {
+		ticker["best_bid"] = formatFloat(b.bids[0].Price)
+	}
+	if len(b.asks) > 0 {
+		ticker["best_ask"] = formatFloat(b.asks[0].Price)
+	}
+	s.feed.publish(taker.ProductID, ticker, nil)
+}
+
+// userFields must be invoked with s.mu held.
+func (s *Server) userFields(o *order) map[string]interface{} {
+	if !o.own {
+		return nil
+	}
+	return map[string]interface{}{
+		"user_id":    s.user.ID,
+		"profile_id": s.user.ID,
+	}
+}
+
+// nextSequence must be invoked with s.mu held.
+func (s *Server) nextSequence(productID string) int64 {
+	s.sequences[productID] += 1
+	return s.sequences[productID]
+}
+
+type subscribeJSON struct {
+	Type       string         `json:"type"`
+	ProductIDs []string       `json:"product_ids"`
+	Channels   []*channelJSON `json:"channels"`
+
+	APIKey     string `json:"key"`
+	Signature  string `json:"signature"`
+	Timestamp  string `json:"timestamp"`
+	Passphrase string `json:"passphrase"`
+}
+
+// channelJSON is a channel, either by name for the products of the
+// subscription or as an object with its own products.
+type channelJSON struct {
+	Name       string   `json:"name"`
+	ProductIDs []string `json:"product_ids"`
+}
+
+func (cj *channelJSON) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &cj.Name); err == nil {
+		return nil
+	}
+	type plain channelJSON
+	return json.Unmarshal(b, (*plain)(cj))
+}
+
+var knownChannels = map[string]bool{
+	"full":      true,
+	"user":      true,
+	"matches":   true,
+	"ticker":    true,
+	"heartbeat": true,
+	"level2":    true,
+}
+
+// channels returns the products subscribed to per channel, checking that
+// the user channel is authenticated. Without channels, the products
+// are subscribed to the full and ticker channels.
+func (sub *subscribeJSON) channels(authenticated bool) (map[string][]string, error) {
+	byChannel := make(map[string][]string)
+	if len(sub.Channels) == 0 {
+		byChannel["full"] = sub.ProductIDs
+		byChannel["ticker"] = sub.ProductIDs
+		return byChannel, nil
+	}
+	for _, cj := range sub.Channels {
+		if !knownChannels[cj.Name] {
+			return nil, fmt.Errorf("%q is not a valid channel", cj.Name)
+		}
+		if cj.Name == "user" && !authenticated {
+			return nil, errors.New("Authentication required for the user channel")
+		}
+		productIDs := cj.ProductIDs
+		if len(productIDs) == 0 {
+			productIDs = sub.ProductIDs
+		}
+		byChannel[cj.Name] = append(byChannel[cj.Name], productIDs...)
+	}
+	return byChannel, nil
+}
+
+func (s *Server) handleFeed(rw http.ResponseWriter, req *http.Request) {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		exchangeError(rw, http.StatusNotFound, "NotFound")
+		return
+	}
+	wc, err := upgrade(rw, req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.feed.add(wc) {
+		wc.close()
+		return
+	}
+	s.serveFeed(wc)
+}
+
+// serveFeed handles the subscriptions of wc, which
+// must have been added to the feed, until it is closed.
+func (s *Server) serveFeed(wc *wsConn) {
+	defer s.feed.remove(wc)
+	defer wc.close()
+
+	go wc.writeLoop()
+	for {
+		frame, err := wc.readMessage()
+		if err != nil {
+			return
+		}
+		sub := new(subscribeJSON)
+		if err := json.Unmarshal(frame, sub); err != nil {
+			wc.sendError("Failed to parse the message")
+			continue
+		}
+		switch sub.Type {
+		case "subscribe":
+			authenticated := sub.Signature != ""
+			if authenticated && !s.authenticateFeed(sub) {
+				wc.sendError("Authentication failed")
+				continue
+			}
+			byChannel, err := sub.channels(authenticated)
+			if err != nil {
+				wc.sendError(err.Error())
+				continue
+			}
+			wc.subscribe(byChannel, authenticated)
+		case "unsubscribe":
+			byChannel, err := sub.channels(true)
+			if err != nil {
+				wc.sendError(err.Error())
+				continue
+			}
+			if len(sub.Channels) == 0 {
+				// Unsubscribing from products leaves every channel.
+				for ch := range knownChannels {
+					byChannel[ch] = sub.ProductIDs
+				}
+			}
+			wc.unsubscribe(byChannel)
+		default:
+			wc.sendError(fmt.Sprintf("Unsupported message type %q", sub.Type))
+		}
+	}
+}
+
+func (s *Server) authenticateFeed(sub *subscribeJSON) bool {
+	s.mu.Lock()
+	creds := s.creds
+	s.mu.Unlock()
+	if creds == nil || sub.APIKey != creds.APIKey || sub.Passphrase != creds.Passphrase {
+		return false
+	}
+	return sub.Signature == sign(creds.APISecret, sub.Timestamp, "GET", "/users/self", nil)
+}
+
+var errFeedDown = errors.New("coinbasetest: the feed is down")
+
+// DialFeed connects to the feed in memory, without going over the
+// network. Clients returned by NewClient connect with it.
+func (s *Server) DialFeed(feedURL string) (coinbase.FeedConn, error) {
+	serverConn, clientConn := net.Pipe()
+	wc := newWSConn(serverConn, bufio.NewReader(serverConn))
+	if !s.feed.add(wc) {
+		serverConn.Close()
+		clientConn.Close()
+		return nil, errFeedDown
+	}
+	go s.serveFeed(wc)
+
+	fc := &feedClient{wc: newWSConn(clientConn, bufio.NewReader(clientConn))}
+	go fc.wc.writeLoop()
+	return fc, nil
+}
+
+// SetFeedDown disconnects every connection to the feed and
+// refuses new ones, until it is invoked with false, to
+// simulate the loss of the feed.
+func (s *Server) SetFeedDown(down bool) {
+	s.feed.setDown(down)
+}
+
+// SetFeedHeld holds back the messages of the feed, until it is invoked
+// with false, to simulate a feed lagging behind the exchange's REST API.
+func (s *Server) SetFeedHeld(held bool) {
+	s.feed.setHeld(held)
+}
+
+// feedClient is the client side of a connection made by DialFeed.
+type feedClient struct {
+	wc *wsConn
+}
+
+var _ coinbase.FeedConn = (*feedClient)(nil)
+
+func (fc *feedClient) Send(msg *wsu.Message) {
+	select {
+	case fc.wc.sendq <- msg.Frame:
+	case <-fc.wc.done:
+	}
+}
+
+func (fc *feedClient) Receive() (*wsu.Message, bool) {
+	frame, err := fc.wc.readMessage()
+	if err != nil {
+		fc.wc.close()
+		return nil, false
+	}
+	return &wsu.Message{Frame: frame}, true
+}
+
+func (fc *feedClient) Close() error {
+	fc.wc.close()
+	return nil
+}
+
+// wsConn is the server side of a websocket connection. It only
+// implements as much of RFC 6455 as is needed to serve the feed.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu       sync.Mutex
+	sendq     chan []byte
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu sync.Mutex
+	// channels maps the channels subscribed to to their products.
+	channels      map[string]map[string]bool
+	authenticated bool
+}
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func upgrade(rw http.ResponseWriter, req *http.Request) (*wsConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("expecting header Sec-WebSocket-Key")
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection can't be hijacked")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, brw.Reader), nil
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader) *wsConn {
+	return &wsConn{
+		conn:     conn,
+		br:       br,
+		sendq:    make(chan []byte, 1024),
+		done:     make(chan struct{}),
+		channels: make(map[string]map[string]bool),
+	}
+}
+
+func (wc *wsConn) subscribe(byChannel map[string][]string, authenticated bool) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	for ch, productIDs := range byChannel {
+		if wc.channels[ch] == nil {
+			wc.channels[ch] = make(map[string]bool)
+		}
+		for _, productID := range productIDs {
+			wc.channels[ch][productID] = true
+		}
+	}
+	if authenticated {
+		wc.authenticated = true
+	}
+}
+
+func (wc *wsConn) unsubscribe(byChannel map[string][]string) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	for ch, productIDs := range byChannel {
+		for _, productID := range productIDs {
+			delete(wc.channels[ch], productID)
+		}
+	}
+}
+
+func (wc *wsConn) subscribed(ch, productID string) bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.channels[ch][productID]
+}
+
+func (wc *wsConn) isAuthenticated() bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.authenticated
+}
+
+func (wc *wsConn) sendError(msg string) {
+	blob, _ := json.Marshal(map[string]string{"type": "error", "message": msg})
+	select {
+	case wc.sendq <- blob:
+	default:
+	}
+}
+
+func (wc *wsConn) close() {
+	wc.closeOnce.Do(func() {
+		close(wc.done)
+		wc.wmu.Lock()
+		wc.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		wc.conn.Write([]byte{0x88, 0x00}) // Close frame
+		wc.wmu.Unlock()
+		wc.conn.Close()
+	})
+}
+
+func (wc *wsConn) writeLoop() {
+	for {
+		select {
+		case frame := <-wc.sendq:
+			if err := wc.writeFrame(0x1, frame); err != nil {
+				wc.close()
+				return
+			}
+		case <-wc.done:
+			return
+		}
+	}
+}
+
+func (wc *wsConn) writeFrame(opcode byte, payload []byte) error {
+	wc.wmu.Lock()
+	defer wc.wmu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, ext[:]...)
+	}
+	if _, err := wc.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := wc.conn.Write(payload)
+	return err
+}
+
+// readMessage returns the payload of the next text or binary
+// message, replying to pings and reassembling fragments.
+func (wc *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(wc.br, hdr[:]); err != nil {
+			return nil, err
+		}
+		fin, opcode := hdr[0]&0x80 != 0, hdr[0]&0x0F
+		masked := hdr[1]&0x80 != 0
+		n := uint64(hdr[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(wc.br, ext[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(wc.br, ext[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > 1<<20 {
+			return nil, errors.New("frame too large")
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(wc.br, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(wc.br, payload); err != nil {
+			return nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case 0x8: // Close
+			return nil, io.EOF
+		case 0x9: // Ping
+			if err := wc.writeFrame(0xA, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case 0xA: // Pong
+			continue
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coinbasetest provides an in-process fake of the Coinbase wallet
+// and GDAX exchange APIs for end-to-end tests of code built on coinbase.Client.
+//
+// The fake serves the accounts, addresses, users, exchange rates, time,
+// orders, fills, ticker and candles endpoints as well as the websocket feed.
+// Orders are matched by a simple price-time priority matching engine
+// against each other and against liquidity added with AddLiquidity,
+// and fills are settled in an in-memory balance ledger. Clients made
+// by NewClient reach the feed in memory, SetFeedHeld simulates its
+// lag and SetFeedDown its loss:
+//
+//	srv := coinbasetest.NewServer()
+//	defer srv.Close()
+//
+//	srv.AddLiquidity("BTC-USD", coinbase.SideSell, 4000, 2)
+//	client := srv.NewClient()
+//	res, err := client.Order(&coinbase.Order{
+//		Product: "BTC-USD",
+//		Side:    coinbase.SideBuy,
+//		Price:   4000,
+//		Size:    1,
+//	})
+package coinbasetest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+// DefaultCredentials are the credentials that the server
+// accepts unless Server.Credentials is changed.
+var DefaultCredentials = &coinbase.Credentials{
+	APIKey:     "coinbasetest-key",
+	APISecret:  "coinbasetest-secret",
+	Passphrase: "coinbasetest-passphrase",
+}
+
+type Server struct {
+	// URL is the base URL of the server e.g. http://127.0.0.1:41337
+	URL string
+
+	// FeedURL is the URL of the websocket feed.
+	FeedURL string
+
+	ts *httptest.Server
+
+	mu sync.Mutex
+
+	creds       *coinbase.Credentials
+	accessToken string
+	clock       func() time.Time
+
+	lastID int64
+
+	user      *user
+	accounts  []*account
+	addresses map[string][]*address
+
+	// usdPrices are the prices, in USD, of the
+	// currencies used to derive exchange rates.
+	usdPrices map[string]float64
+
+	books     map[string]*book
+	orders    map[string]*order
+	orderList []*order
+	trades    map[string][]*trade
+	sequences map[string]int64
+
+	feed *feed
+}
+
+// NewServer starts a fake server seeded with a user that
+// has USD, BTC, ETH and LTC accounts. It must be closed
+// by invoking Close once it is no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		creds:     DefaultCredentials,
+		clock:     time.Now,
+		addresses: make(map[string][]*address),
+		usdPrices: map[string]float64{
+			"USD": 1,
+			"BTC": 4000,
+			"ETH": 300,
+			"LTC": 50,
+			"EUR": 1.18,
+			"CAD": 0.8,
+		},
+		books:     make(map[string]*book),
+		orders:    make(map[string]*order),
+		trades:    make(map[string][]*trade),
+		sequences: make(map[string]int64),
+		feed:      newFeed(),
+	}
+	s.seed()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", s.handleWallet)
+	mux.HandleFunc("/orders", s.handleOrders)
+	mux.HandleFunc("/orders/", s.handleOrders)
+	mux.HandleFunc("/fills", s.handleFills)
+	mux.HandleFunc("/products", s.handleProducts)
+	mux.HandleFunc("/products/", s.handleProducts)
+	mux.HandleFunc("/", s.handleFeed)
+
+	s.ts = httptest.NewServer(mux)
+	s.URL = s.ts.URL
+	s.FeedURL = "ws" + strings.TrimPrefix(s.ts.URL, "http")
+	return s
+}
+
+// Close shuts down the server and its feed.
+func (s *Server) Close() {
+	s.feed.close()
+	s.ts.Close()
+}
+
+// SetCredentials changes the credentials that the server accepts.
+func (s *Server) SetCredentials(creds *coinbase.Credentials) {
+	s.mu.Lock()
+	s.creds = creds
+	s.mu.Unlock()
+}
+
+// SetAccessToken makes the server also accept
+// OAuth2 requests bearing accessToken.
+func (s *Server) SetAccessToken(accessToken string) {
+	s.mu.Lock()
+	s.accessToken = accessToken
+	s.mu.Unlock()
+}
+
+// SetClock makes the server use clock for all of its timestamps.
+func (s *Server) SetClock(clock func() time.Time) {
+	s.mu.Lock()
+	if clock == nil {
+		clock = time.Now
+	}
+	s.clock = clock
+	s.mu.Unlock()
+}
+
+// now must be invoked with s.mu held.
+func (s *Server) now() time.Time {
+	return s.clock().UTC()
+}
+
+// Transport returns an http.RoundTripper that routes
+// every request, whatever its host, to the server.
+func (s *Server) Transport() http.RoundTripper {
+	return &rewriter{target: s.ts.URL, rt: s.ts.Client().Transport}
+}
+
+// NewClient returns a client that is authenticated with the
+// server's credentials and that sends its requests to the server.
+func (s *Server) NewClient() *coinbase.Client {
+	s.mu.Lock()
+	creds := *s.creds
+	s.mu.Unlock()
+
+	client, _ := coinbase.NewClient(&creds)
+	client.SetHTTPRoundTripper(s.Transport())
+	client.SetFeedURL(s.FeedURL)
+	client.SetFeedDialer(s.DialFeed)
+	// The fake server isn't rate limited.
+	client.SetPublicRateLimit(0)
+	return client
+}
+
+type rewriter struct {
+	target string
+	rt     http.RoundTripper
+}
+
+func (rw *rewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(rw.target)
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.URL.Scheme = target.Scheme
+	clone.URL.Host = target.Host
+	clone.Host = target.Host
+	return rw.rt.RoundTrip(clone)
+}
+
+// nextID must be invoked with s.mu held.
+func (s *Server) nextID() string {
+	s.lastID += 1
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.lastID)
+}
+
+type errorJSON struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// walletError writes an error in the wallet API's format.
+func walletError(rw http.ResponseWriter, code int, id, msg string) {
+	writeJSON(rw, code, map[string][]*errorJSON{
+		"errors": {{ID: id, Message: msg}},
+	})
+}
+
+// exchangeError writes an error in the exchange API's format.
+func exchangeError(rw http.ResponseWriter, code int, msg string) {
+	writeJSON(rw, code, map[string]string{"message": msg})
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		code, blob = http.StatusInternalServerError, []byte(`{"message":"failed to serialize response"}`)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(blob)
+}
+
+// authenticate checks the request's HMAC signature or OAuth2 token and
+// returns its body. It must be invoked without s.mu held.
+func (s *Server) authenticate(req *http.Request, requirePassphrase bool) ([]byte, string) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	s.mu.Lock()
+	creds, accessToken, now := s.creds, s.accessToken, s.now()
+	s.mu.Unlock()
+
+	if authz := req.Header.Get("Authorization"); authz != "" {
+		if accessToken != "" && authz == "Bearer "+accessToken {
+			return body, ""
+		}
+		return nil, "invalid OAuth2 access token"
+	}
+	if creds == nil || req.Header.Get("CB-ACCESS-KEY") != creds.APIKey {
+		return nil, "invalid API key"
+	}
+	timestamp := req.Header.Get("CB-ACCESS-TIMESTAMP")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, "invalid timestamp"
+	}
+	if skew := now.Unix() - ts; skew > 30 || skew < -30 {
+		return nil, "request timestamp expired"
+	}
+	urlPath := req.URL.Path
+	if q := req.URL.Query(); len(q) > 0 {
+		urlPath += "?" + q.Encode()
+	}
+	if req.Header.Get("CB-ACCESS-SIGN") != sign(creds.APISecret, timestamp, req.Method, urlPath, body) {
+		return nil, "invalid signature"
+	}
+	if requirePassphrase && req.Header.Get("CB-ACCESS-PASSPHRASE") != creds.Passphrase {
+		return nil, "invalid passphrase"
+	}
+	return body, ""
+}
+
+func sign(secret, timestamp, method, urlPath string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s%s%s%s", timestamp, method, urlPath, body)
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// splitPath splits "/v2/accounts/id" into ["v2", "accounts", "id"].
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
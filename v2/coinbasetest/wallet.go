@@ -0,0 +1,441 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbasetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type user struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Username       string    `json:"username"`
+	Email          string    `json:"email,omitempty"`
+	Timezone       string    `json:"time_zone,omitempty"`
+	NativeCurrency string    `json:"native_currency,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type account struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Primary   bool      `json:"primary"`
+	Type      string    `json:"type"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	balance float64
+	hold    float64
+}
+
+type balanceJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type accountJSON struct {
+	*account
+	Balance       *balanceJSON `json:"balance"`
+	NativeBalance *balanceJSON `json:"native_balance"`
+}
+
+type address struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Name      string    `json:"name,omitempty"`
+	Network   string    `json:"network"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type paginationJSON struct {
+	EndingBefore  *string `json:"ending_before"`
+	StartingAfter *string `json:"starting_after"`
+	Limit         int     `json:"limit"`
+	Order         string  `json:"order"`
+	PreviousURI   *string `json:"previous_uri"`
+	NextURI       *string `json:"next_uri"`
+}
+
+func (s *Server) seed() {
+	now := s.now()
+	s.user = &user{
+		ID:             s.nextID(),
+		Name:           "Satoshi Nakamoto",
+		Username:       "satoshi",
+		Email:          "satoshi@example.org",
+		Timezone:       "UTC",
+		NativeCurrency: "USD",
+		CreatedAt:      now,
+	}
+	for i, seed := range []struct {
+		name, currency, kind string
+		balance              float64
+	}{
+		{"BTC Wallet", "BTC", "wallet", 1},
+		{"ETH Wallet", "ETH", "wallet", 10},
+		{"LTC Wallet", "LTC", "wallet", 10},
+		{"USD Wallet", "USD", "fiat", 10000},
+	} {
+		s.accounts = append(s.accounts, &account{
+			ID:        s.nextID(),
+			Name:      seed.name,
+			Primary:   i == 0,
+			Type:      seed.kind,
+			Currency:  seed.currency,
+			CreatedAt: now,
+			UpdatedAt: now,
+			balance:   seed.balance,
+		})
+	}
+}
+
+// SetBalance sets the balance of the first
+// account in currency, creating it if necessary.
+func (s *Server) SetBalance(currency string, amount float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ledgerAccount(currency).balance = amount
+}
+
+// Balance returns the balance of the first
+// account in currency and the amount on hold.
+func (s *Server) Balance(currency string) (balance, hold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acct := s.ledgerAccount(currency)
+	return acct.balance, acct.hold
+}
+
+// SetUSDPrice sets the price in USD of currency, from which
+// the server derives the exchange rates that it serves.
+func (s *Server) SetUSDPrice(currency string, price float64) {
+	s.mu.Lock()
+	s.usdPrices[currency] = price
+	s.mu.Unlock()
+}
+
+// ledgerAccount returns the account used for settling trades
+// in currency. It must be invoked with s.mu held.
+func (s *Server) ledgerAccount(currency string) *account {
+	for _, acct := range s.accounts {
+		if acct.Currency == currency {
+			return acct
+		}
+	}
+	now := s.now()
+	acct := &account{
+		ID:        s.nextID(),
+		Name:      currency + " Wallet",
+		Type:      "wallet",
+		Currency:  currency,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.accounts = append(s.accounts, acct)
+	return acct
+}
+
+// accountJSON must be invoked with s.mu held.
+func (s *Server) accountJSON(acct *account) *accountJSON {
+	native := acct.balance * s.usdPrices[acct.Currency]
+	return &accountJSON{
+		account:       acct,
+		Balance:       &balanceJSON{Amount: formatFloat(acct.balance), Currency: acct.Currency},
+		NativeBalance: &balanceJSON{Amount: fmt.Sprintf("%.2f", native), Currency: "USD"},
+	}
+}
+
+func (s *Server) handleWallet(rw http.ResponseWriter, req *http.Request) {
+	splits := splitPath(req.URL.Path)[1:]
+	switch {
+	case len(splits) == 1 && splits[0] == "exchange-rates":
+		s.handleExchangeRates(rw, req)
+		return
+	case len(splits) == 1 && splits[0] == "time":
+		s.mu.Lock()
+		now := s.now()
+		s.mu.Unlock()
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"iso": now.Truncate(time.Second), "epoch": now.Unix()},
+		})
+		return
+	}
+
+	body, authErr := s.authenticate(req, false)
+	if authErr != "" {
+		walletError(rw, http.StatusUnauthorized, "authentication_error", authErr)
+		return
+	}
+
+	switch {
+	case len(splits) == 1 && splits[0] == "user" && req.Method == "GET":
+		s.mu.Lock()
+		u := *s.user
+		s.mu.Unlock()
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"data": u})
+
+	case len(splits) == 2 && splits[0] == "users" && req.Method == "GET":
+		s.mu.Lock()
+		u := *s.user
+		s.mu.Unlock()
+		if splits[1] != u.ID && splits[1] != "self" {
+			walletError(rw, http.StatusNotFound, "not_found", "User not found")
+			return
+		}
+		// Only the public fields are shown for other users.
+		u.Email, u.Timezone, u.NativeCurrency = "", "", ""
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"data": u})
+
+	case len(splits) >= 1 && splits[0] == "accounts":
+		s.handleAccounts(rw, req, splits[1:], body)
+
+	default:
+		walletError(rw, http.StatusNotFound, "not_found", "Not found")
+	}
+}
+
+func (s *Server) handleAccounts(rw http.ResponseWriter, req *http.Request, splits []string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(splits) == 0 {
+		switch req.Method {
+		case "GET":
+			items := make([]interface{}, len(s.accounts))
+			ids := make([]string, len(s.accounts))
+			for i, acct := range s.accounts {
+				items[i], ids[i] = s.accountJSON(acct), acct.ID
+			}
+			s.writePage(rw, req, items, ids)
+		case "POST":
+			recv := struct {
+				Name string `json:"name"`
+			}{}
+			if err := json.Unmarshal(body, &recv); err != nil || strings.TrimSpace(recv.Name) == "" {
+				walletError(rw, http.StatusBadRequest, "validation_error", "Name can't be blank")
+				return
+			}
+			now := s.now()
+			acct := &account{
+				ID:        s.nextID(),
+				Name:      recv.Name,
+				Type:      "wallet",
+				Currency:  "BTC",
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			s.accounts = append(s.accounts, acct)
+			writeJSON(rw, http.StatusCreated, map[string]interface{}{"data": s.accountJSON(acct)})
+		default:
+			walletError(rw, http.StatusMethodNotAllowed, "not_found", "Method not allowed")
+		}
+		return
+	}
+
+	index := -1
+	for i, acct := range s.accounts {
+		if acct.ID == splits[0] {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		walletError(rw, http.StatusNotFound, "not_found", "Account not found")
+		return
+	}
+	acct := s.accounts[index]
+
+	switch {
+	case len(splits) == 1 && req.Method == "GET":
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"data": s.accountJSON(acct)})
+
+	case len(splits) == 1 && req.Method == "PUT":
+		recv := struct {
+			Name string `json:"name"`
+		}{}
+		if err := json.Unmarshal(body, &recv); err != nil || strings.TrimSpace(recv.Name) == "" {
+			walletError(rw, http.StatusBadRequest, "validation_error", "Name can't be blank")
+			return
+		}
+		acct.Name = recv.Name
+		acct.UpdatedAt = s.now()
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"data": s.accountJSON(acct)})
+
+	case len(splits) == 1 && req.Method == "DELETE":
+		if acct.Primary {
+			walletError(rw, http.StatusBadRequest, "validation_error", "Primary account can't be deleted")
+			return
+		}
+		if acct.balance != 0 {
+			walletError(rw, http.StatusBadRequest, "validation_error", "Account with a balance can't be deleted")
+			return
+		}
+		s.accounts = append(s.accounts[:index], s.accounts[index+1:]...)
+		delete(s.addresses, acct.ID)
+		rw.WriteHeader(http.StatusNoContent)
+
+	case len(splits) == 2 && splits[1] == "primary" && req.Method == "POST":
+		for _, other := range s.accounts {
+			other.Primary = false
+		}
+		acct.Primary = true
+		acct.UpdatedAt = s.now()
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"data": s.accountJSON(acct)})
+
+	case len(splits) == 2 && splits[1] == "addresses" && req.Method == "GET":
+		addrs := s.addresses[acct.ID]
+		items := make([]interface{}, len(addrs))
+		ids := make([]string, len(addrs))
+		for i, addr := range addrs {
+			items[i], ids[i] = addr, addr.ID
+		}
+		s.writePage(rw, req, items, ids)
+
+	case len(splits) == 2 && splits[1] == "addresses" && req.Method == "POST":
+		recv := struct {
+			Name string `json:"name"`
+		}{}
+		json.Unmarshal(body, &recv)
+		now := s.now()
+		id := s.nextID()
+		addr := &address{
+			ID:        id,
+			Address:   fmt.Sprintf("%sfake%s", strings.ToLower(acct.Currency), strings.Replace(id, "-", "", -1)),
+			Name:      recv.Name,
+			Network:   networkOf(acct.Currency),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		s.addresses[acct.ID] = append(s.addresses[acct.ID], addr)
+		writeJSON(rw, http.StatusCreated, map[string]interface{}{"data": addr})
+
+	default:
+		walletError(rw, http.StatusNotFound, "not_found", "Not found")
+	}
+}
+
+func networkOf(currency string) string {
+	switch currency {
+	case "BTC":
+		return "bitcoin"
+	case "ETH":
+		return "ethereum"
+	case "LTC":
+		return "litecoin"
+	default:
+		return strings.ToLower(currency)
+	}
+}
+
+// writePage writes a page of items in the wallet API's pagination format,
+// honoring the limit, starting_after, ending_before and order parameters.
+func (s *Server) writePage(rw http.ResponseWriter, req *http.Request, items []interface{}, ids []string) {
+	query := req.URL.Query()
+	limit := 25
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	order := "desc"
+	if query.Get("order") == "asc" {
+		order = "asc"
+	}
+
+	// Apply the order without modifying the caller's slices.
+	n := len(items)
+	orderedItems := make([]interface{}, n)
+	orderedIDs := make([]string, n)
+	for i := range items {
+		j := i
+		if order == "desc" {
+			j = n - 1 - i
+		}
+		orderedItems[i], orderedIDs[i] = items[j], ids[j]
+	}
+
+	start, end := 0, n
+	if after := query.Get("starting_after"); after != "" {
+		for i, id := range orderedIDs {
+			if id == after {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if before := query.Get("ending_before"); before != "" {
+		for i, id := range orderedIDs {
+			if id == before {
+				end = i
+				break
+			}
+		}
+	}
+	if start > end {
+		start = end
+	}
+	if end-start > limit {
+		end = start + limit
+	}
+
+	page := &paginationJSON{Limit: limit, Order: order}
+	if end < n && end > start {
+		qv := make(url.Values)
+		qv.Set("limit", strconv.Itoa(limit))
+		qv.Set("order", order)
+		qv.Set("starting_after", orderedIDs[end-1])
+		nextURI := fmt.Sprintf("%s?%s", req.URL.Path, qv.Encode())
+		page.NextURI = &nextURI
+	}
+	data := orderedItems[start:end]
+	if data == nil {
+		data = []interface{}{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"pagination": page,
+		"data":       data,
+	})
+}
+
+func (s *Server) handleExchangeRates(rw http.ResponseWriter, req *http.Request) {
+	currency := req.URL.Query().Get("currency")
+	if currency == "" {
+		currency = "USD"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base, ok := s.usdPrices[currency]
+	if !ok || base == 0 {
+		walletError(rw, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid currency (%s)", currency))
+		return
+	}
+	rates := make(map[string]string)
+	for other, price := range s.usdPrices {
+		if price > 0 {
+			rates[other] = formatFloat(base / price)
+		}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{"currency": currency, "rates": rates},
+	})
+}
@@ -0,0 +1,301 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialsProvider retrieves API key credentials
+// from a source such as the environment or a file.
+type CredentialsProvider interface {
+	Credentials() (*Credentials, error)
+}
+
+var (
+	errNilCredentialsProvider = errors.New("expecting a non-nil CredentialsProvider")
+
+	errBlankCredentialsPath = errors.New("expecting a non-blank credentials file path")
+
+	errBlankCredentialsCommand = errors.New("expecting a non-blank credentials command")
+)
+
+// NewClientFromProvider creates a client
+// with the credentials from cp.
+func NewClientFromProvider(cp CredentialsProvider) (*Client, error) {
+	if cp == nil {
+		return nil, errNilCredentialsProvider
+	}
+	creds, err := cp.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(creds)
+}
+
+func (creds *Credentials) Validate() error {
+	if creds == nil {
+		return errNilCredentials
+	}
+	var errorsList []string
+	if strings.TrimSpace(creds.APIKey) == "" {
+		errorsList = append(errorsList, "expecting a non-blank API key")
+	}
+	if strings.TrimSpace(creds.APISecret) == "" {
+		errorsList = append(errorsList, "expecting a non-blank API secret")
+	}
+	if len(errorsList) > 0 {
+		return errors.New(strings.Join(errorsList, "\n"))
+	}
+	return nil
+}
+
+type envCredentialsProvider int
+
+// EnvCredentialsProvider returns a provider that reads the credentials
+// from the environment variables COINBASE_API_KEY, COINBASE_API_SECRET and
+// the optional COINBASE_API_PASSPHRASE. The environment is
+// re-read on every invocation of Credentials.
+func EnvCredentialsProvider() CredentialsProvider {
+	return envCredentialsProvider(0)
+}
+
+func (ecp envCredentialsProvider) Credentials() (*Credentials, error) {
+	var errorsList []string
+
+	apiKey := strings.TrimSpace(os.Getenv(envCoinbaseAPIKey))
+	if apiKey == "" {
+		errorsList = append(errorsList, fmt.Sprintf("could not find %q in your environment", envCoinbaseAPIKey))
+	}
+	apiSecret := strings.TrimSpace(os.Getenv(envCoinbaseAPISecret))
+	if apiSecret == "" {
+		errorsList = append(errorsList, fmt.Sprintf("could not find %q in your environment", envCoinbaseAPISecret))
+	}
+	if len(errorsList) > 0 {
+		return nil, errors.New(strings.Join(errorsList, "\n"))
+	}
+
+	// Passphrase is an optional field that's only used when
+	// purchasing, canceling and viewing private content.
+	passphrase := strings.TrimSpace(os.Getenv(envCoinbasePassphrase))
+
+	return &Credentials{APIKey: apiKey, APISecret: apiSecret, Passphrase: passphrase}, nil
+}
+
+// FileCredentialsProvider reads credentials from a JSON file
+// or a YAML file (by its ".yaml" or ".yml" extension) of the form:
+//
+//	api_key: <KEY>
+//	api_secret: <SECRET>
+//	passphrase: <PASSPHRASE>
+//
+// The file is only re-read after it has been modified.
+type FileCredentialsProvider struct {
+	Path string `json:"path"`
+
+	mu      sync.Mutex
+	modTime time.Time
+	creds   *Credentials
+}
+
+var _ CredentialsProvider = (*FileCredentialsProvider)(nil)
+
+func (fcp *FileCredentialsProvider) Credentials() (*Credentials, error) {
+	path := strings.TrimSpace(fcp.Path)
+	if path == "" {
+		return nil, errBlankCredentialsPath
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fcp.mu.Lock()
+	defer fcp.mu.Unlock()
+
+	if fcp.creds != nil && fi.ModTime().Equal(fcp.modTime) {
+		return fcp.creds, nil
+	}
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds *Credentials
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		creds, err = parseYAMLCredentials(blob)
+	default:
+		creds, err = parseJSONCredentials(blob)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	fcp.creds, fcp.modTime = creds, fi.ModTime()
+	return creds, nil
+}
+
+func parseJSONCredentials(blob []byte) (*Credentials, error) {
+	creds := new(Credentials)
+	if err := json.Unmarshal(blob, creds); err != nil {
+		return nil, err
+	}
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// parseYAMLCredentials parses flat "key: value" YAML documents,
+// which is all that's needed for credentials files.
+func parseYAMLCredentials(blob []byte) (*Credentials, error) {
+	creds := new(Credentials)
+	scanner := bufio.NewScanner(bytes.NewReader(blob))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		splits := strings.SplitN(line, ":", 2)
+		if len(splits) != 2 {
+			return nil, fmt.Errorf("line #%d: expecting \"key: value\"", lineNumber)
+		}
+		key := strings.TrimSpace(splits[0])
+		value := strings.TrimSpace(splits[1])
+		if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+			value = value[1 : n-1]
+		}
+		switch key {
+		case "api_key":
+			creds.APIKey = value
+		case "api_secret":
+			creds.APISecret = value
+		case "passphrase":
+			creds.Passphrase = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// CommandCredentialsProvider runs a command, such as a secrets
+// manager's CLI, that prints the credentials as JSON to stdout.
+type CommandCredentialsProvider struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	// CacheDuration is how long the credentials are
+	// reused before the command is run again. If unset
+	// the command is run for every invocation of Credentials.
+	CacheDuration time.Duration `json:"cache_duration,omitempty"`
+
+	mu        sync.Mutex
+	fetchedAt time.Time
+	creds     *Credentials
+}
+
+var _ CredentialsProvider = (*CommandCredentialsProvider)(nil)
+
+func (ccp *CommandCredentialsProvider) Credentials() (*Credentials, error) {
+	if strings.TrimSpace(ccp.Command) == "" {
+		return nil, errBlankCredentialsCommand
+	}
+
+	ccp.mu.Lock()
+	defer ccp.mu.Unlock()
+
+	if ccp.creds != nil && time.Since(ccp.fetchedAt) < ccp.CacheDuration {
+		return ccp.creds, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(ccp.Command, ccp.Args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", ccp.Command, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", ccp.Command, err)
+	}
+	creds, err := parseJSONCredentials(stdout)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ccp.Command, err)
+	}
+	ccp.creds, ccp.fetchedAt = creds, time.Now()
+	return creds, nil
+}
+
+type CredentialsRotation struct {
+	Provider CredentialsProvider `json:"-"`
+
+	// Interval is the period between checks for new
+	// credentials. If unset, it defaults to 1 minute.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// ErrFunc if set is invoked with any errors
+	// encountered while retrieving the credentials.
+	ErrFunc func(error) `json:"-"`
+}
+
+// StartCredentialsRotation periodically retrieves credentials from the
+// rotation's provider and sets them on the client, so that rotated keys
+// are picked up without restarting. Invoke the returned function to stop it.
+func (c *Client) StartCredentialsRotation(cr *CredentialsRotation) (cancel func() error, err error) {
+	if cr == nil || cr.Provider == nil {
+		return nil, errNilCredentialsProvider
+	}
+	interval := cr.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	canceler, cancelFn := makeCanceler()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-canceler:
+				return
+			}
+
+			creds, err := cr.Provider.Credentials()
+			if err == nil {
+				c.SetCredentials(creds)
+			} else if cr.ErrFunc != nil {
+				cr.ErrFunc(err)
+			}
+		}
+	}()
+
+	return cancelFn, nil
+}
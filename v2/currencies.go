@@ -14,6 +14,11 @@
 
 package coinbase
 
+import (
+	"errors"
+	"fmt"
+)
+
 type Currency string
 
 const (
@@ -24,3 +29,20 @@ const (
 	ETH Currency = "ETH"
 	USD Currency = "USD"
 )
+
+var errInvalidCurrency = errors.New("expecting a currency code of 2 to 10 uppercase letters or digits")
+
+// Validate checks that c looks like a currency code
+// e.g. "BTC", "USD" or "ETH". It doesn't check that the
+// currency is actually known to the exchange.
+func (c Currency) Validate() error {
+	if n := len(c); n < 2 || n > 10 {
+		return fmt.Errorf("%v, got %q", errInvalidCurrency, c)
+	}
+	for _, r := range c {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return fmt.Errorf("%v, got %q", errInvalidCurrency, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CallInfo describes a single API call made by the client.
+type CallInfo struct {
+	// Name is the name of the Client method that made
+	// the call e.g. "ListAccounts" or "CandleSticks".
+	Name string `json:"name"`
+
+	HTTPMethod string `json:"http_method"`
+
+	// Endpoint is the URL of the call without its query.
+	Endpoint string `json:"endpoint"`
+
+	AuthKind AuthKind `json:"auth_kind"`
+
+	// Attempt is the 1-based attempt number of the call, which
+	// is sent again while the exchange answers 429 Too Many Requests.
+	Attempt int `json:"attempt"`
+
+	// Header is a copy of the request's headers with
+	// signatures, passphrases and tokens redacted.
+	Header http.Header `json:"header,omitempty"`
+}
+
+// CallResult describes the outcome of a call.
+type CallResult struct {
+	// StatusCode is 0 if no response was received.
+	StatusCode int           `json:"status_code,omitempty"`
+	Latency    time.Duration `json:"latency"`
+
+	// Err is the error, if any, that the call failed with.
+	// Errors returned by the API are of type *APIError.
+	Err error `json:"-"`
+}
+
+// CallHook is invoked before every API call. The function that it
+// returns, if non-nil, is invoked with the call's result once it completes.
+// Hooks can be used for logging, metrics or tracing for example:
+//
+//	client.AddCallHooks(func(ci *coinbase.CallInfo) func(*coinbase.CallResult) {
+//		span := startSpan(ci.Name)
+//		return func(cr *coinbase.CallResult) {
+//			span.End(cr.StatusCode, cr.Err)
+//		}
+//	})
+type CallHook func(ci *CallInfo) func(cr *CallResult)
+
+// AddCallHooks registers hooks that will be
+// invoked, in order, for every API call.
+func (c *Client) AddCallHooks(hooks ...CallHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, hook := range hooks {
+		if hook != nil {
+			c.callHooks = append(c.callHooks, hook)
+		}
+	}
+}
+
+func (c *Client) getCallHooks() []CallHook {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.callHooks
+}
+
+func endpoint(req *http.Request) string {
+	u := *req.URL
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+const redacted = "REDACTED"
+
+var redactedHeaders = []string{
+	hdrSignatureKey,
+	hdrPassphraseKey,
+	"Authorization",
+}
+
+func redactHeader(hdr http.Header) http.Header {
+	clone := make(http.Header, len(hdr))
+	for key, values := range hdr {
+		clone[key] = append([]string(nil), values...)
+	}
+	for _, key := range redactedHeaders {
+		if clone.Get(key) != "" {
+			clone.Set(key, redacted)
+		}
+	}
+	return clone
+}
+
+// APIError is returned for calls that the API responded to with a
+// non-2XX status. It holds the error decoded from the response body.
+type APIError struct {
+	StatusCode int    `json:"status_code"`
+	Status     string `json:"status"`
+
+	// ID and Message are decoded from either Coinbase's
+	// {"errors": [{"id": ..., "message": ...}]} or
+	// GDAX's {"message": ...} error responses.
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Body is the raw response body.
+	Body []byte `json:"-"`
+}
+
+func (ae *APIError) Error() string {
+	switch {
+	case ae.Message != "" && ae.ID != "":
+		return fmt.Sprintf("%s: %s", ae.ID, ae.Message)
+	case ae.Message != "":
+		return ae.Message
+	case len(ae.Body) > 3:
+		return string(ae.Body)
+	default:
+		return ae.Status
+	}
+}
+
+type errorResponse struct {
+	Errors  []*apiError `json:"errors"`
+	Message string      `json:"message"`
+}
+
+func newAPIError(res *http.Response, body []byte) *APIError {
+	ae := &APIError{StatusCode: res.StatusCode, Status: res.Status, Body: body}
+	eres := new(errorResponse)
+	if err := json.Unmarshal(body, eres); err != nil {
+		return ae
+	}
+	var msgs []string
+	for _, apiErr := range eres.Errors {
+		if apiErr == nil {
+			continue
+		}
+		if ae.ID == "" {
+			ae.ID = apiErr.ID
+		}
+		msgs = append(msgs, apiErr.Message)
+	}
+	if len(msgs) == 0 && eres.Message != "" {
+		msgs = append(msgs, eres.Message)
+	}
+	ae.Message = strings.Join(msgs, "\n")
+	return ae
+}
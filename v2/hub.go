@@ -0,0 +1,462 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/orijtech/wsu"
+)
+
+// Channel is a channel of the websocket feed, which
+// decides the types of messages sent for a product.
+type Channel string
+
+const (
+	// ChannelFull sends every change to the orders of the book.
+	ChannelFull Channel = "full"
+
+	// ChannelUser is ChannelFull for your own orders only.
+	ChannelUser Channel = "user"
+
+	ChannelMatches   Channel = "matches"
+	ChannelTicker    Channel = "ticker"
+	ChannelHeartbeat Channel = "heartbeat"
+	ChannelLevel2    Channel = "level2"
+)
+
+var channelTypes = map[Channel][]Type{
+	ChannelFull:      {TypeReceived, TypeOpen, TypeDone, TypeMatch, TypeChange, TypeActivate},
+	ChannelUser:      {TypeReceived, TypeOpen, TypeDone, TypeMatch, TypeChange, TypeActivate},
+	ChannelMatches:   {TypeMatch, TypeLastMatch},
+	ChannelTicker:    {TypeTicker},
+	ChannelHeartbeat: {TypeHeartbeat},
+	ChannelLevel2:    {TypeSnapshot, TypeL2Update},
+}
+
+var (
+	errInvalidChannel     = errors.New("invalid channel: expecting full, user, matches, ticker, heartbeat or level2")
+	errUnauthorizedUser   = errors.New("the user channel requires an authenticated hub")
+	errHubClosed          = errors.New("the hub is closed")
+	errBlankHubProductIDs = errors.New("expecting non-blank product ids")
+)
+
+func (ch Channel) Validate() error {
+	if _, ok := channelTypes[ch]; !ok {
+		return errInvalidChannel
+	}
+	return nil
+}
+
+type HubConfig struct {
+	// Authenticate if set signs the hub's subscriptions,
+	// which the user channel and your own fields require.
+	Authenticate bool `json:"authenticate,omitempty"`
+
+	// KeepRawFrames and TypedOnly are applied to
+	// every message as they are by Subscription.
+	KeepRawFrames bool `json:"keep_raw_frames,omitempty"`
+	TypedOnly     bool `json:"typed_only,omitempty"`
+}
+
+// Hub shares a single connection to the websocket feed among its
+// subscribers, each getting only the messages that they asked for.
+// The exchange is only subscribed to what at least one subscriber
+// wants, and unsubscribed from as soon as none does. The connection
+// is made by the first subscription and if it drops, every subscription
+// ends, after its error, leaving the next subscription to reconnect.
+//
+// Messages that the exchange sends on several of the channels, such as
+// matches on both the full and matches channels, are delivered once.
+// Messages are shared between subscribers so they must not be modified.
+type Hub struct {
+	client *Client
+	cfg    HubConfig
+
+	mu        sync.Mutex
+	conn      FeedConn
+	consumers map[*hubConsumer]bool
+	refs      map[hubKey]int
+	closed    bool
+}
+
+type hubKey struct {
+	productID string
+	channel   Channel
+}
+
+// NewHub returns a hub whose connection is made by the first subscription.
+func (c *Client) NewHub(hc *HubConfig) (*Hub, error) {
+	if hc == nil {
+		hc = new(HubConfig)
+	}
+	if hc.Authenticate && c.getTokenSource() != nil {
+		return nil, errOAuth2Subscription
+	}
+	return &Hub{
+		client:    c,
+		cfg:       *hc,
+		consumers: make(map[*hubConsumer]bool),
+		refs:      make(map[hubKey]int),
+	}, nil
+}
+
+type HubSubscription struct {
+	// Products are the product ids e.g. "BTC-USD",
+	// by default the same as for Subscription.
+	Products []string `json:"products,omitempty"`
+
+	// Channels are ChannelFull by default.
+	Channels []Channel `json:"channels,omitempty"`
+
+	// Types if set further narrows the messages down
+	// to those types among the ones of the channels.
+	Types []Type `json:"types,omitempty"`
+
+	// BufferSize and Backpressure are as for Subscription, except that
+	// waiting for the consumer, as BackpressureBlock does and as
+	// BackpressureCoalesce does for the messages it doesn't coalesce,
+	// stops the feed for every subscriber.
+	BufferSize   int                `json:"buffer_size,omitempty"`
+	Backpressure BackpressurePolicy `json:"backpressure,omitempty"`
+}
+
+// Subscribe returns the messages of the products and channels of hs,
+// along with errors of the connection and those sent by the exchange.
+// Closing the returned subscription leaves the others running.
+func (h *Hub) Subscribe(hs *HubSubscription) (*SubscriptionResponse, error) {
+	if hs == nil {
+		hs = new(HubSubscription)
+	}
+	if err := hs.Backpressure.Validate(); err != nil {
+		return nil, err
+	}
+	if hs.BufferSize < 0 {
+		return nil, errNegativeBufferSize
+	}
+	products := hs.Products
+	if len(products) == 0 {
+		products = defaultProductIDs[:]
+	}
+	channels := hs.Channels
+	if len(channels) == 0 {
+		channels = []Channel{ChannelFull}
+	}
+
+	hc := &hubConsumer{
+		products: make(map[string]bool),
+		types:    make(map[Type]Channel),
+		in:       make(chan *Message),
+		done:     make(chan bool),
+	}
+	for _, productID := range products {
+		if productID == "" {
+			return nil, errBlankHubProductIDs
+		}
+		hc.products[productID] = true
+	}
+	for _, ch := range channels {
+		if err := ch.Validate(); err != nil {
+			return nil, err
+		}
+		if ch == ChannelUser && !h.cfg.Authenticate {
+			return nil, errUnauthorizedUser
+		}
+		for _, typ := range channelTypes[ch] {
+			// Public channels take precedence over the user channel.
+			if hc.types[typ] == "" || hc.types[typ] == ChannelUser {
+				hc.types[typ] = ch
+			}
+		}
+	}
+	if len(hs.Types) > 0 {
+		wanted := make(map[Type]bool)
+		for _, typ := range hs.Types {
+			wanted[typ] = true
+		}
+		for typ := range hc.types {
+			if !wanted[typ] {
+				delete(hc.types, typ)
+			}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errHubClosed
+	}
+	if h.conn == nil {
+		conn, err := h.client.dialFeed()
+		if err != nil {
+			return nil, err
+		}
+		h.conn = conn
+		go h.read(conn)
+	}
+
+	// Only what no one else is subscribed to yet is sent.
+	var wanted []hubKey
+	for _, ch := range channels {
+		for _, productID := range products {
+			key := hubKey{productID: productID, channel: ch}
+			hc.keys = append(hc.keys, key)
+			if h.refs[key] += 1; h.refs[key] == 1 {
+				wanted = append(wanted, key)
+			}
+		}
+	}
+	if err := h.sendAll("subscribe", wanted); err != nil {
+		h.release(hc.keys)
+		return nil, err
+	}
+	h.consumers[hc] = true
+
+	sres := &SubscriptionResponse{
+		MessagesChan: hc.in,
+		cancelFn:     func() error { return h.remove(hc) },
+	}
+	return BufferMessages(sres, hs.BufferSize, hs.Backpressure)
+}
+
+// send must be invoked with h.mu held.
+func (h *Hub) send(typ string, ch Channel, productIDs []string) error {
+	sm := &subscribeMessage{
+		Type:       typ,
+		ProductIDs: productIDs,
+		Channels:   []Channel{ch},
+	}
+	if h.cfg.Authenticate {
+		if err := h.client.signSubscription(sm); err != nil {
+			return err
+		}
+	}
+	blob, err := json.Marshal(sm)
+	if err != nil {
+		return err
+	}
+	h.conn.Send(&wsu.Message{Frame: blob})
+	return nil
+}
+
+// sendAll must be invoked with h.mu held. It sends
+// a message per channel, with the channel's products.
+func (h *Hub) sendAll(typ string, keys []hubKey) error {
+	var channels []Channel
+	productIDs := make(map[Channel][]string)
+	for _, key := range keys {
+		if len(productIDs[key.channel]) == 0 {
+			channels = append(channels, key.channel)
+		}
+		productIDs[key.channel] = append(productIDs[key.channel], key.productID)
+	}
+	for _, ch := range channels {
+		if err := h.send(typ, ch, productIDs[ch]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release must be invoked with h.mu held. It
+// returns the keys that no one wants anymore.
+func (h *Hub) release(keys []hubKey) []hubKey {
+	var unwanted []hubKey
+	for _, key := range keys {
+		if h.refs[key] -= 1; h.refs[key] <= 0 {
+			delete(h.refs, key)
+			unwanted = append(unwanted, key)
+		}
+	}
+	return unwanted
+}
+
+func (h *Hub) remove(hc *hubConsumer) error {
+	hc.end()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.consumers[hc] || h.closed {
+		return nil
+	}
+	delete(h.consumers, hc)
+	return h.sendAll("unsubscribe", h.release(hc.keys))
+}
+
+func (h *Hub) read(conn FeedConn) {
+	seen := newRecentFrames(recentFramesSize)
+	for {
+		recvMsg, ok := conn.Receive()
+		if !ok {
+			break
+		}
+		msg := decodeFrame(recvMsg, h.cfg.KeepRawFrames, h.cfg.TypedOnly)
+		// The exchange sends a message once per channel that it
+		// belongs to e.g. matches of both the full and matches
+		// channels, yet every consumer must only get it once.
+		if msg.Err == nil && msg.SequenceNumber > 0 && seen.add(frameKey{msg.ProductID, msg.Type, msg.SequenceNumber}) {
+			continue
+		}
+
+		h.mu.Lock()
+		consumers := make([]*hubConsumer, 0, len(h.consumers))
+		for hc := range h.consumers {
+			consumers = append(consumers, hc)
+		}
+		h.mu.Unlock()
+
+		for _, hc := range consumers {
+			if hc.accepts(msg) {
+				hc.send(msg)
+			}
+		}
+	}
+
+	h.mu.Lock()
+	var consumers map[*hubConsumer]bool
+	if h.conn == conn {
+		h.conn = nil
+		consumers = h.consumers
+		h.consumers = make(map[*hubConsumer]bool)
+		h.refs = make(map[hubKey]int)
+	}
+	h.mu.Unlock()
+
+	for hc := range consumers {
+		hc.end()
+	}
+}
+
+// recentFramesSize is how many frames are remembered
+// to drop the copies that other channels send of them.
+const recentFramesSize = 1024
+
+type frameKey struct {
+	productID string
+	typ       Type
+	sequence  int
+}
+
+// recentFrames remembers the last frames of a connection.
+type recentFrames struct {
+	keys map[frameKey]bool
+	ring []frameKey
+	next int
+}
+
+func newRecentFrames(size int) *recentFrames {
+	return &recentFrames{keys: make(map[frameKey]bool), ring: make([]frameKey, size)}
+}
+
+// add reports whether key was already seen, remembering it if not.
+func (rf *recentFrames) add(key frameKey) bool {
+	if rf.keys[key] {
+		return true
+	}
+	delete(rf.keys, rf.ring[rf.next])
+	rf.ring[rf.next] = key
+	rf.next = (rf.next + 1) % len(rf.ring)
+	rf.keys[key] = true
+	return false
+}
+
+// Close ends every subscription and the connection.
+// Subscribing afterwards returns an error.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	if h.conn == nil {
+		return nil
+	}
+	return h.conn.Close()
+}
+
+type hubConsumer struct {
+	products map[string]bool
+
+	// types maps the types of messages wanted to their channels.
+	types map[Type]Channel
+	keys  []hubKey
+
+	// mu guards sending on in against it being closed.
+	mu      sync.Mutex
+	in      chan *Message
+	done    chan bool
+	endOnce sync.Once
+}
+
+func (hc *hubConsumer) accepts(msg *Message) bool {
+	if msg.Err != nil || msg.Type == TypeError {
+		return true
+	}
+	if !hc.products[msg.ProductID] {
+		return false
+	}
+	ch, ok := hc.types[msg.Type]
+	if !ok {
+		return false
+	}
+	return ch != ChannelUser || isOwnMessage(msg)
+}
+
+func (hc *hubConsumer) send(msg *Message) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	select {
+	case <-hc.done:
+	default:
+		select {
+		case hc.in <- msg:
+		case <-hc.done:
+		}
+	}
+}
+
+// end stops the consumer's messages, unblocking a pending send.
+func (hc *hubConsumer) end() {
+	hc.endOnce.Do(func() {
+		close(hc.done)
+		hc.mu.Lock()
+		close(hc.in)
+		hc.mu.Unlock()
+	})
+}
+
+// isOwnMessage reports whether msg, of the full
+// channel, is about one of your own orders.
+func isOwnMessage(msg *Message) bool {
+	switch fm := msg.Payload.(type) {
+	case *Received:
+		return fm.UserID != ""
+	case *Open:
+		return fm.UserID != ""
+	case *Done:
+		return fm.UserID != ""
+	case *Match:
+		return fm.UserID != "" || fm.TakerUserID != ""
+	case *Change:
+		return fm.UserID != ""
+	case *Activate:
+		return fm.UserID != ""
+	}
+	return msg.UserID != "" || msg.TakerUserID != ""
+}
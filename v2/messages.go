@@ -0,0 +1,272 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Reference: https://docs.gdax.com/#channels
+
+// FeedMessage is implemented by the typed messages of the websocket
+// feed, which are told apart with a type switch, for example:
+//
+//	switch m := msg.Payload.(type) {
+//	case *coinbase.Match:
+//		fmt.Println("traded", m.Size, "at", m.Price)
+//	case *coinbase.Done:
+//		fmt.Println(m.OrderID, "is done:", m.Reason)
+//	}
+type FeedMessage interface {
+	// Header returns the fields common to all messages.
+	Header() *MessageHeader
+}
+
+type MessageHeader struct {
+	Type      Type      `json:"type"`
+	Time      time.Time `json:"time,omitempty"`
+	ProductID string    `json:"product_id,omitempty"`
+	Sequence  int64     `json:"sequence,omitempty"`
+}
+
+func (mh *MessageHeader) Header() *MessageHeader { return mh }
+
+// Received is sent when the matching engine accepts an order.
+type Received struct {
+	MessageHeader
+	OrderID   string  `json:"order_id"`
+	OrderType string  `json:"order_type,omitempty"`
+	Size      float64 `json:"size,string,omitempty"`
+	Price     float64 `json:"price,string,omitempty"`
+	Funds     float64 `json:"funds,string,omitempty"`
+	Side      Side    `json:"side,omitempty"`
+
+	// ClientOID is the CustomOrderID that the order was placed with.
+	ClientOID string `json:"client_oid,omitempty"`
+
+	// These fields are only set for your own orders.
+	UserID    string `json:"user_id,omitempty"`
+	ProfileID string `json:"profile_id,omitempty"`
+}
+
+// Open is sent when the remainder of a limit order rests on the book.
+type Open struct {
+	MessageHeader
+	OrderID       string  `json:"order_id"`
+	Price         float64 `json:"price,string,omitempty"`
+	RemainingSize float64 `json:"remaining_size,string,omitempty"`
+	Side          Side    `json:"side,omitempty"`
+
+	UserID    string `json:"user_id,omitempty"`
+	ProfileID string `json:"profile_id,omitempty"`
+}
+
+// Done is sent when an order is off the book, for good.
+type Done struct {
+	MessageHeader
+	OrderID       string  `json:"order_id"`
+	Price         float64 `json:"price,string,omitempty"`
+	RemainingSize float64 `json:"remaining_size,string,omitempty"`
+	Side          Side    `json:"side,omitempty"`
+	Reason        Reason  `json:"reason,omitempty"`
+
+	UserID    string `json:"user_id,omitempty"`
+	ProfileID string `json:"profile_id,omitempty"`
+}
+
+// Match is a trade between a taker and a resting maker order.
+type Match struct {
+	MessageHeader
+	TradeID      int64   `json:"trade_id,omitempty"`
+	MakerOrderID string  `json:"maker_order_id"`
+	TakerOrderID string  `json:"taker_order_id"`
+	Size         float64 `json:"size,string,omitempty"`
+	Price        float64 `json:"price,string,omitempty"`
+
+	// Side is the side of the maker order.
+	Side Side `json:"side,omitempty"`
+
+	// These fields are only set for your own orders.
+	UserID         string `json:"user_id,omitempty"`
+	ProfileID      string `json:"profile_id,omitempty"`
+	TakerUserID    string `json:"taker_user_id,omitempty"`
+	TakerProfileID string `json:"taker_profile_id,omitempty"`
+}
+
+// Change is sent when an order shrinks e.g. due to self trade prevention.
+type Change struct {
+	MessageHeader
+	OrderID  string  `json:"order_id"`
+	NewSize  float64 `json:"new_size,string,omitempty"`
+	OldSize  float64 `json:"old_size,string,omitempty"`
+	NewFunds float64 `json:"new_funds,string,omitempty"`
+	OldFunds float64 `json:"old_funds,string,omitempty"`
+	Price    float64 `json:"price,string,omitempty"`
+	Side     Side    `json:"side,omitempty"`
+
+	UserID    string `json:"user_id,omitempty"`
+	ProfileID string `json:"profile_id,omitempty"`
+}
+
+// Activate is sent when a stop order is triggered.
+type Activate struct {
+	MessageHeader
+	OrderID      string  `json:"order_id"`
+	StopType     Type    `json:"stop_type,omitempty"`
+	Side         Side    `json:"side,omitempty"`
+	StopPrice    float64 `json:"stop_price,string,omitempty"`
+	Size         float64 `json:"size,string,omitempty"`
+	Funds        float64 `json:"funds,string,omitempty"`
+	TakerFeeRate float64 `json:"taker_fee_rate,string,omitempty"`
+	Private      bool    `json:"private,omitempty"`
+
+	// Timestamp is the activation time, in seconds since the epoch.
+	Timestamp string `json:"timestamp,omitempty"`
+
+	UserID    string `json:"user_id,omitempty"`
+	ProfileID string `json:"profile_id,omitempty"`
+}
+
+// FeedTicker is sent on every trade. It is named so as not
+// to clash with the Ticker retrieved by Client.Ticker.
+type FeedTicker struct {
+	MessageHeader
+	TradeID   int64   `json:"trade_id,omitempty"`
+	Price     float64 `json:"price,string,omitempty"`
+	Side      Side    `json:"side,omitempty"`
+	LastSize  float64 `json:"last_size,string,omitempty"`
+	BestBid   float64 `json:"best_bid,string,omitempty"`
+	BestAsk   float64 `json:"best_ask,string,omitempty"`
+	Open24h   float64 `json:"open_24h,string,omitempty"`
+	Volume24h float64 `json:"volume_24h,string,omitempty"`
+	Low24h    float64 `json:"low_24h,string,omitempty"`
+	High24h   float64 `json:"high_24h,string,omitempty"`
+	Volume30d float64 `json:"volume_30d,string,omitempty"`
+}
+
+// L2Update is a batch of changes to the aggregated levels of the book.
+type L2Update struct {
+	MessageHeader
+	Changes []*L2Change `json:"changes,omitempty"`
+}
+
+// L2Change is the new total size at a price, where
+// a zero size means that the level is now empty.
+type L2Change struct {
+	Side  Side    `json:"side"`
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+var errInvalidL2ChangeOriginalJSON = errors.New("expecting data of the form: [side, price, size]")
+
+// UnmarshalJSON decodes changes sent by the exchange as [side, price, size].
+func (lc *L2Change) UnmarshalJSON(b []byte) error {
+	var recv []string
+	if err := json.Unmarshal(b, &recv); err != nil {
+		return err
+	}
+	if len(recv) != 3 {
+		return errInvalidL2ChangeOriginalJSON
+	}
+	var err error
+	lc.Side = Side(recv[0])
+	if lc.Price, err = strconv.ParseFloat(recv[1], 64); err != nil {
+		return err
+	}
+	lc.Size, err = strconv.ParseFloat(recv[2], 64)
+	return err
+}
+
+// Heartbeat is sent every second, per product,
+// to show that the connection is alive.
+type Heartbeat struct {
+	MessageHeader
+	LastTradeID int64 `json:"last_trade_id,omitempty"`
+}
+
+// FeedError is sent when the exchange rejects e.g. a subscription.
+type FeedError struct {
+	MessageHeader
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (fe *FeedError) Error() string {
+	if fe.Reason == "" {
+		return fe.Message
+	}
+	return fe.Message + ": " + fe.Reason
+}
+
+// UnknownMessage is a message of a type that isn't decoded further.
+type UnknownMessage struct {
+	MessageHeader
+}
+
+var (
+	_ FeedMessage = (*Received)(nil)
+	_ FeedMessage = (*Open)(nil)
+	_ FeedMessage = (*Done)(nil)
+	_ FeedMessage = (*Match)(nil)
+	_ FeedMessage = (*Change)(nil)
+	_ FeedMessage = (*Activate)(nil)
+	_ FeedMessage = (*FeedTicker)(nil)
+	_ FeedMessage = (*L2Update)(nil)
+	_ FeedMessage = (*Heartbeat)(nil)
+	_ FeedMessage = (*FeedError)(nil)
+	_ FeedMessage = (*UnknownMessage)(nil)
+)
+
+// ParseFeedMessage decodes a frame of the websocket feed into its
+// typed message. Frames of unknown types decode to an *UnknownMessage.
+func ParseFeedMessage(frame []byte) (FeedMessage, error) {
+	header := new(MessageHeader)
+	if err := json.Unmarshal(frame, header); err != nil {
+		return nil, err
+	}
+	var fm FeedMessage
+	switch header.Type {
+	case TypeReceived:
+		fm = new(Received)
+	case TypeOpen:
+		fm = new(Open)
+	case TypeDone:
+		fm = new(Done)
+	case TypeMatch, TypeLastMatch:
+		fm = new(Match)
+	case TypeChange:
+		fm = new(Change)
+	case TypeActivate:
+		fm = new(Activate)
+	case TypeTicker:
+		fm = new(FeedTicker)
+	case TypeL2Update:
+		fm = new(L2Update)
+	case TypeHeartbeat:
+		fm = new(Heartbeat)
+	case TypeError:
+		fm = new(FeedError)
+	default:
+		return &UnknownMessage{MessageHeader: *header}, nil
+	}
+	if err := json.Unmarshal(frame, fm); err != nil {
+		return nil, err
+	}
+	return fm, nil
+}
@@ -0,0 +1,385 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reference: https://developers.coinbase.com/docs/wallet/coinbase-connect
+
+const (
+	oauth2AuthorizeURL = "https://www.coinbase.com/oauth/authorize"
+	oauth2TokenURL     = "https://api.coinbase.com/oauth/token"
+)
+
+type Scope string
+
+const (
+	ScopeUserRead          Scope = "wallet:user:read"
+	ScopeUserEmail         Scope = "wallet:user:email"
+	ScopeUserUpdate        Scope = "wallet:user:update"
+	ScopeAccountsRead      Scope = "wallet:accounts:read"
+	ScopeAccountsCreate    Scope = "wallet:accounts:create"
+	ScopeAccountsUpdate    Scope = "wallet:accounts:update"
+	ScopeAccountsDelete    Scope = "wallet:accounts:delete"
+	ScopeAddressesRead     Scope = "wallet:addresses:read"
+	ScopeAddressesCreate   Scope = "wallet:addresses:create"
+	ScopeTransactionsRead  Scope = "wallet:transactions:read"
+	ScopeTransactionsSend  Scope = "wallet:transactions:send"
+	ScopeBuysRead          Scope = "wallet:buys:read"
+	ScopeBuysCreate        Scope = "wallet:buys:create"
+	ScopeSellsRead         Scope = "wallet:sells:read"
+	ScopeSellsCreate       Scope = "wallet:sells:create"
+	ScopePaymentMethodRead Scope = "wallet:payment-methods:read"
+)
+
+// Token is an OAuth2 token that is used in place
+// of an API key to make requests on behalf of a user.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+
+	// Scopes are the permissions that the user
+	// granted. If empty, they are unknown and no
+	// client side scope checks are performed.
+	Scopes []Scope `json:"scopes,omitempty"`
+}
+
+// expiryDelta is how much earlier than its expiry that a
+// token is considered expired, to account for the latency
+// between checking and using the token.
+const expiryDelta = 10 * time.Second
+
+// Valid reports whether the token has an access token that hasn't expired.
+func (t *Token) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(expiryDelta).Before(t.Expiry)
+}
+
+// HasScope reports whether the token was granted scope. A token
+// without any known scopes is assumed to have every scope.
+func (t *Token) HasScope(scope Scope) bool {
+	if t == nil {
+		return false
+	}
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenSource supplies the tokens that a client uses to
+// authenticate. Its method set mirrors golang.org/x/oauth2.TokenSource.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+type staticTokenSource struct {
+	tok *Token
+}
+
+func (sts *staticTokenSource) Token() (*Token, error) {
+	return sts.tok, nil
+}
+
+// StaticTokenSource returns a TokenSource that always
+// returns tok and that never refreshes it.
+func StaticTokenSource(tok *Token) TokenSource {
+	return &staticTokenSource{tok: tok}
+}
+
+type OAuth2Config struct {
+	ClientID     string  `json:"client_id"`
+	ClientSecret string  `json:"client_secret"`
+	RedirectURL  string  `json:"redirect_uri"`
+	Scopes       []Scope `json:"scopes"`
+
+	// OnRefresh if set is invoked with every token
+	// obtained by refreshing an expired token, so that
+	// it can be persisted e.g. to a database.
+	OnRefresh func(*Token) `json:"-"`
+
+	// RoundTripper if set is used for the token requests.
+	RoundTripper http.RoundTripper `json:"-"`
+}
+
+var (
+	errNilOAuth2Config = errors.New("expecting a non-nil OAuth2Config")
+
+	errBlankAuthCode = errors.New("expecting a non-blank authorization code")
+
+	errBlankRefreshToken = errors.New("expecting a non-blank refresh token")
+
+	errNilTokenSource = errors.New("expecting a non-nil TokenSource")
+
+	errInvalidToken = errors.New("the token source returned an invalid token")
+
+	errOAuth2Exchange = errors.New("the exchange's orders and fills require API key credentials, not OAuth2")
+)
+
+// AuthCodeURL returns the URL that users should be redirected
+// to, to grant the application the configured scopes.
+// state is returned as is to the redirect URL and should be
+// checked there to protect against cross-site request forgery.
+func (oc *OAuth2Config) AuthCodeURL(state string) string {
+	qv := make(url.Values)
+	qv.Set("response_type", "code")
+	qv.Set("client_id", oc.ClientID)
+	if oc.RedirectURL != "" {
+		qv.Set("redirect_uri", oc.RedirectURL)
+	}
+	if state != "" {
+		qv.Set("state", state)
+	}
+	if len(oc.Scopes) > 0 {
+		scopes := make([]string, len(oc.Scopes))
+		for i, scope := range oc.Scopes {
+			scopes[i] = string(scope)
+		}
+		qv.Set("scope", strings.Join(scopes, ","))
+	}
+	return fmt.Sprintf("%s?%s", oauth2AuthorizeURL, qv.Encode())
+}
+
+// Exchange converts the authorization code that was sent
+// to the redirect URL into a token.
+func (oc *OAuth2Config) Exchange(code string) (*Token, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, errBlankAuthCode
+	}
+	qv := make(url.Values)
+	qv.Set("grant_type", "authorization_code")
+	qv.Set("code", code)
+	qv.Set("redirect_uri", oc.RedirectURL)
+	return oc.retrieveToken(qv)
+}
+
+// Refresh obtains a new token using refreshToken.
+func (oc *OAuth2Config) Refresh(refreshToken string) (*Token, error) {
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return nil, errBlankRefreshToken
+	}
+	qv := make(url.Values)
+	qv.Set("grant_type", "refresh_token")
+	qv.Set("refresh_token", refreshToken)
+	tok, err := oc.retrieveToken(qv)
+	if err != nil {
+		return nil, err
+	}
+	// The refresh token is only sent back if it was rotated.
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok, nil
+}
+
+type tokenJSON struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+}
+
+func (oc *OAuth2Config) retrieveToken(qv url.Values) (*Token, error) {
+	qv.Set("client_id", oc.ClientID)
+	qv.Set("client_secret", oc.ClientSecret)
+	req, err := http.NewRequest("POST", oauth2TokenURL, strings.NewReader(qv.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &Client{rt: oc.RoundTripper}
+	blob, _, err := client.doHTTPReq("OAuth2Token", req)
+	if err != nil {
+		return nil, err
+	}
+	tj := new(tokenJSON)
+	if err := json.Unmarshal(blob, tj); err != nil {
+		return nil, err
+	}
+	if tj.AccessToken == "" {
+		return nil, errInvalidToken
+	}
+	tok := &Token{
+		AccessToken:  tj.AccessToken,
+		TokenType:    tj.TokenType,
+		RefreshToken: tj.RefreshToken,
+	}
+	if tj.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tj.ExpiresIn) * time.Second)
+	}
+	for _, scope := range strings.FieldsFunc(tj.Scope, isScopeSeparator) {
+		tok.Scopes = append(tok.Scopes, Scope(scope))
+	}
+	return tok, nil
+}
+
+func isScopeSeparator(r rune) bool {
+	return r == ' ' || r == ','
+}
+
+// TokenSource returns a TokenSource that returns tok until
+// it expires, after which it is refreshed using the config.
+func (oc *OAuth2Config) TokenSource(tok *Token) TokenSource {
+	return &refreshingTokenSource{config: oc, tok: tok}
+}
+
+type refreshingTokenSource struct {
+	config *OAuth2Config
+
+	mu  sync.Mutex
+	tok *Token
+}
+
+func (rts *refreshingTokenSource) Token() (*Token, error) {
+	rts.mu.Lock()
+	defer rts.mu.Unlock()
+
+	if rts.tok.Valid() {
+		return rts.tok, nil
+	}
+	var refreshToken string
+	if rts.tok != nil {
+		refreshToken = rts.tok.RefreshToken
+	}
+	tok, err := rts.config.Refresh(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	rts.tok = tok
+	if fn := rts.config.OnRefresh; fn != nil {
+		fn(tok)
+	}
+	return tok, nil
+}
+
+// NewOAuth2Client creates a client that authenticates with
+// the tokens from ts instead of with an API key and secret.
+//
+// The exchange only accepts API keys, so that OAuth2 clients are
+// limited to the wallet methods e.g. MyProfile, ListAccounts and
+// CreateAddress and to the public market data. Order, CancelOrder,
+// FindOrderByID, ListOrders, OrderFills and with them the order
+// tracker fail up front, as do authenticated feed subscriptions.
+func NewOAuth2Client(ts TokenSource) (*Client, error) {
+	if ts == nil {
+		return nil, errNilTokenSource
+	}
+	return &Client{tokenSource: ts}, nil
+}
+
+// SetTokenSource switches the client to OAuth2 authentication
+// using the tokens from ts. Passing in nil switches the client
+// back to authenticating with its API key credentials.
+func (c *Client) SetTokenSource(ts TokenSource) {
+	c.mu.Lock()
+	c.tokenSource = ts
+	c.mu.Unlock()
+}
+
+func (c *Client) getTokenSource() TokenSource {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tokenSource
+}
+
+type errMissingScope Scope
+
+func (ems errMissingScope) Error() string {
+	return fmt.Sprintf("the OAuth2 token wasn't granted scope %q", string(ems))
+}
+
+func (c *Client) setBearerToken(req *http.Request, ts TokenSource) error {
+	tok, err := ts.Token()
+	if err != nil {
+		return err
+	}
+	if !tok.Valid() {
+		return errInvalidToken
+	}
+	if scope := requiredScope(req); scope != "" && !tok.HasScope(scope) {
+		return errMissingScope(scope)
+	}
+	tokenType := tok.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set(hdrVersionKey, apiVersion)
+	req.Header.Set("Authorization", fmt.Sprintf("%s %s", tokenType, tok.AccessToken))
+	return nil
+}
+
+// requiredScope returns the scope needed for the wallet
+// API request or "" if it isn't known to require one.
+func requiredScope(req *http.Request) Scope {
+	splits := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(splits) == 0 || splits[0] != "v2" {
+		return ""
+	}
+	splits = splits[1:]
+	if len(splits) == 0 {
+		return ""
+	}
+
+	switch splits[0] {
+	case "user", "users":
+		if req.Method == "GET" {
+			return ScopeUserRead
+		}
+		return ScopeUserUpdate
+	case "accounts":
+		if len(splits) >= 3 && splits[2] == "addresses" {
+			if req.Method == "POST" {
+				return ScopeAddressesCreate
+			}
+			return ScopeAddressesRead
+		}
+		switch req.Method {
+		case "GET":
+			return ScopeAccountsRead
+		case "POST":
+			if len(splits) == 1 {
+				return ScopeAccountsCreate
+			}
+			return ScopeAccountsUpdate
+		case "PUT":
+			return ScopeAccountsUpdate
+		case "DELETE":
+			return ScopeAccountsDelete
+		}
+	}
+	return ""
+}
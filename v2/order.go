@@ -15,11 +15,12 @@
 package coinbase
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -221,6 +222,9 @@ type OrderResponse struct {
 	ExecutedValue float64   `json:"executed_value,string,omitempty"`
 	Status        Status    `json:"status,omitempty"`
 	Settled       bool      `json:"settled,omitempty"`
+	FilledSize    float64   `json:"filled_size,string,omitempty"`
+	DoneAt        time.Time `json:"done_at,omitempty"`
+	DoneReason    Reason    `json:"done_reason,omitempty"`
 }
 
 type Status string
@@ -286,15 +290,11 @@ func (c *Client) Order(o *Order) (*OrderResponse, error) {
 	if err := o.Validate(); err != nil {
 		return nil, err
 	}
-	blob, err := json.Marshal(o)
+	req, err := newRequest("POST", ordersURL, o)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", ordersURL, bytes.NewReader(blob))
-	if err != nil {
-		return nil, err
-	}
-	blob, _, err = c.doAuthAndReq(req)
+	blob, _, err := c.doAuthAndReq("Order", req)
 	if err != nil {
 		return nil, err
 	}
@@ -319,6 +319,209 @@ func (c *Client) CancelOrder(serverAssignedOrderID string) error {
 	if err != nil {
 		return err
 	}
-	_, _, err = c.doAuthAndReq(req)
+	_, _, err = c.doAuthAndReq("CancelOrder", req)
 	return err
 }
+
+// FindOrderByID retrieves an order by its server assigned ID.
+// Like ListOrders, it may not find orders that were canceled
+// without ever being matched.
+func (c *Client) FindOrderByID(serverAssignedOrderID string) (*OrderResponse, error) {
+	// A blank ID would get the list of orders instead.
+	serverAssignedOrderID = strings.TrimSpace(serverAssignedOrderID)
+	if serverAssignedOrderID == "" {
+		return nil, errBlankOrderID
+	}
+	fullURL := fmt.Sprintf("%s/%s", ordersURL, serverAssignedOrderID)
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := c.doAuthAndReq("FindOrderByID", req)
+	if err != nil {
+		return nil, err
+	}
+	ores := new(OrderResponse)
+	if err := json.Unmarshal(blob, ores); err != nil {
+		return nil, err
+	}
+	return ores, nil
+}
+
+type OrdersRequest struct {
+	// Product if set only lists the orders of that product.
+	Product string `json:"product_id,omitempty"`
+
+	// Statuses filters the orders by status: "open", "pending",
+	// "active", "done" or "all". The exchange lists the
+	// open and pending orders if none are set.
+	Statuses []string `json:"status,omitempty"`
+
+	OrdersPerPage int64 `json:"orders_per_page,omitempty"`
+	MaxPage       int64 `json:"max_page,omitempty"`
+
+	ThrottleDurationMs int64 `json:"throttle_duration_ms"`
+}
+
+type OrdersPage struct {
+	Orders     []*OrderResponse `json:"orders,omitempty"`
+	PageNumber int64            `json:"page_number,omitempty"`
+
+	Err error `json:"error,omitempty"`
+}
+
+type OrdersResponse struct {
+	Cancel    func() error
+	PagesChan chan *OrdersPage
+}
+
+// maxOrdersPerPage is the exchange's limit of orders per request.
+const maxOrdersPerPage = 100
+
+var errInvalidOrdersPerPage = errors.New("expecting orders per page of at most 100")
+
+func (oreq *OrdersRequest) Validate() error {
+	if oreq.OrdersPerPage > maxOrdersPerPage {
+		return errInvalidOrdersPerPage
+	}
+	return nil
+}
+
+// ListOrders pages through your orders, newest first,
+// following the exchange's CB-AFTER cursor.
+func (c *Client) ListOrders(oreq *OrdersRequest) (*OrdersResponse, error) {
+	if oreq == nil {
+		oreq = new(OrdersRequest)
+	}
+	if err := oreq.Validate(); err != nil {
+		return nil, err
+	}
+	limit := oreq.OrdersPerPage
+	if limit <= 0 {
+		limit = maxOrdersPerPage
+	}
+
+	pagesChan := make(chan *OrdersPage)
+	cancelChan, cancelFn := makeCanceler()
+	go func() {
+		defer close(pagesChan)
+
+		var throttleDuration time.Duration
+		if oreq.ThrottleDurationMs != NoThrottle && oreq.ThrottleDurationMs > 0 {
+			throttleDuration = time.Duration(oreq.ThrottleDurationMs) * time.Millisecond
+		}
+
+		qv := make(url.Values)
+		qv.Set("limit", fmt.Sprintf("%d", limit))
+		if oreq.Product != "" {
+			qv.Set("product_id", oreq.Product)
+		}
+		for _, status := range oreq.Statuses {
+			qv.Add("status", status)
+		}
+
+		for pageNumber := int64(1); ; pageNumber++ {
+			page := &OrdersPage{PageNumber: pageNumber}
+			req, err := http.NewRequest("GET", ordersURL+"?"+qv.Encode(), nil)
+			if err != nil {
+				page.Err = err
+				pagesChan <- page
+				return
+			}
+			blob, hdr, err := c.doAuthAndReq("ListOrders", req)
+			if err == nil {
+				err = json.Unmarshal(blob, &page.Orders)
+			}
+			if err != nil {
+				page.Err = err
+				pagesChan <- page
+				return
+			}
+
+			// A full last page is followed by an empty one,
+			// which is only worth sending if it is the first.
+			if len(page.Orders) == 0 && pageNumber > 1 {
+				return
+			}
+			select {
+			case pagesChan <- page:
+			case <-cancelChan:
+				return
+			}
+
+			after := hdr.Get("CB-AFTER")
+			if int64(len(page.Orders)) < limit || after == "" || (oreq.MaxPage > 0 && pageNumber >= oreq.MaxPage) {
+				return
+			}
+			qv.Set("after", after)
+
+			select {
+			case <-time.After(throttleDuration):
+			case <-cancelChan:
+				return
+			}
+		}
+	}()
+
+	ores := &OrdersResponse{
+		Cancel:    cancelFn,
+		PagesChan: pagesChan,
+	}
+
+	return ores, nil
+}
+
+// Fill is a match that an order was a part of.
+type Fill struct {
+	TradeID   int64   `json:"trade_id"`
+	ProductID string  `json:"product_id,omitempty"`
+	OrderID   string  `json:"order_id,omitempty"`
+	Price     float64 `json:"price,string,omitempty"`
+	Size      float64 `json:"size,string,omitempty"`
+	Fee       float64 `json:"fee,string,omitempty"`
+	Side      Side    `json:"side,omitempty"`
+
+	// Liquidity is "M" if the order was the maker
+	// of the match and "T" if it was the taker.
+	Liquidity string    `json:"liquidity,omitempty"`
+	Settled   bool      `json:"settled,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+const fillsURL = "https://api.gdax.com/fills"
+
+var errBlankOrderID = errors.New("expecting a non-blank order ID")
+
+// OrderFills returns all the fills of the order, newest first.
+func (c *Client) OrderFills(serverAssignedOrderID string) ([]*Fill, error) {
+	serverAssignedOrderID = strings.TrimSpace(serverAssignedOrderID)
+	if serverAssignedOrderID == "" {
+		return nil, errBlankOrderID
+	}
+	qv := make(url.Values)
+	qv.Set("order_id", serverAssignedOrderID)
+	qv.Set("limit", fmt.Sprintf("%d", maxOrdersPerPage))
+
+	var fills []*Fill
+	for {
+		req, err := http.NewRequest("GET", fillsURL+"?"+qv.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		blob, hdr, err := c.doAuthAndReq("OrderFills", req)
+		if err != nil {
+			return nil, err
+		}
+		var page []*Fill
+		if err := json.Unmarshal(blob, &page); err != nil {
+			return nil, err
+		}
+		fills = append(fills, page...)
+
+		after := hdr.Get("CB-AFTER")
+		if len(page) < maxOrdersPerPage || after == "" {
+			return fills, nil
+		}
+		qv.Set("after", after)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type Product struct {
+	ID             string  `json:"id,omitempty"`
+	BaseCurrency   string  `json:"base_currency,omitempty"`
+	QuoteCurrency  string  `json:"quote_currency,omitempty"`
+	BaseMinSize    float64 `json:"base_min_size,omitempty"`
+	BaseMaxSize    float64 `json:"base_max_size,omitempty"`
+	QuoteIncrement float64 `json:"quote_increment,omitempty"`
+	DisplayName    string  `json:"display_name,omitempty"`
+	Status         string  `json:"status,omitempty"`
+}
+
+type rawProduct struct {
+	ID             string  `json:"id,omitempty"`
+	BaseCurrency   string  `json:"base_currency,omitempty"`
+	QuoteCurrency  string  `json:"quote_currency,omitempty"`
+	BaseMinSize    float64 `json:"base_min_size,string,omitempty"`
+	BaseMaxSize    float64 `json:"base_max_size,string,omitempty"`
+	QuoteIncrement float64 `json:"quote_increment,string,omitempty"`
+	DisplayName    string  `json:"display_name,omitempty"`
+	Status         string  `json:"status,omitempty"`
+}
+
+// Products lists the products that are traded on the exchange.
+func (c *Client) Products() ([]*Product, error) {
+	req, err := http.NewRequest("GET", "https://api.gdax.com/products", nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := c.doPublicReq("Products", req)
+	if err != nil {
+		return nil, err
+	}
+	var rproducts []*rawProduct
+	if err := json.Unmarshal(blob, &rproducts); err != nil {
+		return nil, err
+	}
+	products := make([]*Product, 0, len(rproducts))
+	for _, rp := range rproducts {
+		products = append(products, (*Product)(rp))
+	}
+	return products, nil
+}
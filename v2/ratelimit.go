@@ -0,0 +1,99 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// publicRequestsPerSecond is the exchange's rate limit for public
+// endpoints, which allows bursts of up to twice as many requests.
+const publicRequestsPerSecond = 3
+
+// rateLimiter lets through requestsPerSecond requests
+// on average, with bursts of up to burst requests.
+type rateLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu sync.Mutex
+	// tat is the time at which the bucket will be full again.
+	tat time.Time
+}
+
+func newRateLimiter(requestsPerSecond, burst int) *rateLimiter {
+	return &rateLimiter{interval: time.Second / time.Duration(requestsPerSecond), burst: burst}
+}
+
+// reserve takes a request from the bucket, returning
+// how long to wait for before making the request.
+func (rl *rateLimiter) reserve(now time.Time) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.tat.Before(now) {
+		rl.tat = now
+	}
+	wait := rl.tat.Sub(now) - time.Duration(rl.burst-1)*rl.interval
+	rl.tat = rl.tat.Add(rl.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (rl *rateLimiter) wait() {
+	if d := rl.reserve(time.Now()); d > 0 {
+		<-time.After(d)
+	}
+}
+
+// SetPublicRateLimit sets the rate of requests to the exchange's public
+// endpoints e.g. Ticker, Stats, CandleSticks and ListTrades, which is
+// shared by all the concurrent calls of the client. It defaults to the
+// exchange's limit of 3 requests per second, with bursts of up to twice
+// as many. A rate of zero or less disables the limit.
+func (c *Client) SetPublicRateLimit(requestsPerSecond int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.publicLimitSet = true
+	c.publicLimiter = nil
+	if requestsPerSecond > 0 {
+		c.publicLimiter = newRateLimiter(requestsPerSecond, 2*requestsPerSecond)
+	}
+}
+
+func (c *Client) getPublicLimiter() *rateLimiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.publicLimitSet {
+		c.publicLimitSet = true
+		c.publicLimiter = newRateLimiter(publicRequestsPerSecond, 2*publicRequestsPerSecond)
+	}
+	return c.publicLimiter
+}
+
+// doPublicReq makes an unauthenticated request to
+// one of the exchange's rate limited public endpoints.
+func (c *Client) doPublicReq(name string, req *http.Request) ([]byte, http.Header, error) {
+	if rl := c.getPublicLimiter(); rl != nil {
+		rl.wait()
+	}
+	return c.doHTTPReq(name, req)
+}
@@ -17,6 +17,7 @@ package coinbase
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -41,36 +42,106 @@ func (v *Value) UnmarshalJSON(b []byte) error {
 type ExchangeRateResponse struct {
 	From  Currency           `json:"from"`
 	Rates map[Currency]Value `json:"rates"`
+
+	// Missing lists the requested secondary currencies
+	// for which the API returned no rate. They are
+	// deliberately left out of Rates so that a zero
+	// Value always means that the rate is zero.
+	Missing []Currency `json:"missing,omitempty"`
+}
+
+// RatePair is a single From->To exchange rate.
+type RatePair struct {
+	From  Currency `json:"from"`
+	To    Currency `json:"to"`
+	Value Value    `json:"value"`
+}
+
+// RatePair returns the rate of From to the currency "to"
+// and reports whether that rate was available at all.
+func (erp *ExchangeRateResponse) RatePair(to Currency) (*RatePair, bool) {
+	if erp == nil {
+		return nil, false
+	}
+	value, ok := erp.Rates[to]
+	if !ok {
+		return nil, false
+	}
+	return &RatePair{From: erp.From, To: to, Value: value}, true
+}
+
+type apiError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
 }
 
 type exchangeRateResponseWrap struct {
-	Data *ExchangeRateResponse `json:"data"`
+	Data   *ExchangeRateResponse `json:"data"`
+	Errors []*apiError           `json:"errors"`
+}
+
+var (
+	errNoExchangeRateData = errors.New("expecting exchange rate data in the response")
+
+	errDuplicateCurrency = errors.New("duplicate currency in request")
+)
+
+func apiErrorsToError(apiErrs []*apiError) error {
+	var msgs []string
+	for _, apiErr := range apiErrs {
+		if apiErr == nil {
+			continue
+		}
+		if apiErr.ID != "" {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", apiErr.ID, apiErr.Message))
+		} else {
+			msgs = append(msgs, apiErr.Message)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "\n"))
 }
 
 // From can either be of the form:
 // * PRIMARY --> ETH
 // * PRIMARY-SECONDARY --> BTC-USD
 // * PRIMARY-SECONDARY1-SECONDARY2-SECONDARY3... --> LTC-USD-ETH-BTC
-// Where the last two forms prune out any pairs that aren't the secondaries
+// Where the last two forms prune out any pairs that aren't the secondaries.
+// Any secondaries that the API doesn't have a rate for are reported
+// in the response's Missing field instead of in Rates.
 func (c *Client) ExchangeRate(from Currency) (*ExchangeRateResponse, error) {
 	// Exchange Rate reference https://developers.coinbase.com/api/v2#exchange-rates
 	// is unauthenticated.
 	// GDAX exchange rates are of the form "<PRIMARY_CURRENCY>"
 	// If the user has requested "<PRIMARY_CURRENCY>-<SECONDARY_CURRENCY>"
 	// That means that they are only interested in the primary-secondary rate.
-	primary := string(from)
-	var secondaries []string
-	splits := strings.Split(primary, "-")
-	if len(splits) > 0 {
-		primary = splits[0]
-		if len(splits) > 1 {
-			secondaries = splits[1:]
+	var primary Currency
+	var secondaries []Currency
+	if from != "" {
+		splits := strings.Split(string(from), "-")
+		seen := make(map[Currency]bool)
+		for i, split := range splits {
+			curr := Currency(strings.ToUpper(strings.TrimSpace(split)))
+			if err := curr.Validate(); err != nil {
+				return nil, err
+			}
+			if seen[curr] {
+				return nil, fmt.Errorf("%v: %q", errDuplicateCurrency, curr)
+			}
+			seen[curr] = true
+			if i == 0 {
+				primary = curr
+			} else {
+				secondaries = append(secondaries, curr)
+			}
 		}
 	}
 	fullURL := fmt.Sprintf("%s/exchange-rates", baseURL)
-	if from != "" {
+	if primary != "" {
 		qv := make(url.Values)
-		qv.Set("currency", primary)
+		qv.Set("currency", string(primary))
 		fullURL = fmt.Sprintf("%s?%s", fullURL, qv.Encode())
 	}
 
@@ -79,7 +150,7 @@ func (c *Client) ExchangeRate(from Currency) (*ExchangeRateResponse, error) {
 		return nil, err
 	}
 
-	blob, _, err := c.doHTTPReq(req)
+	blob, _, err := c.doHTTPReq("ExchangeRate", req)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +159,15 @@ func (c *Client) ExchangeRate(from Currency) (*ExchangeRateResponse, error) {
 	if err := json.Unmarshal(blob, cwrap); err != nil {
 		return nil, err
 	}
-	cwrap.Data.From = Currency(primary)
+	if err := apiErrorsToError(cwrap.Errors); err != nil {
+		return nil, err
+	}
+	if cwrap.Data == nil {
+		return nil, errNoExchangeRateData
+	}
+	if primary != "" {
+		cwrap.Data.From = primary
+	}
 	if len(secondaries) == 0 {
 		return cwrap.Data, nil
 	}
@@ -97,8 +176,12 @@ func (c *Client) ExchangeRate(from Currency) (*ExchangeRateResponse, error) {
 	data := cwrap.Data.Rates
 	prunedRates := make(map[Currency]Value)
 	for _, secondary := range secondaries {
-		secCurr := Currency(secondary)
-		prunedRates[secCurr] = data[secCurr]
+		value, ok := data[secondary]
+		if !ok {
+			cwrap.Data.Missing = append(cwrap.Data.Missing, secondary)
+			continue
+		}
+		prunedRates[secondary] = value
 	}
 	cwrap.Data.Rates = prunedRates
 	return cwrap.Data, nil
@@ -0,0 +1,387 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+)
+
+// FeedFrame is a frame of the websocket feed and when it was received.
+type FeedFrame struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Frame      json.RawMessage `json:"frame,omitempty"`
+
+	// Err is set instead of Frame if receiving failed.
+	Err string `json:"err,omitempty"`
+}
+
+const feedFileExt = ".jsonl.gz"
+
+// FeedRecorder writes the frames of the websocket feed to gzip
+// compressed files in Dir, of a JSON encoded FeedFrame per line,
+// starting a new file once either limit of the current one is hit.
+// Files are named after the time of their first frame so that
+// their names sort in the order that they were recorded.
+type FeedRecorder struct {
+	Dir string
+
+	// Prefix is the prefix of the file names, "feed" by default.
+	Prefix string
+
+	// MaxBytes if set is the most uncompressed bytes written per file.
+	MaxBytes int64
+
+	// MaxDuration if set is the longest time span of the frames in a file.
+	MaxDuration time.Duration
+
+	// FlushInterval is how often frames are flushed to the file so
+	// that a crash loses at most that much, 1s by default. If negative,
+	// every frame is flushed.
+	FlushInterval time.Duration
+
+	mu        sync.Mutex
+	f         *os.File
+	gzw       *gzip.Writer
+	written   int64
+	firstAt   time.Time
+	lastFlush time.Time
+}
+
+// Record records the message's RawFrame, so the subscription
+// must have set KeepRawFrames, or its error if it has no frame.
+func (fr *FeedRecorder) Record(msg *coinbase.Message) error {
+	ff := &FeedFrame{ReceivedAt: msg.ReceivedAt, Frame: msg.RawFrame}
+	if len(ff.Frame) == 0 && msg.Err != nil {
+		ff.Err = msg.Err.Error()
+	}
+	if ff.ReceivedAt.IsZero() {
+		ff.ReceivedAt = time.Now()
+	}
+	return fr.WriteFrame(ff)
+}
+
+func (fr *FeedRecorder) WriteFrame(ff *FeedFrame) error {
+	if strings.TrimSpace(fr.Dir) == "" {
+		return errBlankDir
+	}
+	blob, err := json.Marshal(ff)
+	if err != nil {
+		return err
+	}
+	blob = append(blob, '\n')
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if fr.f != nil && fr.full(ff.ReceivedAt) {
+		if err := fr.closeFile(); err != nil {
+			return err
+		}
+	}
+	if fr.f == nil {
+		if err := fr.openFile(ff.ReceivedAt); err != nil {
+			return err
+		}
+	}
+	n, err := fr.gzw.Write(blob)
+	fr.written += int64(n)
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); fr.FlushInterval < 0 || now.Sub(fr.lastFlush) >= fr.flushInterval() {
+		fr.lastFlush = now
+		return fr.gzw.Flush()
+	}
+	return nil
+}
+
+func (fr *FeedRecorder) flushInterval() time.Duration {
+	if fr.FlushInterval == 0 {
+		return time.Second
+	}
+	return fr.FlushInterval
+}
+
+// full must be invoked with fr.mu held.
+func (fr *FeedRecorder) full(receivedAt time.Time) bool {
+	if fr.MaxBytes > 0 && fr.written >= fr.MaxBytes {
+		return true
+	}
+	return fr.MaxDuration > 0 && receivedAt.Sub(fr.firstAt) >= fr.MaxDuration
+}
+
+// openFile must be invoked with fr.mu held.
+func (fr *FeedRecorder) openFile(firstAt time.Time) error {
+	if err := os.MkdirAll(fr.Dir, 0755); err != nil {
+		return err
+	}
+	prefix := fr.Prefix
+	if prefix == "" {
+		prefix = "feed"
+	}
+	name := fmt.Sprintf("%s-%s", prefix, firstAt.UTC().Format("20060102T150405.000000000Z"))
+	for i := 0; ; i++ {
+		path := filepath.Join(fr.Dir, name+feedFileExt)
+		if i > 0 {
+			path = filepath.Join(fr.Dir, fmt.Sprintf("%s-%d%s", name, i, feedFileExt))
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fr.f, fr.gzw = f, gzip.NewWriter(f)
+		fr.written, fr.firstAt, fr.lastFlush = 0, firstAt, time.Now()
+		return nil
+	}
+}
+
+// closeFile must be invoked with fr.mu held.
+func (fr *FeedRecorder) closeFile() error {
+	gzErr := fr.gzw.Close()
+	err := fr.f.Close()
+	fr.f, fr.gzw = nil, nil
+	if gzErr != nil {
+		return gzErr
+	}
+	return err
+}
+
+// Close finishes the current file. Recording
+// afterwards starts a new file.
+func (fr *FeedRecorder) Close() error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.f == nil {
+		return nil
+	}
+	return fr.closeFile()
+}
+
+// FeedFiles expands paths, of recordings or of directories
+// holding them, into the recordings in the order to be played.
+func FeedFiles(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*"+feedFileExt))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// FeedReader reads the frames of recordings in order.
+type FeedReader struct {
+	files []string
+
+	f       *os.File
+	scanner *bufio.Scanner
+}
+
+// maxFrameSize is the largest frame that can be read back.
+const maxFrameSize = 16 * 1024 * 1024
+
+// NewFeedReader reads the recordings at paths, as expanded by FeedFiles.
+func NewFeedReader(paths ...string) (*FeedReader, error) {
+	files, err := FeedFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+	return &FeedReader{files: files}, nil
+}
+
+// Next returns the next frame, or io.EOF after the last one.
+// The incomplete end of a recording that was cut off e.g.
+// by a crash is skipped, along with any frame it held.
+func (fr *FeedReader) Next() (*FeedFrame, error) {
+	for {
+		if fr.scanner == nil {
+			if len(fr.files) == 0 {
+				return nil, io.EOF
+			}
+			path := fr.files[0]
+			fr.files = fr.files[1:]
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			gzr, err := gzip.NewReader(f)
+			if err != nil {
+				f.Close()
+				if err == io.EOF {
+					// An empty file that was never written to.
+					continue
+				}
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			fr.f, fr.scanner = f, bufio.NewScanner(gzr)
+			fr.scanner.Buffer(make([]byte, 64*1024), maxFrameSize)
+		}
+
+		if fr.scanner.Scan() {
+			line := fr.scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			ff := new(FeedFrame)
+			if err := json.Unmarshal(line, ff); err != nil {
+				// Only the last line of a cut off recording can be partial.
+				if fr.scanner.Scan() {
+					return nil, fmt.Errorf("%s: %v", fr.f.Name(), err)
+				}
+				fr.nextFile()
+				continue
+			}
+			return ff, nil
+		}
+		err := fr.scanner.Err()
+		path := fr.f.Name()
+		fr.nextFile()
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func (fr *FeedReader) nextFile() {
+	fr.f.Close()
+	fr.f, fr.scanner = nil, nil
+}
+
+// Close closes the recording being read.
+func (fr *FeedReader) Close() error {
+	if fr.f == nil {
+		return nil
+	}
+	err := fr.f.Close()
+	fr.f, fr.scanner, fr.files = nil, nil, nil
+	return err
+}
+
+type FeedPlayback struct {
+	// Paths are the recordings, or directories of them, to play.
+	Paths []string
+
+	// Speed is how many times faster than originally received that
+	// messages are sent, with 1 being the original pace. If zero or
+	// negative, messages are sent as fast as they are consumed.
+	Speed float64
+}
+
+var errNoRecordings = errors.New("replay: no feed recordings found")
+
+// PlayFeed sends the recorded frames, decoded like the frames of
+// Client.Subscribe, through the returned subscription's MessagesChan
+// which is closed after the last message or once Close is invoked.
+func PlayFeed(fp *FeedPlayback) (*coinbase.SubscriptionResponse, error) {
+	if fp == nil {
+		fp = new(FeedPlayback)
+	}
+	fr, err := NewFeedReader(fp.Paths...)
+	if err != nil {
+		return nil, err
+	}
+	if len(fr.files) == 0 {
+		return nil, errNoRecordings
+	}
+
+	msgsChan := make(chan *coinbase.Message)
+	done := make(chan bool)
+	var closeOnce sync.Once
+	closeFn := func() error {
+		closeOnce.Do(func() { close(done) })
+		return nil
+	}
+
+	go func() {
+		defer close(msgsChan)
+		defer fr.Close()
+
+		var firstAt, startedAt time.Time
+		for {
+			ff, err := fr.Next()
+			if err == io.EOF {
+				return
+			}
+			msg := new(coinbase.Message)
+			if err != nil {
+				msg.Err = err
+			} else if ff.Err != "" {
+				msg.Err = errors.New(ff.Err)
+			} else {
+				parsed, err := coinbase.ParseMessage(ff.Frame)
+				msg = parsed
+				msg.Err = err
+			}
+
+			if ff != nil {
+				msg.ReceivedAt = ff.ReceivedAt
+				if fp.Speed > 0 {
+					if firstAt.IsZero() {
+						firstAt, startedAt = ff.ReceivedAt, time.Now()
+					}
+					// Scheduling off the first frame keeps delays from adding up.
+					due := startedAt.Add(time.Duration(float64(ff.ReceivedAt.Sub(firstAt)) / fp.Speed))
+					if wait := time.Until(due); wait > 0 {
+						timer := time.NewTimer(wait)
+						select {
+						case <-timer.C:
+						case <-done:
+							timer.Stop()
+							return
+						}
+					}
+				}
+			}
+
+			select {
+			case msgsChan <- msg:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return coinbase.NewSubscriptionResponse(msgsChan, closeFn), nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/replay"
+)
+
+var feedEpoch = time.Date(2017, 9, 22, 0, 40, 0, 0, time.UTC)
+
+// matchMessages returns n messages as kept by a subscription
+// with KeepRawFrames, received every interval from feedEpoch.
+func matchMessages(n int, interval time.Duration) []*coinbase.Message {
+	var msgs []*coinbase.Message
+	for i := 0; i < n; i++ {
+		frame := fmt.Sprintf(`{"type":"match","product_id":"BTC-USD","sequence":%d,"price":"%d.5","size":"0.1","side":"sell"}`, i+1, 4000+i)
+		msgs = append(msgs, &coinbase.Message{
+			ReceivedAt: feedEpoch.Add(time.Duration(i) * interval),
+			RawFrame:   []byte(frame),
+		})
+	}
+	return msgs
+}
+
+func playAll(t *testing.T, fp *replay.FeedPlayback) []*coinbase.Message {
+	sub, err := replay.PlayFeed(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	var msgs []*coinbase.Message
+	for msg := range sub.MessagesChan {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestFeedRecordAndPlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rec := &replay.FeedRecorder{Dir: dir, MaxBytes: 400}
+	msgs := matchMessages(10, 100*time.Millisecond)
+	msgs = append(msgs, &coinbase.Message{ReceivedAt: feedEpoch.Add(time.Second), Err: errors.New("connection reset")})
+	for _, msg := range msgs {
+		if err := rec.Record(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := replay.FeedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 3 {
+		t.Fatalf("expected the recording to be rotated across several files, got %q", files)
+	}
+	if g, w := filepath.Base(files[0]), "feed-20170922T004000.000000000Z.jsonl.gz"; g != w {
+		t.Errorf("first file: got=%q want=%q", g, w)
+	}
+
+	played := playAll(t, &replay.FeedPlayback{Paths: []string{dir}})
+	if g, w := len(played), len(msgs); g != w {
+		t.Fatalf("messages: got=%d want=%d", g, w)
+	}
+	for i, msg := range played[:10] {
+		if msg.Err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, msg.Err)
+			continue
+		}
+		if g, w := msg.SequenceNumber, i+1; g != w {
+			t.Errorf("#%d: sequence: got=%d want=%d", i, g, w)
+		}
+		if g, w := msg.Price, float64(4000+i)+0.5; g != w {
+			t.Errorf("#%d: price: got=%v want=%v", i, g, w)
+		}
+		if g, w := msg.ReceivedAt, msgs[i].ReceivedAt; !g.Equal(w) {
+			t.Errorf("#%d: received at: got=%v want=%v", i, g, w)
+		}
+	}
+	if last := played[10]; last.Err == nil || last.Err.Error() != "connection reset" {
+		t.Errorf("expected the recorded error, got %v", last.Err)
+	}
+}
+
+func TestFeedPlayMalformedFrame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rec := &replay.FeedRecorder{Dir: dir}
+	msgs := []*coinbase.Message{
+		{ReceivedAt: feedEpoch, RawFrame: []byte(`{"type":"match","size":"notanumber"}`)},
+	}
+	msgs = append(msgs, matchMessages(1, time.Second)...)
+	for _, msg := range msgs {
+		if err := rec.Record(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec.Close()
+
+	played := playAll(t, &replay.FeedPlayback{Paths: []string{dir}})
+	if g, w := len(played), 2; g != w {
+		t.Fatalf("messages: got=%d want=%d", g, w)
+	}
+	if played[0].Err == nil {
+		t.Errorf("expected the decoding error, got type=%q payload=%v", played[0].Type, played[0].Payload)
+	}
+	if played[1].Err != nil {
+		t.Errorf("unexpected error: %v", played[1].Err)
+	}
+}
+
+func TestFeedPlaybackSpeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The frames span a second, so a twentyfold replay takes 50ms.
+	rec := &replay.FeedRecorder{Dir: dir}
+	for _, msg := range matchMessages(11, 100*time.Millisecond) {
+		if err := rec.Record(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec.Close()
+
+	start := time.Now()
+	played := playAll(t, &replay.FeedPlayback{Paths: []string{dir}, Speed: 20})
+	elapsed := time.Since(start)
+	if len(played) != 11 {
+		t.Fatalf("messages: got=%d want=11", len(played))
+	}
+	if elapsed < 45*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Errorf("elapsed: got=%v want about 50ms", elapsed)
+	}
+
+	// Closing stops the playback early.
+	sub, err := replay.PlayFeed(&replay.FeedPlayback{Paths: []string{dir}, Speed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.MessagesChan
+	sub.Close()
+	select {
+	case _, ok := <-sub.MessagesChan:
+		if ok {
+			// At most a message that was already due.
+			if _, ok := <-sub.MessagesChan; ok {
+				t.Error("expected the playback to stop once closed")
+			}
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("expected the messages channel to be closed")
+	}
+}
+
+func TestFeedCutOffRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The recorder is never closed, as if the process had crashed.
+	rec := &replay.FeedRecorder{Dir: dir, FlushInterval: -1}
+	for _, msg := range matchMessages(3, time.Second) {
+		if err := rec.Record(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fr, err := replay.NewFeedReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	n := 0
+	for {
+		_, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("frames: got=%d want=3", n)
+	}
+
+	if _, err := replay.PlayFeed(&replay.FeedPlayback{Paths: []string{filepath.Join(dir, "empty")}}); err == nil {
+		t.Error("expected an error for a missing recording")
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay provides http.RoundTrippers that record a client's
+// exchanges with the API into fixture files and then replay them, so
+// that code built on coinbase.Client can be tested without the network:
+//
+//	// Record once, against the real API.
+//	client.SetHTTPRoundTripper(&replay.Recorder{Dir: "./testdata/fixtures"})
+//
+//	// Then in tests, replay the recorded fixtures.
+//	client.SetHTTPRoundTripper(&replay.Replayer{Dir: "./testdata/fixtures"})
+//
+// Signatures, API keys, passphrases and OAuth2 secrets are
+// scrubbed from the fixtures before they are written.
+//
+// It also records the frames of the websocket feed with a FeedRecorder,
+// and plays them back with PlayFeed through a coinbase.SubscriptionResponse
+// like that of Client.Subscribe, at their original pace or faster.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Exchange is a recorded request and its response.
+type Exchange struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// Query is the normalized query of the request.
+	Query string `json:"query,omitempty"`
+
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	RequestBody   string      `json:"request_body,omitempty"`
+
+	StatusCode     int         `json:"status_code"`
+	Status         string      `json:"status"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+}
+
+const scrubbed = "SCRUBBED"
+
+// scrubbedHeaders are always scrubbed from recorded requests.
+var scrubbedHeaders = []string{
+	"CB-ACCESS-KEY",
+	"CB-ACCESS-SIGN",
+	"CB-ACCESS-PASSPHRASE",
+	"CB-ACCESS-TIMESTAMP",
+	"Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// scrubbedFields are always scrubbed from the form and JSON
+// bodies of requests, wherever they are nested in the latter.
+var scrubbedFields = map[string]bool{
+	"client_secret": true,
+	"code":          true,
+	"access_token":  true,
+	"refresh_token": true,
+	"passphrase":    true,
+	"key":           true,
+	"signature":     true,
+}
+
+// scrubbedResponseFields are scrubbed from response bodies, where
+// only OAuth2 token responses are expected to hold secrets, as
+// fields like code are otherwise legitimate e.g. currency codes.
+var scrubbedResponseFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// normalizeQuery sorts the query's keys and values,
+// leaving out any keys in ignore.
+func normalizeQuery(rawQuery string, ignore []string) string {
+	qv, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	for _, key := range ignore {
+		qv.Del(key)
+	}
+	for _, values := range qv {
+		sort.Strings(values)
+	}
+	// Encode sorts by key.
+	return qv.Encode()
+}
+
+// fixtureName returns the name of the file that holds the
+// exchanges for requests with the method, path and query.
+func fixtureName(method, path, query string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.Trim(path, "/"))
+	if len(sanitized) > 100 {
+		sanitized = sanitized[:100]
+	}
+	sum := sha256.Sum256([]byte(method + " " + path + "?" + query))
+	return fmt.Sprintf("%s-%s-%x.json", strings.ToUpper(method), sanitized, sum[:6])
+}
+
+func scrubHeader(hdr http.Header, extra []string) http.Header {
+	if len(hdr) == 0 {
+		return nil
+	}
+	clone := make(http.Header, len(hdr))
+	for key, values := range hdr {
+		clone[key] = append([]string(nil), values...)
+	}
+	for _, keys := range [][]string{scrubbedHeaders, extra} {
+		for _, key := range keys {
+			if clone.Get(key) != "" {
+				clone.Set(key, scrubbed)
+			}
+		}
+	}
+	return clone
+}
+
+// scrubBody scrubs fields from JSON and form encoded bodies,
+// replacing their values in place and leaving the rest as is.
+func scrubBody(body []byte, contentType string, fields map[string]bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return string(scrubJSON(body, fields))
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		pairs := strings.Split(string(body), "&")
+		for i, pair := range pairs {
+			rawKey := strings.SplitN(pair, "=", 2)[0]
+			if key, err := url.QueryUnescape(rawKey); err == nil && fields[key] {
+				pairs[i] = rawKey + "=" + scrubbed
+			}
+		}
+		return strings.Join(pairs, "&")
+	}
+	return string(body)
+}
+
+// jsonFrame is an object or array being walked by scrubJSON.
+type jsonFrame struct {
+	object  bool
+	wantKey bool
+
+	// scrubFrom if non-negative is where the value of a
+	// scrubbed field starts, for it to be replaced whole.
+	scrubFrom int64
+}
+
+// scrubJSON replaces the values of fields, at any depth, with
+// scrubbed. The body is returned as is if it isn't valid JSON.
+func scrubJSON(body []byte, fields map[string]bool) []byte {
+	type span struct{ start, end int64 }
+	var spans []span
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var stack []*jsonFrame
+	scrubNext := false
+	for {
+		prev := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return body
+		}
+		// The token starts after any whitespace and separators.
+		start := prev
+		for start < int64(len(body)) && strings.IndexByte(" \t\r\n:,", body[start]) >= 0 {
+			start++
+		}
+
+		var top *jsonFrame
+		if n := len(stack); n > 0 {
+			top = stack[n-1]
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			if top.scrubFrom >= 0 {
+				spans = append(spans, span{top.scrubFrom, dec.InputOffset()})
+			}
+			if n := len(stack); n > 0 && stack[n-1].object {
+				stack[n-1].wantKey = true
+			}
+			continue
+		}
+		if top != nil && top.object && top.wantKey {
+			key, _ := tok.(string)
+			scrubNext = fields[key]
+			top.wantKey = false
+			continue
+		}
+
+		scrub := scrubNext
+		scrubNext = false
+		if delim, ok := tok.(json.Delim); ok {
+			frame := &jsonFrame{object: delim == '{', wantKey: delim == '{', scrubFrom: -1}
+			// Fields within a scrubbed value are scrubbed along with it.
+			if scrub && !insideScrubbed(stack) {
+				frame.scrubFrom = start
+			}
+			stack = append(stack, frame)
+			continue
+		}
+		if scrub && !insideScrubbed(stack) {
+			spans = append(spans, span{start, dec.InputOffset()})
+		}
+		if top != nil && top.object {
+			top.wantKey = true
+		}
+	}
+
+	if len(spans) == 0 {
+		return body
+	}
+	var buf bytes.Buffer
+	last := int64(0)
+	for _, sp := range spans {
+		buf.Write(body[last:sp.start])
+		buf.WriteString(`"` + scrubbed + `"`)
+		last = sp.end
+	}
+	buf.Write(body[last:])
+	return buf.Bytes()
+}
+
+func insideScrubbed(stack []*jsonFrame) bool {
+	for _, frame := range stack {
+		if frame.scrubFrom >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Recorder is an http.RoundTripper that sends requests using
+// Transport and records every exchange into fixture files in Dir.
+type Recorder struct {
+	Dir string
+
+	// Transport is used to make the actual requests.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// ScrubHeaders are headers to scrub in
+	// addition to the authentication headers.
+	ScrubHeaders []string
+
+	// IgnoreQueryParams are query parameters that are
+	// left out of the normalized query e.g. nonces.
+	IgnoreQueryParams []string
+
+	// Scrub if set is invoked with every exchange before it
+	// is written, to scrub any other application specific data.
+	Scrub func(*Exchange)
+
+	mu sync.Mutex
+}
+
+var _ http.RoundTripper = (*Recorder)(nil)
+
+var errBlankDir = errors.New("expecting a non-blank fixtures directory")
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.TrimSpace(r.Dir) == "" {
+		return nil, errBlankDir
+	}
+
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if req.GetBody != nil {
+			rc, gerr := req.GetBody()
+			if gerr != nil {
+				return nil, gerr
+			}
+			reqBody, err = ioutil.ReadAll(rc)
+			rc.Close()
+		} else {
+			reqBody, err = ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resBody []byte
+	if res.Body != nil {
+		resBody, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	ex := &Exchange{
+		Method:         req.Method,
+		Path:           req.URL.Path,
+		Query:          normalizeQuery(req.URL.RawQuery, r.IgnoreQueryParams),
+		RequestHeader:  scrubHeader(req.Header, r.ScrubHeaders),
+		RequestBody:    scrubBody(reqBody, req.Header.Get("Content-Type"), scrubbedFields),
+		StatusCode:     res.StatusCode,
+		Status:         res.Status,
+		ResponseHeader: scrubHeader(res.Header, r.ScrubHeaders),
+		ResponseBody:   scrubBody(resBody, res.Header.Get("Content-Type"), scrubbedResponseFields),
+	}
+	if r.Scrub != nil {
+		r.Scrub(ex)
+	}
+	if err := r.save(ex); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// save appends the exchange to the exchanges already
+// recorded for the same method, path and query.
+func (r *Recorder) save(ex *Exchange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(r.Dir, fixtureName(ex.Method, ex.Path, ex.Query))
+	exchanges, err := readFixture(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exchanges = append(exchanges, ex)
+	blob, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, blob, 0644)
+}
+
+func readFixture(path string) ([]*Exchange, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var exchanges []*Exchange
+	if err := json.Unmarshal(blob, &exchanges); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return exchanges, nil
+}
+
+// Replayer is an http.RoundTripper that serves the exchanges
+// recorded in Dir, matching requests by their method, path and
+// normalized query. Identical requests are served the recorded
+// responses in the order that they were recorded, with the
+// last response repeated once they've all been served.
+type Replayer struct {
+	Dir string
+
+	// IgnoreQueryParams must match those used while recording.
+	IgnoreQueryParams []string
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+var _ http.RoundTripper = (*Replayer)(nil)
+
+// ErrNoExchange is returned for requests that weren't recorded.
+type ErrNoExchange struct {
+	Method string
+	Path   string
+	Query  string
+}
+
+func (ene *ErrNoExchange) Error() string {
+	if ene.Query == "" {
+		return fmt.Sprintf("replay: no recorded exchange for %s %s", ene.Method, ene.Path)
+	}
+	return fmt.Sprintf("replay: no recorded exchange for %s %s?%s", ene.Method, ene.Path, ene.Query)
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.TrimSpace(r.Dir) == "" {
+		return nil, errBlankDir
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	query := normalizeQuery(req.URL.RawQuery, r.IgnoreQueryParams)
+	name := fixtureName(req.Method, req.URL.Path, query)
+	exchanges, err := readFixture(filepath.Join(r.Dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &ErrNoExchange{Method: req.Method, Path: req.URL.Path, Query: query}
+		}
+		return nil, err
+	}
+	if len(exchanges) == 0 {
+		return nil, &ErrNoExchange{Method: req.Method, Path: req.URL.Path, Query: query}
+	}
+
+	r.mu.Lock()
+	if r.served == nil {
+		r.served = make(map[string]int)
+	}
+	i := r.served[name]
+	r.served[name] = i + 1
+	r.mu.Unlock()
+	if i >= len(exchanges) {
+		i = len(exchanges) - 1
+	}
+
+	ex := exchanges[i]
+	hdr := make(http.Header)
+	for key, values := range ex.ResponseHeader {
+		hdr[key] = append([]string(nil), values...)
+	}
+	return &http.Response{
+		Status:        ex.Status,
+		StatusCode:    ex.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        hdr,
+		Body:          ioutil.NopCloser(strings.NewReader(ex.ResponseBody)),
+		ContentLength: int64(len(ex.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// Reset makes the replayer serve every recorded exchange from the start.
+func (r *Replayer) Reset() {
+	r.mu.Lock()
+	r.served = nil
+	r.mu.Unlock()
+}
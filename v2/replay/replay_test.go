@@ -0,0 +1,255 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/orijtech/coinbase/v2"
+	"github.com/orijtech/coinbase/v2/replay"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (rtf roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rtf(req)
+}
+
+func makeResp(code int, body string) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// upstream is a stand-in for the real API.
+var upstream = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+	switch {
+	case req.URL.Path == "/v2/user":
+		if req.Header.Get("CB-ACCESS-KEY") != "key" {
+			return makeResp(http.StatusUnauthorized, `{"errors":[{"id":"authentication_error","message":"invalid api key"}]}`), nil
+		}
+		return makeResp(http.StatusOK, `{"data":{"id":"prof1","username":"odeke"}}`), nil
+	case req.URL.Path == "/products/BTC-USD/ticker":
+		return makeResp(http.StatusOK, `{"trade_id":1,"price":"4000.01","size":"0.1"}`), nil
+	case req.URL.Path == "/orders":
+		return makeResp(http.StatusOK, `{"id":"order-1","price":"100.00","size":"1.0","product_id":"BTC-USD","side":"sell"}`), nil
+	default:
+		return makeResp(http.StatusNotFound, `{"message":"NotFound"}`), nil
+	}
+})
+
+func TestRecorderScrubsBodies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coinbase-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := [...]struct {
+		path        string
+		contentType string
+		reqBody     string
+		resBody     string
+
+		wantReqBody string
+		wantResBody string
+	}{
+		0: {
+			// Secrets are scrubbed at any depth, leaving
+			// the order of keys and numbers as they were.
+			path:        "/v2/accounts",
+			contentType: "application/json",
+			reqBody:     `{"nested": {"passphrase": "pp", "amount": 12345678901234567890.123}, "list": [{"key": {"id": "k1"}}], "keep": "v"}`,
+			resBody:     `{"data":[{"code":"BTC","name":"Bitcoin","key":"k"}],"price":1234567890.123456789}`,
+			wantReqBody: `{"nested": {"passphrase": "SCRUBBED", "amount": 12345678901234567890.123}, "list": [{"key": "SCRUBBED"}], "keep": "v"}`,
+			wantResBody: `{"data":[{"code":"BTC","name":"Bitcoin","key":"k"}],"price":1234567890.123456789}`,
+		},
+		1: {
+			path:        "/oauth/token",
+			contentType: "application/x-www-form-urlencoded",
+			reqBody:     "grant_type=refresh_token&refresh_token=r1&client_secret=cs",
+			resBody:     `{"access_token":"a1","token_type":"bearer","refresh_token":"r2","expires_in":7200}`,
+			wantReqBody: "grant_type=refresh_token&refresh_token=SCRUBBED&client_secret=SCRUBBED",
+			wantResBody: `{"access_token":"SCRUBBED","token_type":"bearer","refresh_token":"SCRUBBED","expires_in":7200}`,
+		},
+	}
+
+	for i, tt := range tests {
+		rec := &replay.Recorder{Dir: dir, Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return makeResp(http.StatusOK, tt.resBody), nil
+		})}
+		req, _ := http.NewRequest("POST", "https://api.coinbase.com"+tt.path, strings.NewReader(tt.reqBody))
+		req.Header.Set("Content-Type", tt.contentType)
+		if _, err := rec.RoundTrip(req); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+
+		fixtures, _ := filepath.Glob(filepath.Join(dir, "POST-"+strings.Replace(strings.Trim(tt.path, "/"), "/", "_", -1)+"-*.json"))
+		if len(fixtures) != 1 {
+			t.Errorf("#%d: fixtures: got=%q", i, fixtures)
+			continue
+		}
+		blob, _ := ioutil.ReadFile(fixtures[0])
+		var exchanges []*replay.Exchange
+		if err := json.Unmarshal(blob, &exchanges); err != nil || len(exchanges) != 1 {
+			t.Errorf("#%d: exchanges: %v", i, err)
+			continue
+		}
+		if g, w := exchanges[0].RequestBody, tt.wantReqBody; g != w {
+			t.Errorf("#%d: request body:\ngot= %s\nwant=%s", i, g, w)
+		}
+		if g, w := exchanges[0].ResponseBody, tt.wantResBody; g != w {
+			t.Errorf("#%d: response body:\ngot= %s\nwant=%s", i, g, w)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coinbase-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	creds := &coinbase.Credentials{APIKey: "key", APISecret: "secret", Passphrase: "passphrase"}
+	recClient, _ := coinbase.NewClient(creds)
+	recClient.SetHTTPRoundTripper(&replay.Recorder{Dir: dir, Transport: upstream})
+
+	wantProfile, err := recClient.MyProfile()
+	if err != nil {
+		t.Fatalf("record profile: %v", err)
+	}
+	wantTicker, err := recClient.Ticker("BTC-USD")
+	if err != nil {
+		t.Fatalf("record ticker: %v", err)
+	}
+	wantOrder, err := recClient.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideSell, Price: 100, Size: 1})
+	if err != nil {
+		t.Fatalf("record order: %v", err)
+	}
+
+	// Ensure that no secrets made it into the fixtures.
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(fixtures), 3; g != w {
+		t.Fatalf("fixtures: got=%d want=%d", g, w)
+	}
+	for _, fixture := range fixtures {
+		blob, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exchanges []*replay.Exchange
+		if err := json.Unmarshal(blob, &exchanges); err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+		for _, ex := range exchanges {
+			for _, key := range []string{"CB-ACCESS-KEY", "CB-ACCESS-SIGN", "CB-ACCESS-PASSPHRASE"} {
+				if g, w := ex.RequestHeader.Get(key), "SCRUBBED"; g != "" && g != w {
+					t.Errorf("%s: header %q: got=%q want=%q", fixture, key, g, w)
+				}
+			}
+		}
+		if bytes.Contains(blob, []byte(creds.Passphrase)) {
+			t.Errorf("%s: found the passphrase", fixture)
+		}
+	}
+
+	// Replaying doesn't need any credentials nor network access.
+	client := new(coinbase.Client)
+	client.SetHTTPRoundTripper(&replay.Replayer{Dir: dir})
+
+	gotProfile, err := client.MyProfile()
+	if err != nil {
+		t.Fatalf("replay profile: %v", err)
+	}
+	gotTicker, err := client.Ticker("BTC-USD")
+	if err != nil {
+		t.Fatalf("replay ticker: %v", err)
+	}
+	gotOrder, err := client.Order(&coinbase.Order{Product: "BTC-USD", Side: coinbase.SideSell, Price: 100, Size: 1})
+	if err != nil {
+		t.Fatalf("replay order: %v", err)
+	}
+	for i, pair := range [][2]interface{}{
+		{gotProfile, wantProfile},
+		{gotTicker, wantTicker},
+		{gotOrder, wantOrder},
+	} {
+		g, _ := json.Marshal(pair[0])
+		w, _ := json.Marshal(pair[1])
+		if !bytes.Equal(g, w) {
+			t.Errorf("#%d: got =%s\nwant=%s", i, g, w)
+		}
+	}
+
+	// Unrecorded requests must fail loudly.
+	_, err = client.Ticker("ETH-USD")
+	if _, ok := err.(*url.Error); ok {
+		err = err.(*url.Error).Err
+	}
+	if _, ok := err.(*replay.ErrNoExchange); !ok {
+		t.Errorf("got err=%v (%T) want *replay.ErrNoExchange", err, err)
+	}
+}
+
+func TestReplaySequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coinbase-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := 0
+	counter := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		n += 1
+		return makeResp(http.StatusOK, fmt.Sprintf(`{"trade_id":%d}`, n)), nil
+	})
+
+	recClient := new(coinbase.Client)
+	recClient.SetHTTPRoundTripper(&replay.Recorder{Dir: dir, Transport: counter})
+	for i := 0; i < 2; i++ {
+		if _, err := recClient.Ticker("BTC-USD"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := new(coinbase.Client)
+	client.SetHTTPRoundTripper(&replay.Replayer{Dir: dir})
+	for i, want := range []uint64{1, 2, 2} {
+		ticker, err := client.Ticker("BTC-USD")
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if ticker.TradeID != want {
+			t.Errorf("#%d: got=%d want=%d", i, ticker.TradeID, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"net/http"
+)
+
+// Signer authenticates a request before it is sent
+// e.g. by setting its signature or authorization headers.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// SignerFunc is an adapter to allow the use of
+// ordinary functions as a Signer.
+type SignerFunc func(req *http.Request) error
+
+func (sf SignerFunc) Sign(req *http.Request) error {
+	return sf(req)
+}
+
+var _ Signer = (SignerFunc)(nil)
+
+// SetSigner makes the client authenticate requests with s.
+// Passing in nil restores the default signers that use
+// either the client's OAuth2 token source, if set,
+// or its API key credentials.
+func (c *Client) SetSigner(s Signer) {
+	c.mu.Lock()
+	c.signer = s
+	c.mu.Unlock()
+}
+
+// AuthKind is the kind of authentication used for a request.
+type AuthKind string
+
+const (
+	AuthNone   AuthKind = "none"
+	AuthAPIKey AuthKind = "api_key"
+	AuthOAuth2 AuthKind = "oauth2"
+	AuthCustom AuthKind = "custom"
+)
+
+func (c *Client) getSigner() (Signer, AuthKind) {
+	c.mu.RLock()
+	signer, ts := c.signer, c.tokenSource
+	c.mu.RUnlock()
+
+	switch {
+	case signer != nil:
+		return signer, AuthCustom
+	case ts != nil:
+		return SignerFunc(func(req *http.Request) error {
+			return c.setBearerToken(req, ts)
+		}), AuthOAuth2
+	default:
+		return SignerFunc(func(req *http.Request) error {
+			c.signAndSetHeaders(req)
+			return nil
+		}), AuthAPIKey
+	}
+}
@@ -16,10 +16,14 @@ package coinbase
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/odeke-em/semalim"
 )
 
 type Ticker struct {
@@ -52,7 +56,7 @@ func (c *Client) Ticker(productID string) (*Ticker, error) {
 	if err != nil {
 		return nil, err
 	}
-	blob, _, err := c.doHTTPReq(req)
+	blob, _, err := c.doPublicReq("Ticker", req)
 	if err != nil {
 		return nil, err
 	}
@@ -65,3 +69,107 @@ func (c *Client) Ticker(productID string) (*Ticker, error) {
 	}
 	return (*Ticker)(rtick), nil
 }
+
+type Stats struct {
+	Open        float64 `json:"open,omitempty"`
+	High        float64 `json:"high,omitempty"`
+	Low         float64 `json:"low,omitempty"`
+	Volume      float64 `json:"volume,omitempty"`
+	Last        float64 `json:"last,omitempty"`
+	Volume30Day float64 `json:"volume_30day,omitempty"`
+}
+
+type rawStats struct {
+	Open        float64 `json:"open,string,omitempty"`
+	High        float64 `json:"high,string,omitempty"`
+	Low         float64 `json:"low,string,omitempty"`
+	Volume      float64 `json:"volume,string,omitempty"`
+	Last        float64 `json:"last,string,omitempty"`
+	Volume30Day float64 `json:"volume_30day,string,omitempty"`
+}
+
+// Stats retrieves the 24 hour stats of a product.
+func (c *Client) Stats(productID string) (*Stats, error) {
+	productID = strings.TrimSpace(productID)
+	if productID == "" {
+		return nil, errBlankProduct
+	}
+	fullURL := fmt.Sprintf("https://api.gdax.com/products/%s/stats", productID)
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := c.doPublicReq("Stats", req)
+	if err != nil {
+		return nil, err
+	}
+	rstats := new(rawStats)
+	if err := json.Unmarshal(blob, rstats); err != nil {
+		return nil, err
+	}
+	return (*Stats)(rstats), nil
+}
+
+var errNoProducts = errors.New("expecting at least one product")
+
+// Tickers concurrently retrieves the tickers of products, keyed by
+// product ID, while staying under the client's public rate limit.
+// If some products fail, the tickers of the others are still
+// returned alongside an error describing each failure.
+func (c *Client) Tickers(productIDs ...string) (map[string]*Ticker, error) {
+	var uniqueIDs []string
+	seen := make(map[string]bool)
+	for _, productID := range productIDs {
+		productID = strings.TrimSpace(productID)
+		if productID == "" {
+			return nil, errBlankProduct
+		}
+		if !seen[productID] {
+			seen[productID] = true
+			uniqueIDs = append(uniqueIDs, productID)
+		}
+	}
+	if len(uniqueIDs) == 0 {
+		return nil, errNoProducts
+	}
+
+	jobsChan := make(chan semalim.Job)
+	go func() {
+		defer close(jobsChan)
+
+		for _, productID := range uniqueIDs {
+			jobsChan <- &tickerGetter{productID: productID, client: c}
+		}
+	}()
+
+	tickers := make(map[string]*Ticker)
+	var errsList []string
+	for res := range semalim.Run(jobsChan, publicRequestsPerSecond) {
+		productID := res.Id().(string)
+		if err := res.Err(); err != nil {
+			errsList = append(errsList, fmt.Sprintf("%s: %v", productID, err))
+			continue
+		}
+		tickers[productID] = res.Value().(*Ticker)
+	}
+	if len(errsList) > 0 {
+		sort.Strings(errsList)
+		return tickers, errors.New(strings.Join(errsList, "; "))
+	}
+	return tickers, nil
+}
+
+type tickerGetter struct {
+	productID string
+	client    *Client
+}
+
+var _ semalim.Job = (*tickerGetter)(nil)
+
+func (tg *tickerGetter) Id() interface{} {
+	return tg.productID
+}
+
+func (tg *tickerGetter) Do() (interface{}, error) {
+	return tg.client.Ticker(tg.productID)
+}
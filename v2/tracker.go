@@ -0,0 +1,573 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinbase
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OrderState is the stage of an order's lifecycle.
+type OrderState string
+
+const (
+	// OrderStatePending is an order that was placed
+	// but not yet accepted by the matching engine.
+	OrderStatePending         OrderState = "pending"
+	OrderStateReceived        OrderState = "received"
+	OrderStateOpen            OrderState = "open"
+	OrderStatePartiallyFilled OrderState = "partially_filled"
+	OrderStateDone            OrderState = "done"
+)
+
+// orderStateRanks orders the states so that an
+// order's state only ever moves forward.
+var orderStateRanks = map[OrderState]int{
+	OrderStatePending:         0,
+	OrderStateReceived:        1,
+	OrderStateOpen:            2,
+	OrderStatePartiallyFilled: 3,
+	OrderStateDone:            4,
+}
+
+type TrackedOrder struct {
+	// ID is the server assigned ID, which is blank
+	// until either the feed or the exchange sends it.
+	ID        string `json:"id,omitempty"`
+	ClientOID string `json:"client_oid,omitempty"`
+
+	ProductID string  `json:"product_id,omitempty"`
+	Side      Side    `json:"side,omitempty"`
+	Price     float64 `json:"price,omitempty"`
+	Size      float64 `json:"size,omitempty"`
+	Funds     float64 `json:"funds,omitempty"`
+
+	State         OrderState `json:"state,omitempty"`
+	FilledSize    float64    `json:"filled_size,omitempty"`
+	ExecutedValue float64    `json:"executed_value,omitempty"`
+
+	// AveragePrice is the average price of the fills so far.
+	AveragePrice float64 `json:"average_price,omitempty"`
+
+	DoneReason Reason    `json:"done_reason,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+}
+
+// OrderTransition is a change to a tracked order.
+type OrderTransition struct {
+	// Order is the order as of after the change.
+	Order *TrackedOrder `json:"order"`
+	From  OrderState    `json:"from"`
+
+	// FillSize and FillPrice are set if the order was filled some more.
+	FillSize  float64 `json:"fill_size,omitempty"`
+	FillPrice float64 `json:"fill_price,omitempty"`
+
+	// Polled is set if the change was learnt from
+	// the exchange's REST API instead of the feed.
+	Polled bool `json:"polled,omitempty"`
+}
+
+type OrderTrackerConfig struct {
+	// Hub if set is shared with the tracker, which otherwise
+	// makes its own. It must have been made with Authenticate.
+	Hub *Hub
+
+	// Products are subscribed to right away, for orders
+	// of other products to be subscribed to when placed.
+	Products []string
+
+	// PollInterval is how often orders are polled and the feed
+	// reconnected to while the feed is down, 5s by default.
+	PollInterval time.Duration
+
+	// OnTransition if set is invoked with every transition
+	// of the tracked orders, one at a time and in order.
+	OnTransition func(*OrderTransition)
+}
+
+// OrderTracker places orders and follows them to completion on the user
+// channel of the feed, matching their received messages to them by their
+// CustomOrderID. While the feed is down, the orders are polled instead.
+type OrderTracker struct {
+	client       *Client
+	hub          *Hub
+	ownHub       bool
+	pollInterval time.Duration
+	onTransition func(*OrderTransition)
+
+	// subMu serializes subscribing.
+	subMu sync.Mutex
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	byClientOID map[string]*TrackedOrder
+	byID        map[string]*TrackedOrder
+	products    map[string]bool
+	subs        map[string]*SubscriptionResponse
+	queue       []*OrderTransition
+	closed      bool
+
+	// tradeIDs are the trades already counted as fills, by the
+	// ClientOID of their order, for the fills reported by both
+	// the feed and the exchange's REST API to only count once.
+	tradeIDs map[string]map[int64]bool
+
+	feedDownChan chan bool
+	closeChan    chan bool
+}
+
+const defaultPollInterval = 5 * time.Second
+
+var (
+	errUnauthenticatedHub = errors.New("the order tracker requires a hub made with Authenticate")
+	errTrackerClosed      = errors.New("the order tracker is closed")
+	errDuplicateClientOID = errors.New("an order with the same CustomOrderID is already tracked")
+)
+
+func (c *Client) NewOrderTracker(otc *OrderTrackerConfig) (*OrderTracker, error) {
+	if otc == nil {
+		otc = new(OrderTrackerConfig)
+	}
+	t := &OrderTracker{
+		client:       c,
+		hub:          otc.Hub,
+		pollInterval: otc.PollInterval,
+		onTransition: otc.OnTransition,
+		byClientOID:  make(map[string]*TrackedOrder),
+		byID:         make(map[string]*TrackedOrder),
+		tradeIDs:     make(map[string]map[int64]bool),
+		products:     make(map[string]bool),
+		subs:         make(map[string]*SubscriptionResponse),
+		feedDownChan: make(chan bool, 1),
+		closeChan:    make(chan bool),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	if t.pollInterval <= 0 {
+		t.pollInterval = defaultPollInterval
+	}
+	if t.hub == nil {
+		hub, err := c.NewHub(&HubConfig{Authenticate: true, TypedOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		t.hub, t.ownHub = hub, true
+	} else if !t.hub.cfg.Authenticate {
+		return nil, errUnauthenticatedHub
+	}
+
+	for _, productID := range otc.Products {
+		// Failing to subscribe only means polling until the feed is back.
+		t.watch(productID)
+	}
+	go t.run()
+	go t.notify()
+	return t, nil
+}
+
+// Place places the order, giving it a CustomOrderID if it has
+// none, and tracks it from then on. The returned order is as of
+// when the exchange responded, which may be after it was filled.
+func (t *OrderTracker) Place(o *Order) (*TrackedOrder, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	oc := new(Order)
+	*oc = *o
+	if oc.CustomOrderID == "" {
+		clientOID, err := newClientOID()
+		if err != nil {
+			return nil, err
+		}
+		oc.CustomOrderID = clientOID
+	}
+
+	// The feed must be listened to before placing the order
+	// since its received message may come before the response.
+	t.watch(oc.Product)
+
+	tracked := &TrackedOrder{
+		ClientOID: oc.CustomOrderID,
+		ProductID: oc.Product,
+		Side:      oc.Side,
+		Price:     oc.Price,
+		Size:      oc.Size,
+		Funds:     oc.Funds,
+		State:     OrderStatePending,
+		UpdatedAt: time.Now(),
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, errTrackerClosed
+	}
+	if _, ok := t.byClientOID[tracked.ClientOID]; ok {
+		t.mu.Unlock()
+		return nil, errDuplicateClientOID
+	}
+	t.byClientOID[tracked.ClientOID] = tracked
+	t.mu.Unlock()
+
+	ores, err := t.client.Order(oc)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		delete(t.byClientOID, tracked.ClientOID)
+		return nil, err
+	}
+	t.identify(tracked, ores.ID)
+	t.transition(tracked, OrderStateReceived, 0, 0, true)
+	return tracked.clone(), nil
+}
+
+// Order returns the tracked order with either the server
+// assigned ID or the CustomOrderID, as of the last transition.
+func (t *OrderTracker) Order(id string) (*TrackedOrder, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked, ok := t.byID[id]
+	if !ok {
+		tracked, ok = t.byClientOID[id]
+	}
+	if !ok {
+		return nil, false
+	}
+	return tracked.clone(), true
+}
+
+// Forget stops tracking the order with either the server assigned ID
+// or the CustomOrderID, reporting whether it was tracked. Done orders
+// are otherwise kept for Order to return them, so that long running
+// trackers should forget them once they are done with them.
+func (t *OrderTracker) Forget(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked, ok := t.byID[id]
+	if !ok {
+		tracked, ok = t.byClientOID[id]
+	}
+	if !ok {
+		return false
+	}
+	delete(t.byClientOID, tracked.ClientOID)
+	delete(t.tradeIDs, tracked.ClientOID)
+	if tracked.ID != "" {
+		delete(t.byID, tracked.ID)
+	}
+	return true
+}
+
+// Close stops tracking the orders, after
+// the pending transitions are notified.
+func (t *OrderTracker) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	subs := t.subs
+	t.subs = make(map[string]*SubscriptionResponse)
+	t.cond.Broadcast()
+	t.mu.Unlock()
+
+	close(t.closeChan)
+	for _, sub := range subs {
+		sub.Close()
+	}
+	if t.ownHub {
+		return t.hub.Close()
+	}
+	return nil
+}
+
+func (to *TrackedOrder) clone() *TrackedOrder {
+	clone := new(TrackedOrder)
+	*clone = *to
+	return clone
+}
+
+// newClientOID returns a random UUID, as the exchange expects of client_oid.
+func newClientOID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// identify must be invoked with t.mu held.
+func (t *OrderTracker) identify(tracked *TrackedOrder, id string) {
+	if tracked.ID == "" && id != "" {
+		tracked.ID = id
+		t.byID[id] = tracked
+	}
+}
+
+// fill must be invoked with t.mu held. It reports whether
+// the trade wasn't yet counted as a fill of the order.
+func (t *OrderTracker) fill(tracked *TrackedOrder, tradeID int64) bool {
+	seen := t.tradeIDs[tracked.ClientOID]
+	if seen == nil {
+		seen = make(map[int64]bool)
+		t.tradeIDs[tracked.ClientOID] = seen
+	}
+	if seen[tradeID] {
+		return false
+	}
+	seen[tradeID] = true
+	return true
+}
+
+// transition must be invoked with t.mu held. It moves the order
+// forward to state, if it isn't already past it, and records the fill
+// if any, queueing a transition if either changed the order.
+func (t *OrderTracker) transition(tracked *TrackedOrder, state OrderState, fillSize, fillPrice float64, polled bool) {
+	from := tracked.State
+	if fillSize > 0 {
+		tracked.FilledSize += fillSize
+		tracked.ExecutedValue += fillSize * fillPrice
+		tracked.AveragePrice = tracked.ExecutedValue / tracked.FilledSize
+		if orderStateRanks[state] < orderStateRanks[OrderStatePartiallyFilled] {
+			state = OrderStatePartiallyFilled
+		}
+	}
+	if orderStateRanks[state] > orderStateRanks[from] {
+		tracked.State = state
+	}
+	if tracked.State == from && fillSize <= 0 {
+		return
+	}
+	tracked.UpdatedAt = time.Now()
+	if t.onTransition == nil {
+		return
+	}
+	t.queue = append(t.queue, &OrderTransition{
+		Order:     tracked.clone(),
+		From:      from,
+		FillSize:  fillSize,
+		FillPrice: fillPrice,
+		Polled:    polled,
+	})
+	t.cond.Broadcast()
+}
+
+// apply must be invoked with t.mu held.
+func (t *OrderTracker) apply(fm FeedMessage) {
+	switch m := fm.(type) {
+	case *Received:
+		if tracked, ok := t.byClientOID[m.ClientOID]; ok && m.ClientOID != "" {
+			t.identify(tracked, m.OrderID)
+			t.transition(tracked, OrderStateReceived, 0, 0, false)
+		}
+	case *Open:
+		if tracked, ok := t.byID[m.OrderID]; ok {
+			t.transition(tracked, OrderStateOpen, 0, 0, false)
+		}
+	case *Match:
+		for _, orderID := range []string{m.MakerOrderID, m.TakerOrderID} {
+			if tracked, ok := t.byID[orderID]; ok && t.fill(tracked, m.TradeID) {
+				t.transition(tracked, tracked.State, m.Size, m.Price, false)
+			}
+		}
+	case *Change:
+		if tracked, ok := t.byID[m.OrderID]; ok && m.NewSize > 0 {
+			tracked.Size = m.NewSize
+		}
+	case *Done:
+		if tracked, ok := t.byID[m.OrderID]; ok && tracked.State != OrderStateDone {
+			tracked.DoneReason = m.Reason
+			t.transition(tracked, OrderStateDone, 0, 0, false)
+		}
+	}
+}
+
+// applySnapshot must be invoked with t.mu held. The fills, newest
+// first, must have been listed after the order was looked up.
+func (t *OrderTracker) applySnapshot(tracked *TrackedOrder, ores *OrderResponse, fills []*Fill) {
+	state := OrderStateReceived
+	switch ores.Status {
+	case "open":
+		state = OrderStateOpen
+	case "done":
+		state = OrderStateDone
+		if tracked.State != OrderStateDone {
+			tracked.DoneReason = ores.DoneReason
+		}
+	}
+	// The order only moves to state with its last fill, if any.
+	for i := len(fills) - 1; i >= 0; i-- {
+		if f := fills[i]; t.fill(tracked, f.TradeID) {
+			to := tracked.State
+			if i == 0 {
+				to = state
+			}
+			t.transition(tracked, to, f.Size, f.Price, true)
+		}
+	}
+	t.transition(tracked, state, 0, 0, true)
+}
+
+// watch subscribes to the user channel of the product, if it
+// isn't already. The run loop retries while it fails.
+func (t *OrderTracker) watch(productID string) error {
+	t.subMu.Lock()
+	defer t.subMu.Unlock()
+
+	t.mu.Lock()
+	t.products[productID] = true
+	_, ok := t.subs[productID]
+	t.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	sub, err := t.hub.Subscribe(&HubSubscription{
+		Products:   []string{productID},
+		Channels:   []Channel{ChannelUser},
+		BufferSize: 100,
+	})
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		sub.Close()
+		return errTrackerClosed
+	}
+	t.subs[productID] = sub
+	t.mu.Unlock()
+	go t.follow(productID, sub)
+	return nil
+}
+
+func (t *OrderTracker) follow(productID string, sub *SubscriptionResponse) {
+	for msg := range sub.MessagesChan {
+		// Errors of the connection end the subscription.
+		if msg.Payload == nil {
+			continue
+		}
+		t.mu.Lock()
+		t.apply(msg.Payload)
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	if t.subs[productID] == sub {
+		delete(t.subs, productID)
+	}
+	t.mu.Unlock()
+	select {
+	case t.feedDownChan <- true:
+	default:
+	}
+}
+
+func (t *OrderTracker) run() {
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closeChan:
+			return
+		case <-t.feedDownChan:
+		case <-ticker.C:
+		}
+		// Orders are polled while the feed is down, and once more
+		// when it is back, for what happened in the meantime.
+		if t.resubscribe() {
+			t.poll()
+		}
+	}
+}
+
+// resubscribe reports whether any product wasn't subscribed to.
+func (t *OrderTracker) resubscribe() bool {
+	t.mu.Lock()
+	var missing []string
+	for productID := range t.products {
+		if _, ok := t.subs[productID]; !ok {
+			missing = append(missing, productID)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, productID := range missing {
+		t.watch(productID)
+	}
+	return len(missing) > 0
+}
+
+func (t *OrderTracker) poll() {
+	t.mu.Lock()
+	var pending []*TrackedOrder
+	for _, tracked := range t.byID {
+		if tracked.State != OrderStateDone {
+			pending = append(pending, tracked)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, tracked := range pending {
+		select {
+		case <-t.closeChan:
+			return
+		default:
+		}
+		var fills []*Fill
+		ores, err := t.client.FindOrderByID(tracked.ID)
+		if ae, ok := err.(*APIError); ok && ae.StatusCode == http.StatusNotFound {
+			// Orders canceled without ever being matched are purged.
+			ores, err = &OrderResponse{Status: "done", DoneReason: ReasonCanceled}, nil
+		} else if err == nil {
+			// Fills are counted by trade rather than by the order's
+			// filled size, which may include some from the feed.
+			fills, err = t.client.OrderFills(tracked.ID)
+		}
+		if err != nil {
+			continue
+		}
+		t.mu.Lock()
+		t.applySnapshot(tracked, ores, fills)
+		t.mu.Unlock()
+	}
+}
+
+func (t *OrderTracker) notify() {
+	for {
+		t.mu.Lock()
+		for len(t.queue) == 0 && !t.closed {
+			t.cond.Wait()
+		}
+		queue, closed := t.queue, t.closed
+		t.queue = nil
+		t.mu.Unlock()
+
+		for _, ot := range queue {
+			t.onTransition(ot)
+		}
+		if closed && len(queue) == 0 {
+			return
+		}
+	}
+}
@@ -15,10 +15,15 @@
 package coinbase
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/odeke-em/semalim"
@@ -35,6 +40,11 @@ type CandleStickRequest struct {
 	ThrottleDurationMs int64 `json:"throttle_duration_ms"`
 
 	GranularityInSeconds int `json:"granularity,omitempty"`
+
+	// Ordered if set delivers pages in window order with their
+	// candles sorted ascending by time, drops candles already
+	// delivered and reports the buckets without data as Gaps.
+	Ordered bool `json:"ordered,omitempty"`
 }
 
 type actualCandleStickRequest struct {
@@ -54,14 +64,54 @@ type CandleStickPage struct {
 	CandleSticks []*CandleStick `json:"candlesticks,omitempty"`
 
 	PageNumber int64 `json:"page,omitempty"`
+
+	// Gaps is only set for ordered requests.
+	Gaps []*CandleGap `json:"gaps,omitempty"`
 }
 
-var errBlankProduct = errors.New("expecting a non-blank product")
+// CandleGap is a run of buckets for which the exchange
+// returned no candles. Both StartTime and EndTime are
+// the start times of buckets within the run.
+type CandleGap struct {
+	StartTime time.Time `json:"start,omitempty"`
+	EndTime   time.Time `json:"end,omitempty"`
+}
+
+var (
+	errBlankProduct       = errors.New("expecting a non-blank product")
+	errInvalidGranularity = errors.New("invalid granularity: expecting one of 60, 300, 900, 3600, 21600 or 86400 seconds")
+)
+
+// The granularities, in seconds, that the exchange supports for candles.
+const (
+	GranularityOneMinute      = 60
+	GranularityFiveMinutes    = 300
+	GranularityFifteenMinutes = 900
+	GranularityOneHour        = 3600
+	GranularitySixHours       = 21600
+	GranularityOneDay         = 86400
+)
+
+var allowedGranularities = map[int]bool{
+	GranularityOneMinute:      true,
+	GranularityFiveMinutes:    true,
+	GranularityFifteenMinutes: true,
+	GranularityOneHour:        true,
+	GranularitySixHours:       true,
+	GranularityOneDay:         true,
+}
+
+// maxCandlesPerRequest is the maximum number of
+// candles that the exchange returns per request.
+const maxCandlesPerRequest = 300
 
 func (csr *CandleStickRequest) Validate() error {
 	if csr == nil || csr.Product == "" {
 		return errBlankProduct
 	}
+	if gd := csr.GranularityInSeconds; gd != 0 && !allowedGranularities[gd] {
+		return errInvalidGranularity
+	}
 	return nil
 }
 
@@ -80,18 +130,22 @@ func (c *Client) CandleSticks(ocsr *CandleStickRequest) (*CandleSticksResponse,
 	minStartTime := ocsr.StartTime
 	maxEndTime := ocsr.EndTime
 	maxPageNumber := ocsr.MaxPageNumber
+	granularity := ocsr.GranularityInSeconds
 
 	canPaginateTime := minStartTime.After(zeroTime) && maxEndTime.After(minStartTime)
 
-	// The granularity calculations should calculate
-	// through the pagination duration to avoid.
-	durationIncrement := 5 * time.Hour
-	if gd := ocsr.GranularityInSeconds; gd > 0 && gd <= 30 {
-		durationIncrement = time.Duration(gd) * time.Second
-	}
-
-	if minStartTime.After(zeroTime) && canPaginateTime {
-		maxPageNumber = int64(maxEndTime.Sub(minStartTime) / durationIncrement)
+	// Each page spans at most maxCandlesPerRequest buckets. The exchange
+	// includes the buckets at both the start and end times, so a window
+	// ends one bucket before the start of the next to avoid duplicates.
+	var step, window time.Duration
+	if canPaginateTime {
+		if granularity == 0 {
+			granularity = GranularityOneMinute
+		}
+		step = time.Duration(granularity) * time.Second
+		window = maxCandlesPerRequest * step
+		// Align to bucket boundaries so that no bucket straddles two windows.
+		minStartTime = minStartTime.Truncate(step)
 	}
 	shouldTerminate := func(startTime, endTime time.Time, pageNumber int64) bool {
 		// If startTime is not defined or endTime is not
@@ -99,14 +153,14 @@ func (c *Client) CandleSticks(ocsr *CandleStickRequest) (*CandleSticksResponse,
 		// https://api.gdax.com/products/ETH-USD/candles?end=2017-09-02T16:50:20.00000Z
 		// https://api.gdax.com/products/ETH-USD/candles?start=2017-09-02T15:25:00.00000Z
 		// just return a single page
-		if minStartTime.Equal(zeroTime) || maxEndTime.Equal(zeroTime) {
+		if !canPaginateTime {
 			return true
 		}
-		if startTime.After(maxEndTime) || endTime.After(maxEndTime) {
+		if !endTime.Before(maxEndTime) {
 			return true
 		}
 
-		// Otherwise just paginate by maxPageNumber
+		// Otherwise paginate until the end time or maxPageNumber.
 		return maxPageNumber > 0 && pageNumber >= maxPageNumber
 	}
 
@@ -125,36 +179,53 @@ func (c *Client) CandleSticks(ocsr *CandleStickRequest) (*CandleSticksResponse,
 		}
 
 		startTime, endTime := ocsr.StartTime, ocsr.EndTime
+		windowEnd := func(startTime time.Time) time.Time {
+			endTime := startTime.Add(window - step)
+			if endTime.After(maxEndTime) {
+				endTime = maxEndTime
+			}
+			return endTime
+		}
 		if canPaginateTime {
-			// Now setup the startTime for increments
-			endTime = startTime.Add(durationIncrement)
+			startTime = minStartTime
+			endTime = windowEnd(startTime)
 		}
 
 		csr := new(actualCandleStickRequest)
 		csr.Product = ocsr.Product
+		csr.GranularityInSeconds = granularity
 		pageNumber := int64(0)
 
+		var windowsMu sync.Mutex
+		windows := make(map[int64][2]time.Time)
+
 		jobsChan := make(chan semalim.Job)
 		go func() {
 			defer close(jobsChan)
 
 			for {
-				csr.StartTime = iso8601(startTime)
-				csr.EndTime = iso8601(endTime)
+				if !startTime.IsZero() {
+					csr.StartTime = iso8601(startTime)
+				}
+				if !endTime.IsZero() {
+					csr.EndTime = iso8601(endTime)
+				}
 
 				ccsr := new(actualCandleStickRequest)
 				*ccsr = *csr
 				pageNumber += 1
+				windowsMu.Lock()
+				windows[pageNumber] = [2]time.Time{startTime, endTime}
+				windowsMu.Unlock()
 				jobsChan <- &candleStickGetter{id: pageNumber, csr: ccsr, client: c}
 
 				if shouldTerminate(startTime, endTime, pageNumber) {
 					return
 				}
 
-				// Otherwise, now change the startDate
-				newEndTime := endTime.Add(durationIncrement)
-				startTime = endTime
-				endTime = newEndTime
+				// Otherwise, move on to the next window.
+				startTime = startTime.Add(window)
+				endTime = windowEnd(startTime)
 
 				select {
 				case <-time.After(throttleDuration):
@@ -164,16 +235,32 @@ func (c *Client) CandleSticks(ocsr *CandleStickRequest) (*CandleSticksResponse,
 			}
 		}()
 
+		var ordered *candleOrderer
+		if ocsr.Ordered {
+			ordered = newCandleOrderer(time.Duration(granularity) * time.Second)
+		}
+
 		resChan := semalim.Run(jobsChan, 4)
 		for res := range resChan {
 			val, err, pageNumber := res.Value(), res.Err(), res.Id().(int64)
 			csPage := new(CandleStickPage)
 			csPage.Err = err
 			csPage.PageNumber = pageNumber
+			windowsMu.Lock()
+			window := windows[pageNumber]
+			delete(windows, pageNumber)
+			windowsMu.Unlock()
+			csPage.StartTime, csPage.EndTime = window[0], window[1]
 			if val != nil {
 				csPage.CandleSticks = val.([]*CandleStick)
 			}
-			cspChan <- csPage
+			if ordered == nil {
+				cspChan <- csPage
+				continue
+			}
+			for _, page := range ordered.add(csPage) {
+				cspChan <- page
+			}
 		}
 	}()
 
@@ -185,6 +272,106 @@ func (c *Client) CandleSticks(ocsr *CandleStickRequest) (*CandleSticksResponse,
 	return csRes, nil
 }
 
+// candleOrderer buffers pages that arrive out of order
+// and releases them in page order, cleaned up.
+type candleOrderer struct {
+	step     time.Duration
+	next     int64
+	pending  map[int64]*CandleStickPage
+	lastTime float64
+	started  bool
+}
+
+func newCandleOrderer(step time.Duration) *candleOrderer {
+	return &candleOrderer{step: step, next: 1, pending: make(map[int64]*CandleStickPage)}
+}
+
+// add buffers page and returns the pages that are now ready.
+func (co *candleOrderer) add(page *CandleStickPage) []*CandleStickPage {
+	co.pending[page.PageNumber] = page
+	var ready []*CandleStickPage
+	for {
+		page, ok := co.pending[co.next]
+		if !ok {
+			return ready
+		}
+		delete(co.pending, co.next)
+		co.next += 1
+		if page.Err == nil {
+			co.clean(page)
+		}
+		ready = append(ready, page)
+	}
+}
+
+func (co *candleOrderer) clean(page *CandleStickPage) {
+	sort.Slice(page.CandleSticks, func(i, j int) bool {
+		return page.CandleSticks[i].Time < page.CandleSticks[j].Time
+	})
+	// Buckets up to the last delivered candle aren't gaps.
+	delivered, deliveredUntil := co.started, (&CandleStick{Time: co.lastTime}).Timestamp()
+	candles := page.CandleSticks[:0]
+	for _, cs := range page.CandleSticks {
+		if co.started && cs.Time <= co.lastTime {
+			continue
+		}
+		co.started = true
+		co.lastTime = cs.Time
+		candles = append(candles, cs)
+	}
+	page.CandleSticks = candles
+	page.Gaps = co.gaps(page)
+	for len(page.Gaps) > 0 && delivered && !page.Gaps[0].StartTime.After(deliveredUntil) {
+		gap := page.Gaps[0]
+		if gap.EndTime.After(deliveredUntil) {
+			gap.StartTime = deliveredUntil.Add(co.step)
+			break
+		}
+		page.Gaps = page.Gaps[1:]
+	}
+	if len(page.Gaps) == 0 {
+		page.Gaps = nil
+	}
+}
+
+// gaps finds the buckets missing from the page's window or, if the
+// window isn't known, those missing between its first and last candles.
+func (co *candleOrderer) gaps(page *CandleStickPage) []*CandleGap {
+	if co.step <= 0 {
+		return nil
+	}
+	start, end := page.StartTime, page.EndTime
+	if n := len(page.CandleSticks); n > 0 {
+		if start.IsZero() {
+			start = page.CandleSticks[0].Timestamp()
+		}
+		if end.IsZero() {
+			end = page.CandleSticks[n-1].Timestamp()
+		}
+	}
+	if start.IsZero() || end.IsZero() {
+		return nil
+	}
+	start, end = start.Truncate(co.step), end.Truncate(co.step)
+
+	var gaps []*CandleGap
+	candles := page.CandleSticks
+	for t := start; !t.After(end); t = t.Add(co.step) {
+		for len(candles) > 0 && candles[0].Timestamp().Before(t) {
+			candles = candles[1:]
+		}
+		if len(candles) > 0 && candles[0].Timestamp().Equal(t) {
+			continue
+		}
+		if n := len(gaps); n > 0 && gaps[n-1].EndTime.Add(co.step).Equal(t) {
+			gaps[n-1].EndTime = t
+		} else {
+			gaps = append(gaps, &CandleGap{StartTime: t, EndTime: t})
+		}
+	}
+	return gaps
+}
+
 type CandleStick struct {
 	Time   float64 `json:"time,omitempty"`
 	High   float64 `json:"high,omitempty"`
@@ -196,7 +383,27 @@ type CandleStick struct {
 
 var errInvalidCandleStickOriginalJSON = errors.New("expecting data of the form: [time, low, high, open, close, volume]")
 
+// Timestamp returns the start time of the candle's bucket.
+func (cs *CandleStick) Timestamp() time.Time {
+	return time.Unix(int64(cs.Time), 0).UTC()
+}
+
+// candleStickObject has the same fields as CandleStick
+// but none of its methods, to avoid recursive (un)marshaling.
+type candleStickObject CandleStick
+
+// MarshalJSON emits the object form of a CandleStick rather
+// than the exchange's array form, for readability downstream.
+func (cs CandleStick) MarshalJSON() ([]byte, error) {
+	return json.Marshal(candleStickObject(cs))
+}
+
 func (cs *CandleStick) UnmarshalJSON(b []byte) error {
+	// Accept the object form that MarshalJSON emits.
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		return json.Unmarshal(b, (*candleStickObject)(cs))
+	}
+
 	var recv []float64
 	if err := json.Unmarshal(b, &recv); err != nil {
 		return err
@@ -208,8 +415,8 @@ func (cs *CandleStick) UnmarshalJSON(b []byte) error {
 	}
 
 	cs.Time = recv[0]
-	cs.High = recv[1]
-	cs.Low = recv[2]
+	cs.Low = recv[1]
+	cs.High = recv[2]
 	cs.Open = recv[3]
 	cs.Close = recv[4]
 	cs.Volume = recv[5]
@@ -244,7 +451,7 @@ func (csg *candleStickGetter) Do() (interface{}, error) {
 		return nil, err
 	}
 
-	blob, _, err := client.doHTTPReq(req)
+	blob, _, err := client.doPublicReq("CandleSticks", req)
 	if err != nil {
 		return nil, err
 	}
@@ -258,10 +465,186 @@ func (csg *candleStickGetter) Do() (interface{}, error) {
 
 var _ semalim.Job = (*candleStickGetter)(nil)
 
+type Trade struct {
+	TradeID int64     `json:"trade_id,omitempty"`
+	Price   float64   `json:"price,omitempty"`
+	Size    float64   `json:"size,omitempty"`
+	Side    Side      `json:"side,omitempty"`
+	Time    time.Time `json:"time,omitempty"`
+}
+
+// rawTrade is the wire form of a Trade whose float values are strings.
+type rawTrade struct {
+	TradeID int64     `json:"trade_id"`
+	Price   float64   `json:"price,string,omitempty"`
+	Size    float64   `json:"size,string,omitempty"`
+	Side    Side      `json:"side,omitempty"`
+	Time    time.Time `json:"time,omitempty"`
+}
+
+type TradesRequest struct {
+	Product string `json:"product,omitempty"`
+
+	// SinceTradeID if set lists the trades after it, oldest page
+	// first and with each page in ascending order, for incremental
+	// backfills. Otherwise trades are listed newest first.
+	SinceTradeID int64 `json:"since_trade_id,omitempty"`
+
+	TradesPerPage int64 `json:"trades_per_page,omitempty"`
+	MaxPage       int64 `json:"max_page,omitempty"`
+
+	ThrottleDurationMs int64 `json:"throttle_duration_ms"`
+}
+
+type TradesPage struct {
+	Trades     []*Trade `json:"trades,omitempty"`
+	PageNumber int64    `json:"page_number,omitempty"`
+
+	// Before and After are the exchange's cursors for
+	// the pages of newer and older trades respectively.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+
+	Err error `json:"error,omitempty"`
+}
+
+type TradesResponse struct {
+	Cancel    func() error
+	PagesChan chan *TradesPage
+}
+
+// maxTradesPerPage is the exchange's limit of trades per request.
+const maxTradesPerPage = 100
+
+var errInvalidTradesPerPage = errors.New("expecting trades per page of at most 100")
+
+func (treq *TradesRequest) Validate() error {
+	if treq == nil || strings.TrimSpace(treq.Product) == "" {
+		return errBlankProduct
+	}
+	if treq.TradesPerPage > maxTradesPerPage {
+		return errInvalidTradesPerPage
+	}
+	return nil
+}
+
+// ListTrades pages through the public trades of a product using the
+// exchange's CB-BEFORE and CB-AFTER cursors.
+func (c *Client) ListTrades(treq *TradesRequest) (*TradesResponse, error) {
+	if err := treq.Validate(); err != nil {
+		return nil, err
+	}
+
+	limit := treq.TradesPerPage
+	if limit <= 0 {
+		limit = maxTradesPerPage
+	}
+	forward := treq.SinceTradeID > 0
+
+	pagesChan := make(chan *TradesPage)
+	cancelChan, cancelFn := makeCanceler()
+	go func() {
+		defer close(pagesChan)
+
+		var throttleDuration time.Duration
+		if treq.ThrottleDurationMs != NoThrottle {
+			if treq.ThrottleDurationMs > 0 {
+				throttleDuration = time.Duration(treq.ThrottleDurationMs) * time.Millisecond
+			} else {
+				throttleDuration = 350 * time.Millisecond
+			}
+		}
+
+		baseURL := fmt.Sprintf("https://api.gdax.com/products/%s/trades", strings.TrimSpace(treq.Product))
+		var cursor string
+		if forward {
+			cursor = fmt.Sprintf("%d", treq.SinceTradeID)
+		}
+
+		for pageNumber := int64(1); ; pageNumber++ {
+			qv := make(url.Values)
+			qv.Set("limit", fmt.Sprintf("%d", limit))
+			if cursor != "" {
+				if forward {
+					qv.Set("before", cursor)
+				} else {
+					qv.Set("after", cursor)
+				}
+			}
+
+			page := &TradesPage{PageNumber: pageNumber}
+			trades, hdr, err := c.fetchTrades(baseURL + "?" + qv.Encode())
+			if err != nil {
+				page.Err = err
+				pagesChan <- page
+				return
+			}
+			page.Trades = trades
+			page.Before, page.After = hdr.Get("CB-BEFORE"), hdr.Get("CB-AFTER")
+			if forward {
+				// The exchange sends each page newest first.
+				sort.Slice(trades, func(i, j int) bool { return trades[i].TradeID < trades[j].TradeID })
+			}
+
+			select {
+			case pagesChan <- page:
+			case <-cancelChan:
+				return
+			}
+
+			// A short page means that there are no more trades.
+			if int64(len(trades)) < limit || (treq.MaxPage > 0 && pageNumber >= treq.MaxPage) {
+				return
+			}
+			if forward {
+				cursor = page.Before
+			} else {
+				cursor = page.After
+			}
+			if cursor == "" {
+				return
+			}
+
+			select {
+			case <-time.After(throttleDuration):
+			case <-cancelChan:
+				return
+			}
+		}
+	}()
+
+	tres := &TradesResponse{
+		Cancel:    cancelFn,
+		PagesChan: pagesChan,
+	}
+
+	return tres, nil
+}
+
+func (c *Client) fetchTrades(fullURL string) ([]*Trade, http.Header, error) {
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	blob, hdr, err := c.doPublicReq("ListTrades", req)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rtrades []*rawTrade
+	if err := json.Unmarshal(blob, &rtrades); err != nil {
+		return nil, nil, err
+	}
+	trades := make([]*Trade, 0, len(rtrades))
+	for _, rt := range rtrades {
+		trades = append(trades, (*Trade)(rt))
+	}
+	return trades, hdr, nil
+}
+
 // iso8601 formats time into the ISO 8601 format of sample:
 //   2017-09-02T15:25:00.00000Z
 func iso8601(t time.Time) string {
-	return t.Format("2006-01-02T15:04:05.00000Z")
+	return t.UTC().Format("2006-01-02T15:04:05.00000Z")
 }
 
 /*
@@ -65,7 +65,7 @@ func (c *Client) MyProfile() (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return c.fetchProfile(req)
+	return c.fetchProfile("MyProfile", req)
 }
 
 func (c *Client) FindProfileByID(profileID string) (*Profile, error) {
@@ -79,15 +79,15 @@ func (c *Client) FindProfileByID(profileID string) (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return c.fetchProfile(req)
+	return c.fetchProfile("FindProfileByID", req)
 }
 
 type profileWrap struct {
 	Profile *Profile `json:"data"`
 }
 
-func (c *Client) fetchProfile(req *http.Request) (*Profile, error) {
-	slurp, _, err := c.doAuthAndReq(req)
+func (c *Client) fetchProfile(name string, req *http.Request) (*Profile, error) {
+	slurp, _, err := c.doAuthAndReq(name, req)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package coinbase
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -19,6 +20,14 @@ const (
 	TypeActivate  Type = "activate"
 	TypeEntry     Type = "entry"
 	TypeHeartbeat Type = "heartbeat"
+	TypeMatch     Type = "match"
+	TypeLastMatch Type = "last_match"
+	TypeDone      Type = "done"
+	TypeChange    Type = "change"
+	TypeTicker    Type = "ticker"
+	TypeL2Update  Type = "l2update"
+	TypeError     Type = "error"
+	TypeSnapshot  Type = "snapshot"
 )
 
 type Side string
@@ -37,7 +46,7 @@ type Message struct {
 	Size           float64   `json:"size,string,omitempty"`
 	Price          float64   `json:"price,string,omitempty"`
 	OrderType      string    `json:"order_type,omitempty"`
-	Funds          float64   `json:"funds,strings,omitempty"`
+	Funds          float64   `json:"funds,string,omitempty"`
 	Side           Side      `json:"side,omitempty"`
 	RemainingSize  float64   `json:"remaining_size,string,omitempty"`
 	Reason         Reason    `json:"reason,omitempty"`
@@ -63,10 +72,10 @@ type Message struct {
 	QuoteFunding   float64   `json:"quote_funding,string,omitempty"`
 	Private        bool      `json:"private,omitempty"`
 
-	StopPrice    float64 `json:"stop_price,omitempty"`
+	StopPrice    float64 `json:"stop_price,string,omitempty"`
 	StopType     Type    `json:"stop_type,omitempty"`
 	TakerFeeRate float64 `json:"taker_fee_rate,string,omitempty"`
-	LastTradeID  string  `json:"last_trade_id,omitempty"`
+	LastTradeID  int64   `json:"last_trade_id,omitempty"`
 
 	Message string `json:"message,omitempty"`
 
@@ -77,6 +86,45 @@ type Message struct {
 	UserID         string `json:"user_id,omitempty"`
 
 	Err error `json:"err,omitempty"`
+
+	// ReceivedAt is when the message's frame was received.
+	ReceivedAt time.Time `json:"-"`
+
+	// RawFrame is the frame as it was received, only
+	// kept if the subscription asked for KeepRawFrames.
+	RawFrame json.RawMessage `json:"-"`
+
+	// Payload is the typed message e.g. a *Match,
+	// to be told apart from others with a type switch.
+	Payload FeedMessage `json:"-"`
+}
+
+// ParseMessage decodes a frame of the websocket feed into both
+// the flat fields of a Message and its typed Payload. Like json.Unmarshal,
+// it returns what it could decode alongside any error.
+func ParseMessage(frame []byte) (*Message, error) {
+	return parseMessage(frame, true)
+}
+
+// parseMessage decodes the flat fields of the message, which
+// hold every field of every type of message, only if flat is set.
+// Otherwise only the fields common to all types are set.
+func parseMessage(frame []byte, flat bool) (*Message, error) {
+	msg := new(Message)
+	payload, err := ParseFeedMessage(frame)
+	if err == nil {
+		msg.Payload = payload
+	}
+	if flat {
+		// Only the typed payload's errors are reported, as the
+		// flat fields are a best effort for every type at once.
+		json.Unmarshal(frame, msg)
+	} else if payload != nil {
+		header := payload.Header()
+		msg.Type, msg.Time, msg.ProductID = header.Type, header.Time, header.ProductID
+		msg.SequenceNumber = int(header.Sequence)
+	}
+	return msg, err
 }
 
 type Reason string
@@ -89,11 +137,38 @@ const (
 type Subscription struct {
 	Authenticate bool     `json:"authenticate,omitempty"`
 	Currencies   []string `json:"currencies,omitempty"`
+
+	// KeepRawFrames if set keeps every message's
+	// frame in its RawFrame e.g. for recording.
+	KeepRawFrames bool `json:"keep_raw_frames,omitempty"`
+
+	// TypedOnly if set only decodes messages into their Payload
+	// and the fields common to all types, skipping the rest of
+	// the flat fields of Message which are kept for compatibility.
+	TypedOnly bool `json:"typed_only,omitempty"`
+
+	// BufferSize is how many messages are buffered
+	// for a consumer that falls behind, 1 by default.
+	BufferSize int `json:"buffer_size,omitempty"`
+
+	// Backpressure is what happens once the buffer
+	// is full, BackpressureBlock by default.
+	Backpressure BackpressurePolicy `json:"backpressure,omitempty"`
 }
 
 type SubscriptionResponse struct {
 	MessagesChan <-chan *Message
 	cancelFn     func() error
+
+	// buffer is only set for buffered subscriptions.
+	buffer *messageBuffer
+}
+
+// NewSubscriptionResponse wraps messages that don't come from
+// the exchange's feed e.g. replays, where closeFn if non-nil
+// is invoked by Close and should stop sending messages.
+func NewSubscriptionResponse(msgsChan <-chan *Message, closeFn func() error) *SubscriptionResponse {
+	return &SubscriptionResponse{MessagesChan: msgsChan, cancelFn: closeFn}
 }
 
 func (sr *SubscriptionResponse) Close() error {
@@ -104,8 +179,9 @@ func (sr *SubscriptionResponse) Close() error {
 }
 
 type subscribeMessage struct {
-	Type       string   `json:"type,omitempty"`
-	ProductIDs []string `json:"product_ids,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	ProductIDs []string  `json:"product_ids,omitempty"`
+	Channels   []Channel `json:"channels,omitempty"`
 
 	// The fields below are necessary when making
 	// an authenticated subscription for products.
@@ -125,17 +201,113 @@ const (
 	websocketFeedURL = "wss://ws-feed.gdax.com"
 )
 
+// SetFeedURL makes the client subscribe to the websocket feed
+// at feedURL e.g. the sandbox or a fake exchange in tests.
+// Passing in "" restores the default feed.
+func (c *Client) SetFeedURL(feedURL string) {
+	c.mu.Lock()
+	c.wsFeedURL = feedURL
+	c.mu.Unlock()
+}
+
+func (c *Client) feedURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.wsFeedURL != "" {
+		return c.wsFeedURL
+	}
+	return websocketFeedURL
+}
+
+// FeedConn is a connection to the websocket feed, like those of wsu.
+type FeedConn interface {
+	Send(*wsu.Message)
+	Receive() (*wsu.Message, bool)
+	Close() error
+}
+
+// SetFeedDialer makes the client connect to the websocket feed
+// with dial e.g. to reach a fake exchange in memory in tests.
+// Passing in nil restores connecting with wsu.
+func (c *Client) SetFeedDialer(dial func(feedURL string) (FeedConn, error)) {
+	c.mu.Lock()
+	c.feedDialer = dial
+	c.mu.Unlock()
+}
+
+func (c *Client) dialFeed() (FeedConn, error) {
+	c.mu.RLock()
+	dial := c.feedDialer
+	c.mu.RUnlock()
+	if dial != nil {
+		return dial(c.feedURL())
+	}
+	wsConn, err := wsu.NewClientConnection(&wsu.ClientSetup{URL: c.feedURL()})
+	if err != nil {
+		return nil, err
+	}
+	return wsConn, nil
+}
+
+// signSubscription sets the fields that authenticate sm, which the
+// exchange expects to be those of a signed request for /users/self.
+// A custom Signer set with SetSigner signs it like any other request.
+func (c *Client) signSubscription(sm *subscribeMessage) error {
+	fullURL := fmt.Sprintf("%s/users/self", unversionedBaseURL)
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return err
+	}
+	signer, authKind := c.getSigner()
+	if authKind == AuthOAuth2 {
+		return errOAuth2Subscription
+	}
+	if err := signer.Sign(req); err != nil {
+		return err
+	}
+	hdr := req.Header
+	sm.Signature = hdr.Get(hdrSignatureKey)
+	sm.Timestamp = hdr.Get(hdrTimestampKey)
+	sm.APIKey = hdr.Get(hdrAPIKeyKey)
+	sm.Passphrase = hdr.Get(hdrPassphraseKey)
+	return nil
+}
+
+// decodeFrame turns what was received from the feed into a Message.
+func decodeFrame(recvMsg *wsu.Message, keepRawFrames, typedOnly bool) *Message {
+	receivedAt := time.Now()
+	msg := new(Message)
+	if err := recvMsg.Err; err != nil {
+		msg.Err = err
+	} else {
+		// Assigning msg and msg.Err together would set
+		// the Err of the discarded message instead.
+		parsed, err := parseMessage(recvMsg.Frame, !typedOnly)
+		msg = parsed
+		msg.Err = err
+	}
+	msg.ReceivedAt = receivedAt
+	if keepRawFrames && recvMsg.Frame != nil {
+		msg.RawFrame = append(json.RawMessage(nil), recvMsg.Frame...)
+	}
+	return msg
+}
+
+var errOAuth2Subscription = errors.New("authenticated subscriptions require API key credentials, not OAuth2")
+
 func (c *Client) Subscribe(sin *Subscription) (*SubscriptionResponse, error) {
 	if sin == nil {
 		sin = new(Subscription)
 	}
-
-	wsConn, err := wsu.NewClientConnection(&wsu.ClientSetup{
-		URL: websocketFeedURL,
-	})
-	if err != nil {
+	if sin.Authenticate && c.getTokenSource() != nil {
+		return nil, errOAuth2Subscription
+	}
+	if err := sin.Backpressure.Validate(); err != nil {
 		return nil, err
 	}
+	if sin.BufferSize < 0 {
+		return nil, errNegativeBufferSize
+	}
 
 	s := new(Subscription)
 	*s = *sin
@@ -147,24 +319,20 @@ func (c *Client) Subscribe(sin *Subscription) (*SubscriptionResponse, error) {
 		Type:       "subscribe",
 		ProductIDs: s.Currencies[:],
 	}
-
 	if s.Authenticate {
-		fullURL := fmt.Sprintf("%s/users/self", unversionedBaseURL)
-		req, err := http.NewRequest("GET", fullURL, nil)
-		if err != nil {
+		if err := c.signSubscription(sm); err != nil {
 			return nil, err
 		}
-		c.signAndSetHeaders(req)
-		hdr := req.Header
-		sm.Signature = hdr.Get(hdrSignatureKey)
-		sm.Timestamp = hdr.Get(hdrTimestampKey)
-		sm.APIKey = hdr.Get(hdrAPIKeyKey)
 	}
-
 	subscriptionBlob, err := json.Marshal(sm)
 	if err != nil {
 		return nil, err
 	}
+
+	wsConn, err := c.dialFeed()
+	if err != nil {
+		return nil, err
+	}
 	// Send that subscription blob to kick off the entire process.
 	wsConn.Send(&wsu.Message{Frame: subscriptionBlob})
 
@@ -177,14 +345,7 @@ func (c *Client) Subscribe(sin *Subscription) (*SubscriptionResponse, error) {
 			if !ok {
 				return
 			}
-			msg := new(Message)
-
-			if err := recvMsg.Err; err != nil {
-				msg.Err = err
-			} else if err := json.Unmarshal(recvMsg.Frame, msg); err != nil {
-				msg.Err = err
-			}
-			msgsChan <- msg
+			msgsChan <- decodeFrame(recvMsg, s.KeepRawFrames, s.TypedOnly)
 		}
 	}()
 
@@ -193,7 +354,10 @@ func (c *Client) Subscribe(sin *Subscription) (*SubscriptionResponse, error) {
 		MessagesChan: msgsChan,
 	}
 
-	return sres, nil
+	// Buffering keeps a slow consumer from stalling the
+	// reader, unless the policy is to block, and counts
+	// what happens to messages.
+	return BufferMessages(sres, s.BufferSize, s.Backpressure)
 }
 
 const (